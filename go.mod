@@ -9,6 +9,7 @@ require (
 	github.com/fatih/color v1.18.0
 	github.com/sirupsen/logrus v1.9.3
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
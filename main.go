@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/dchest/uniuri"
 	"github.com/fatih/color"
@@ -60,6 +63,11 @@ func main() {
 		}
 	}
 
+	// Unknown block types are looked up as plugins in this directory first
+	if cfg.PluginDir != "" {
+		cli.SetPluginDirs(cfg.PluginDir)
+	}
+
 	// Handle different modes
 	var err error
 	switch {
@@ -102,6 +110,24 @@ func validateConfig(cfg *config.Config) error {
 		validator.ValidateAIModel(cfg.AIModel)
 	}
 
+	// Validate number of parallel command blocks
+	if cfg.Parallel < 0 {
+		validator.AddError(errors.NewValidationError(
+			fmt.Sprintf("Invalid parallel value: %d (must not be negative)", cfg.Parallel),
+			"parallel",
+			cfg.Parallel,
+		))
+	}
+
+	// Validate workflow timeout
+	if cfg.Timeout < 0 {
+		validator.AddError(errors.NewValidationError(
+			fmt.Sprintf("Invalid timeout: %s (must not be negative)", cfg.Timeout),
+			"timeout",
+			cfg.Timeout,
+		))
+	}
+
 	// Validate shell type
 	if cfg.ShellType != "" {
 		validator.ValidateShellType(cfg.ShellType)
@@ -180,15 +206,54 @@ func handleFileExecution(cfg *config.Config) error {
 			WithSuggestion("Ensure the file exists and you have read permissions")
 	}
 
-	// Validate YAML structure
-	var ydoc map[interface{}][]interface{}
-	if err := yaml.Unmarshal(ydata, &ydoc); err != nil {
+	// Validate YAML structure, unknown keys and wrong types are reported
+	// with their position
+	if _, err := cli.ParseWorkflow(ydata, cfg.File); err != nil {
 		return errors.NewYAMLError("Failed to parse YAML structure", err, cfg.File).
-			WithSuggestion("Validate your YAML syntax using a YAML validator")
+			WithSuggestion("Check the reported keys and values against the syntax in the README")
 	}
 
+	// Cancel the run on Ctrl-C or SIGTERM so that running commands are killed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Execute commands with error handling
-	if err := cli.Runfromyaml(ydata, cfg.Debug); err != nil {
+	opts := cli.RunOptions{
+		Debug:     cfg.Debug,
+		File:      cfg.File,
+		Parallel:  cfg.Parallel,
+		Timeout:   cfg.Timeout,
+		DryRun:    cfg.DryRun,
+		StateFile: cfg.StateFile,
+		Resume:    cfg.Resume,
+		Selection: cli.Selection{
+			Only:  cli.SplitSelection(cfg.Only),
+			Skip:  cli.SplitSelection(cfg.Skip),
+			From:  cfg.From,
+			Until: cfg.Until,
+		},
+	}
+	// Run state is only recorded when asked for, next to the workflow by default
+	if opts.StateFile == "" && opts.Resume {
+		opts.StateFile = cfg.File + cli.StateFileSuffix
+	}
+
+	// Settings that don't fit their block, like a retry without attempts,
+	// are reported as such before anything runs
+	if err := cli.ValidateWorkflow(ydata, opts); err != nil {
+		if rfyErr, ok := err.(*errors.RunFromYAMLError); ok {
+			return rfyErr
+		}
+		return errors.Wrap(err, errors.ErrorTypeValidation, "Invalid workflow").
+			WithContext("filename", cfg.File).
+			WithSuggestion("Check the reported block against the syntax in the README")
+	}
+
+	if err := cli.RunfromyamlWithOptions(ctx, ydata, opts); err != nil {
+		// Invalid block selections are reported with their suggestions
+		if rfyErr, ok := err.(*errors.RunFromYAMLError); ok {
+			return rfyErr
+		}
 		return errors.NewExecutionError("Failed to execute commands from YAML file", err, cfg.File)
 	}
 
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/lanixx/runfromyaml/pkg/config"
+	"github.com/lanixx/runfromyaml/pkg/errors"
 )
 
 func TestValidateConfig(t *testing.T) {
@@ -195,3 +196,39 @@ cmd:
 		t.Error("Test YAML file should exist")
 	}
 }
+
+func TestHandleFileExecutionInvalidSettings(t *testing.T) {
+	tempDir := t.TempDir()
+	marker := filepath.Join(tempDir, "ran")
+
+	tests := []struct {
+		name     string
+		retry    string
+		wantType errors.ErrorType
+		want     string
+	}{
+		{"unknown key", "{attemps: 3}", errors.ErrorTypeYAML, `unknown key "attemps" in retry of command block 2, did you mean "attempts"?`},
+		{"invalid value", "{attempts: 0}", errors.ErrorTypeValidation, "retry attempts must be a number greater than 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yamlFile := filepath.Join(tempDir, "commands.yaml")
+			yamlContent := "cmd:\n  - type: exec\n    values: [touch, " + marker + "]\n  - type: exec\n    values: [true]\n    retry: " + tt.retry + "\n"
+			if err := os.WriteFile(yamlFile, []byte(yamlContent), 0644); err != nil {
+				t.Fatalf("Failed to create test YAML file: %v", err)
+			}
+
+			cfg := config.New()
+			cfg.File = yamlFile
+			err := handleFileExecution(cfg)
+
+			rfyErr, ok := err.(*errors.RunFromYAMLError)
+			if !ok || rfyErr.Type != tt.wantType || !strings.Contains(rfyErr.Error(), tt.want) {
+				t.Errorf("handleFileExecution() error = %v, want a %s error containing %q", err, tt.wantType, tt.want)
+			}
+			if fileExists(marker) {
+				t.Error("handleFileExecution() ran a block of an invalid workflow")
+			}
+		})
+	}
+}
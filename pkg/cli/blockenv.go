@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// parseBlockEnv converts the env option of a command block. It takes the
+// key and value entries of the workflow env section as well as a mapping.
+func parseBlockEnv(value interface{}) (map[string]string, error) {
+	vars := make(map[string]string)
+	add := func(key, value interface{}) error {
+		name := fmt.Sprint(key)
+		if !registerNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid variable name %q: must be a valid environment variable name", name)
+		}
+		text := ""
+		if value != nil {
+			text = fmt.Sprint(value)
+		}
+		vars[name] = text
+		return nil
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case map[interface{}]interface{}:
+		for key, value := range v {
+			if err := add(key, value); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for _, entry := range v {
+			entryMap, ok := entry.(map[interface{}]interface{})
+			if !ok || entryMap["key"] == nil {
+				return nil, fmt.Errorf("env entries must have a key and a value")
+			}
+			if err := add(entryMap["key"], entryMap["value"]); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("env must be a list of key and value entries or a mapping")
+	}
+	return vars, nil
+}
+
+// lookupEnv returns a variable as seen by a block: its own env comes first,
+// followed by the workflow environment and, unless that is isolated, the
+// process environment
+func (e *CommandExecutor) lookupEnv(cmd *Command, key string) (string, bool) {
+	if value, ok := cmd.EnvVars[key]; ok {
+		return value, true
+	}
+	if e.config.Env != nil {
+		return e.config.Env.resolve(key)
+	}
+	return os.LookupEnv(key)
+}
+
+// expandEnv replaces $var and ${var} like os.ExpandEnv, using the variables
+// seen by the block
+func (e *CommandExecutor) expandEnv(cmd *Command, s string) string {
+	return os.Expand(s, func(key string) string {
+		value, _ := e.lookupEnv(cmd, key)
+		return value
+	})
+}
+
+// expandCommand returns a copy of a block with expandenv in which its env,
+// values and script are expanded. This happens when the block runs, so the
+// variables registered by earlier blocks and the loop variables are seen.
+func (e *CommandExecutor) expandCommand(cmd *Command) *Command {
+	if !cmd.ExpandEnv {
+		return cmd
+	}
+
+	expanded := *cmd
+	if cmd.EnvVars != nil {
+		// The env of the block is expanded with the workflow environment only
+		workflow := *cmd
+		workflow.EnvVars = nil
+		expanded.EnvVars = make(map[string]string, len(cmd.EnvVars))
+		for key, value := range cmd.EnvVars {
+			expanded.EnvVars[key] = e.expandEnv(&workflow, value)
+		}
+	}
+	expand := func(s string) string { return e.expandEnv(&expanded, s) }
+	expanded.Values = expandAll(cmd.Values, expand)
+	expanded.Script = expand(cmd.Script)
+	return &expanded
+}
+
+// environ returns the environment of the processes started by a block
+func (e *CommandExecutor) environ(cmd *Command) []string {
+	env := os.Environ()
+	if e.config.Env != nil {
+		env = e.config.Env.environ()
+	}
+
+	keys := make([]string, 0, len(cmd.EnvVars))
+	for key := range cmd.EnvVars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, key+"="+cmd.EnvVars[key])
+	}
+	return env
+}
+
+// templateVariables returns the variables available to the templates of a
+// block
+func (e *CommandExecutor) templateVariables(cmd *Command) map[string]string {
+	variables := make(map[string]string)
+	if e.config.Env != nil {
+		variables = e.config.Env.GetVariables()
+	}
+	for key, value := range cmd.EnvVars {
+		variables[key] = value
+	}
+	return variables
+}
+
+// workdir returns the working directory of a block, empty for the current one
+func (e *CommandExecutor) workdir(cmd *Command) string {
+	if cmd.Workdir == "" {
+		return ""
+	}
+	return e.expandEnv(cmd, cmd.Workdir)
+}
+
+// resolvePath interprets relative paths within the working directory of a
+// block
+func (e *CommandExecutor) resolvePath(cmd *Command, path string) string {
+	if dir := e.workdir(cmd); dir != "" && path != "" && !filepath.IsAbs(path) {
+		return filepath.Join(dir, path)
+	}
+	return path
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestParseBlockEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    map[string]string
+		wantErr bool
+	}{
+		{"unset", nil, nil, false},
+		{"mapping", map[interface{}]interface{}{"A": "1", "B": 2}, map[string]string{"A": "1", "B": "2"}, false},
+		{"entries", []interface{}{map[interface{}]interface{}{"key": "A", "value": "1"}}, map[string]string{"A": "1"}, false},
+		{"empty value", map[interface{}]interface{}{"A": nil}, map[string]string{"A": ""}, false},
+		{"invalid name", map[interface{}]interface{}{"A-B": "1"}, nil, true},
+		{"entry without key", []interface{}{map[interface{}]interface{}{"value": "1"}}, nil, true},
+		{"scalar", "A=1", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBlockEnv(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBlockEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBlockEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunfromyamlWithOptionsBlockEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	workflow := []byte(fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+env:
+  - key: RFY_SHARED
+    value: workflow
+cmd:
+  - type: shell
+    name: scoped
+    workdir: %[1]s/sub
+    env:
+      RFY_BLOCK: block
+      RFY_SHARED: overridden
+    values:
+      - echo "$RFY_BLOCK $RFY_SHARED $(pwd -P)" > scoped
+  - type: exec
+    workdir: %[1]s/sub
+    env:
+      RFY_ARG: from-exec
+    values:
+      - touch $RFY_ARG
+  - type: conf
+    workdir: %[1]s/sub
+    expandenv: true
+    env:
+      RFY_BLOCK: templated
+    confdest: conf
+    confperm: 0644
+    confdata: "{{.RFY_BLOCK}}"
+  - type: shell
+    values:
+      - echo "${RFY_BLOCK:-unset} $RFY_SHARED" > %[1]s/later
+`, dir))
+
+	if err := RunfromyamlWithOptions(context.Background(), workflow, RunOptions{Parallel: 1}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+
+	sub := filepath.Join(dir, "sub")
+	wantSub, _ := filepath.EvalSymlinks(sub)
+	files := map[string]string{
+		filepath.Join(sub, "scoped"): "block overridden " + wantSub + "\n",
+		filepath.Join(sub, "conf"):   "templated",
+		filepath.Join(dir, "later"):  "unset workflow\n",
+	}
+	for path, want := range files {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("failed to read %s: %v", path, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(sub, "from-exec")); err != nil {
+		t.Errorf("exec block didn't run in its workdir with its env: %v", err)
+	}
+	if _, ok := os.LookupEnv("RFY_BLOCK"); ok {
+		t.Error("block env leaked into the process environment")
+	}
+}
+
+func TestCheckGuardsBlockEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "done"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	executor := NewCommandExecutor(CommandConfig{Env: NewEnvironment(), Level: LogLevelInfo, Output: OutputTypeFile})
+
+	tests := []struct {
+		name string
+		cmd  *Command
+		skip bool
+	}{
+		{"creates relative to workdir", &Command{Workdir: dir, Creates: "done"}, true},
+		{"creates from block env", &Command{EnvVars: map[string]string{"RFY_FILE": "done"}, Creates: dir + "/$RFY_FILE"}, true},
+		{"unless in workdir", &Command{Workdir: dir, Unless: "test -f done"}, true},
+		{"onlyif with block env", &Command{EnvVars: map[string]string{"RFY_GO": "no"}, OnlyIf: `test "$RFY_GO" = yes`}, true},
+		{"runs", &Command{Workdir: dir, Creates: "missing"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, err := executor.checkGuards(context.Background(), tt.cmd)
+			if err != nil {
+				t.Fatalf("checkGuards() unexpected error: %v", err)
+			}
+			if (reason != "") != tt.skip {
+				t.Errorf("checkGuards() = %q, want skip %v", reason, tt.skip)
+			}
+		})
+	}
+}
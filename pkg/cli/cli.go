@@ -2,16 +2,19 @@ package cli
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fatih/color"
-	"gopkg.in/yaml.v2"
 
 	functions "github.com/lanixx/runfromyaml/pkg/functions"
 )
@@ -26,6 +29,9 @@ const (
 	CommandTypeDockerCompose CommandType = "docker-compose"
 	CommandTypeSSH           CommandType = "ssh"
 	CommandTypeConfig        CommandType = "conf"
+	CommandTypeHTTP          CommandType = "http"
+	CommandTypeTemplate      CommandType = "template"
+	CommandTypeFile          CommandType = "file"
 )
 
 // OutputType represents where command output should be directed
@@ -46,10 +52,17 @@ const (
 	LogLevelDebug LogLevel = "debug"
 )
 
-// Environment manages environment variables
+// Environment manages the variables of a workflow. It doesn't change the
+// environment of the process, so every workflow sees only its own variables,
+// and it is safe for concurrent use.
 type Environment struct {
+	mu        sync.RWMutex
 	variables map[string]string
 	shell     []string
+	// index holds the position of every variable within shell
+	index map[string]int
+	// isolated environments don't fall back to the process environment
+	isolated bool
 }
 
 // NewEnvironment creates a new environment manager
@@ -57,38 +70,158 @@ func NewEnvironment() *Environment {
 	return &Environment{
 		variables: make(map[string]string),
 		shell:     make([]string, 0),
+		index:     make(map[string]int),
 	}
 }
 
-// Set sets an environment variable
+// NewIsolatedEnvironment creates an environment holding only vars. Unlike
+// an environment created by NewEnvironment, it doesn't fall back to the
+// variables of the process and the processes started with it don't inherit
+// them.
+func NewIsolatedEnvironment(vars map[string]string) *Environment {
+	e := NewEnvironment()
+	e.isolated = true
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		e.Set(key, vars[key])
+	}
+	return e
+}
+
+// Set sets an environment variable, replacing an earlier value
 func (e *Environment) Set(key, value string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	e.variables[key] = value
+	if i, ok := e.index[key]; ok {
+		e.shell[i] = key + "=" + value
+		return
+	}
+	e.index[key] = len(e.shell)
 	e.shell = append(e.shell, key+"="+value)
-	_ = os.Setenv(key, value)
 }
 
 // Get retrieves an environment variable
 func (e *Environment) Get(key string) string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	return e.variables[key]
 }
 
-// GetVariables returns the entire variables map
+// Lookup retrieves an environment variable and reports whether it is set
+func (e *Environment) Lookup(key string) (string, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	value, ok := e.variables[key]
+	return value, ok
+}
+
+// resolve retrieves a variable like Lookup, falling back to the process
+// environment unless the environment is isolated
+func (e *Environment) resolve(key string) (string, bool) {
+	if value, ok := e.Lookup(key); ok {
+		return value, true
+	}
+	if e.isolated {
+		return "", false
+	}
+	return os.LookupEnv(key)
+}
+
+// Expand replaces $var and ${var} like os.ExpandEnv. Variables of the
+// environment take precedence over those of the process.
+func (e *Environment) Expand(s string) string {
+	return os.Expand(s, func(key string) string {
+		value, _ := e.resolve(key)
+		return value
+	})
+}
+
+// GetVariables returns a copy of the variables map
 func (e *Environment) GetVariables() map[string]string {
-	return e.variables
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	variables := make(map[string]string, len(e.variables))
+	for k, v := range e.variables {
+		variables[k] = v
+	}
+	return variables
 }
 
 // Shell returns the environment variables in shell format
 func (e *Environment) Shell() []string {
-	return e.shell
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]string(nil), e.shell...)
+}
+
+// environ returns the environment of started processes, the variables of the
+// process followed by those of the environment unless it is isolated
+func (e *Environment) environ() []string {
+	if e.isolated {
+		return e.Shell()
+	}
+	return append(os.Environ(), e.Shell()...)
 }
 
 // Command represents a command to be executed
 type Command struct {
-	Type        CommandType
-	Description string
-	Values      []string
-	Options     map[string]interface{}
-	Env         *Environment
+	Type             CommandType
+	Name             string
+	Description      string
+	Values           []string
+	Argv             [][]string
+	Split            string
+	Shell            string
+	Script           string
+	PreserveNewlines bool
+	Strict           *bool
+	Workdir          string
+	EnvVars          map[string]string
+	Needs            []string
+	Tags             []string
+	When             string
+	Timeout          time.Duration
+	Retry            *RetryPolicy
+	ContinueOnError  bool
+	Register         string
+	Creates          string
+	Unless           string
+	OnlyIf           string
+	Loop             *Loop
+	Source           string
+	// Dir is the directory of the workflow file defining the block, empty
+	// for the working directory
+	Dir  string
+	Hash string
+	// ExpandEnv expands variables in the values and options of the block
+	ExpandEnv bool
+	// Options holds the keys specific to the type of the block, see
+	// DecodeOptions
+	Options map[string]interface{}
+	Env     *Environment
+}
+
+// label returns the name of the command, falling back to its type for
+// unnamed blocks
+func (c *Command) label(index int) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return fmt.Sprintf("%s #%d", c.Type, index+1)
+}
+
+// origin names the file an included command block comes from, it is empty
+// for blocks of the workflow itself
+func (c *Command) origin() string {
+	if c.Source == "" {
+		return ""
+	}
+	return " in " + c.Source
 }
 
 // CommandConfig holds common configuration for command execution
@@ -98,6 +231,26 @@ type CommandConfig struct {
 	Output      OutputType
 	Description string
 	WaitGroup   *sync.WaitGroup
+	// DryRun reports the commands and files of every block instead of
+	// running or writing them
+	DryRun bool
+	// Plan receives the report of a dry run, it is logged like any other
+	// output when nil
+	Plan io.Writer
+	// Shell is the interpreter of shell blocks and guards without their own
+	// shell option, bash when empty
+	Shell string
+	// Strict enables strict error handling for shell blocks without their
+	// own strict option
+	Strict bool
+	// Stdout and Stderr receive the output of started commands in place of
+	// the logging output setting when either of them is set. Commands don't
+	// read from the standard input then.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Log receives the messages of the run in place of the logging output
+	// setting when set
+	Log io.Writer
 }
 
 // CommandExecutor handles command execution
@@ -112,277 +265,323 @@ func NewCommandExecutor(config CommandConfig) *CommandExecutor {
 
 // Execute runs the command based on its type
 func (e *CommandExecutor) Execute(cmd *Command) error {
-	switch cmd.Type {
-	case CommandTypeExec:
-		return e.executeExecCommand(cmd)
-	case CommandTypeShell:
-		return e.executeShellCommand(cmd)
-	case CommandTypeDocker:
-		return e.executeDockerCommand(cmd)
-	case CommandTypeDockerCompose:
-		return e.executeDockerComposeCommand(cmd)
-	case CommandTypeSSH:
-		return e.executeSSHCommand(cmd)
-	case CommandTypeConfig:
-		return e.handleConfigCommand(cmd)
-	default:
+	return e.ExecuteContext(context.Background(), cmd)
+}
+
+// ExecuteContext runs the command based on its type. Running processes are
+// killed when ctx is done or the timeout of the command expires. Failed runs
+// are repeated according to the retry policy of the command. Blocks with a
+// loop are run once per iteration until the first one fails. Blocks whose
+// creates, unless or onlyif guards say so are skipped.
+func (e *CommandExecutor) ExecuteContext(ctx context.Context, cmd *Command) error {
+	reason, err := e.executeBlock(ctx, cmd, nil)
+	if reason != "" {
+		e.print(color.FgYellow, "# skipping execution: "+reason)
+	}
+	return err
+}
+
+// executeBlock runs the command like ExecuteContext and returns why it was
+// skipped when its guards prevented it from running. The started commands
+// and their output are added to record.
+func (e *CommandExecutor) executeBlock(ctx context.Context, cmd *Command, record *blockRecord) (string, error) {
+	if cmd.Loop == nil {
+		cmd = e.expandCommand(cmd)
+		if reason, err := e.checkGuards(ctx, cmd); err != nil || reason != "" {
+			return reason, err
+		}
+		return "", e.executeWithRetry(ctx, cmd, record)
+	}
+
+	iterations := cmd.Loop.iterations(e.config.Env)
+	if len(iterations) == 0 {
+		e.print(color.FgYellow, "# loop without items - skipping execution")
+		return "", nil
+	}
+
+	skipped := 0
+	for i, vars := range iterations {
+		e.print(color.FgCyan, fmt.Sprintf("# iteration %d/%d: %s", i+1, len(iterations), cmd.Loop.describe(vars)))
+
+		iteration := e.expandCommand(cmd.withLoopVars(vars))
+		if err := validateCommand(iteration); err != nil {
+			return "", fmt.Errorf("iteration %s: %w", cmd.Loop.describe(vars), err)
+		}
+		reason, err := e.checkGuards(ctx, iteration)
+		if err != nil {
+			return "", fmt.Errorf("iteration %s: %w", cmd.Loop.describe(vars), err)
+		}
+		if reason != "" {
+			e.print(color.FgYellow, "# skipping iteration: "+reason)
+			skipped++
+			continue
+		}
+
+		if err := e.executeWithRetry(ctx, iteration, record); err != nil {
+			return "", fmt.Errorf("iteration %s: %w", cmd.Loop.describe(vars), err)
+		}
+	}
+
+	if skipped == len(iterations) {
+		return "every iteration was skipped by its guards", nil
+	}
+	return "", nil
+}
+
+func (e *CommandExecutor) executeWithRetry(ctx context.Context, cmd *Command, record *blockRecord) error {
+	if cmd.Retry == nil || cmd.Retry.Attempts <= 1 {
+		return e.executeAttempt(ctx, cmd, record)
+	}
+
+	attempts := cmd.Retry.Attempts
+	for attempt := 1; ; attempt++ {
+		err := e.executeAttempt(ctx, cmd, record)
+		if err == nil {
+			e.print(color.FgGreen, fmt.Sprintf("# attempt %d/%d succeeded", attempt, attempts))
+			return nil
+		}
+
+		if attempt == attempts || ctx.Err() != nil || !cmd.Retry.shouldRetry(err) {
+			e.print(color.FgRed, fmt.Sprintf("# attempt %d/%d failed: %v - giving up", attempt, attempts, err))
+			if attempt > 1 {
+				return fmt.Errorf("failed after %d attempts: %w", attempt, err)
+			}
+			return err
+		}
+
+		delay := cmd.Retry.delayFor(attempt)
+		e.print(color.FgYellow, fmt.Sprintf("# attempt %d/%d failed: %v - retrying in %s", attempt, attempts, err, delay))
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// executeAttempt runs the command once, bounded by its timeout. The output
+// of interactive commands on a terminal isn't added to record, as they have
+// to be connected to it directly.
+func (e *CommandExecutor) executeAttempt(ctx context.Context, cmd *Command, record *blockRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	blockCtx := ctx
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		blockCtx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+
+	if e.interactive() {
+		record = nil
+	}
+	var out *blockOutput
+	if cmd.Register != "" || record != nil {
+		out = &blockOutput{capture: cmd.Register != "", record: record}
+	}
+
+	err := e.execute(blockCtx, cmd, out)
+	if cmd.Register != "" {
+		out.register(e.config.Env, cmd.Register)
+	}
+	if err != nil && ctx.Err() == nil && blockCtx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{Timeout: cmd.Timeout, Err: err}
+	}
+	return err
+}
+
+func (e *CommandExecutor) execute(ctx context.Context, cmd *Command, out *blockOutput) error {
+	runner, ok := LookupRunner(cmd.Type)
+	if !ok {
 		return fmt.Errorf("unknown command type: %s", cmd.Type)
 	}
+	return runner.Execute(ctx, &BlockRun{Command: cmd, executor: e, out: out})
 }
 
-func (e *CommandExecutor) executeExecCommand(cmd *Command) error {
+func (e *CommandExecutor) executeExecCommand(ctx context.Context, cmd *Command, out *blockOutput) error {
 	// Handle empty values gracefully
-	if len(cmd.Values) == 0 {
-		functions.PrintSwitch(color.FgYellow, string(e.config.Level), string(e.config.Output), "# exec command with empty values - skipping execution")
+	if len(cmd.Values) == 0 && len(cmd.Argv) == 0 {
+		e.print(color.FgYellow, "# exec command with empty values - skipping execution")
 		return nil
 	}
 
-	cmds := splitCommands(cmd.Values)
-	for _, cmdStr := range cmds {
-		cmdStr = strings.TrimSpace(cmdStr)
-		if cmdStr == "" {
-			continue // Skip empty commands
-		}
-		cmdStr = os.ExpandEnv(cmdStr)
-		cmdArgs := strings.Fields(cmdStr)
-		if len(cmdArgs) == 0 {
-			continue // Skip if no arguments after expansion
-		}
-		if err := e.runCommand(cmdArgs); err != nil {
+	commands, err := e.commandLines(cmd)
+	if err != nil {
+		return err
+	}
+	for _, cmdArgs := range commands {
+		if err := e.runCommand(ctx, cmd, cmdArgs, out); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (e *CommandExecutor) executeShellCommand(cmd *Command) error {
+func (e *CommandExecutor) executeShellCommand(ctx context.Context, cmd *Command, out *blockOutput) error {
 	// Handle empty values gracefully
-	if len(cmd.Values) == 0 {
-		functions.PrintSwitch(color.FgYellow, string(e.config.Level), string(e.config.Output), "# shell command with empty values - skipping execution")
+	if len(cmd.Values) == 0 && strings.TrimSpace(cmd.Script) == "" {
+		e.print(color.FgYellow, "# shell command with empty values - skipping execution")
 		return nil
 	}
 
-	// Filter out empty values
-	nonEmptyValues := make([]string, 0, len(cmd.Values))
-	for _, val := range cmd.Values {
-		if strings.TrimSpace(val) != "" {
-			nonEmptyValues = append(nonEmptyValues, val)
-		}
+	if strings.TrimSpace(cmd.Script) == "" && strings.TrimSpace(strings.Join(cmd.Values, "")) == "" {
+		e.print(color.FgYellow, "# shell command with only empty values - skipping execution")
+		return nil
 	}
 
-	if len(nonEmptyValues) == 0 {
-		functions.PrintSwitch(color.FgYellow, string(e.config.Level), string(e.config.Output), "# shell command with only empty values - skipping execution")
+	ip := interpreters[e.shellFor(cmd)]
+	script, err := ip.script(cmd, e.strictFor(cmd))
+	if err != nil {
+		return err
+	}
+
+	// A dry run shows the script instead of writing it to a file
+	if e.config.DryRun && ip.scriptExt != "" {
+		e.plan("would run %s with script:\n    %s", ip.program, strings.ReplaceAll(strings.TrimRight(script, "\n"), "\n", "\n    "))
+		out.setResult(nil)
 		return nil
 	}
 
-	// Join shell commands - semicolons are already present in the values
-	args := append([]string{"bash", "-c"}, strings.Join(nonEmptyValues, " "))
-	return e.runCommand(args)
+	args, cleanup, err := ip.command(script)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return e.runCommand(ctx, cmd, args, out)
 }
 
-func (e *CommandExecutor) executeDockerCommand(cmd *Command) error {
-	args := e.buildDockerArgs(cmd)
+func (e *CommandExecutor) executeDockerCommand(ctx context.Context, cmd *Command, o dockerOptions, out *blockOutput) error {
+	args := e.buildDockerArgs(o)
 
 	// If values are empty, we can't execute docker commands as they require commands to run
-	if len(cmd.Values) == 0 {
-		functions.PrintSwitch(color.FgYellow, string(e.config.Level), string(e.config.Output), "# docker command with empty values - skipping execution (docker commands require commands to execute)")
+	if len(cmd.Values) == 0 && len(cmd.Argv) == 0 {
+		e.print(color.FgYellow, "# docker command with empty values - skipping execution (docker commands require commands to execute)")
 		return nil
 	}
 
-	cmds := splitCommands(cmd.Values)
-	for _, cmdStr := range cmds {
-		cmdStr = strings.TrimSpace(cmdStr)
-		if cmdStr == "" {
-			continue // Skip empty commands
-		}
-		cmdStr = os.ExpandEnv(cmdStr)
-		cmdArgs := strings.Fields(cmdStr)
-		if len(cmdArgs) == 0 {
-			continue // Skip if no arguments after expansion
+	commands, err := e.commandLines(cmd)
+	if err != nil {
+		return err
+	}
+	for _, cmdArgs := range commands {
+		// sh -c expects the whole command as a single script
+		if cmd.Split != splitFields {
+			cmdArgs = []string{formatArgv(cmdArgs)}
 		}
-		fullArgs := append(args, cmdArgs...)
-		if err := e.runCommand(fullArgs); err != nil {
+		fullArgs := append(append([]string(nil), args...), cmdArgs...)
+		if err := e.runCommand(ctx, cmd, fullArgs, out); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (e *CommandExecutor) executeDockerComposeCommand(cmd *Command) error {
-	args := e.buildDockerComposeArgs(cmd)
+func (e *CommandExecutor) executeDockerComposeCommand(ctx context.Context, cmd *Command, o composeOptions, out *blockOutput) error {
+	args := e.buildDockerComposeArgs(cmd, o)
 
 	// If values are empty, execute the docker-compose command without additional commands
-	if len(cmd.Values) == 0 {
-		functions.PrintSwitch(color.FgYellow, string(e.config.Level), string(e.config.Output), "# docker-compose command with empty values - executing base command only")
-		return e.runCommand(args)
+	if len(cmd.Values) == 0 && len(cmd.Argv) == 0 {
+		e.print(color.FgYellow, "# docker-compose command with empty values - executing base command only")
+		return e.runCommand(ctx, cmd, args, out)
 	}
 
 	// If values are provided, execute additional commands inside containers
-	cmds := splitCommands(cmd.Values)
-	for _, cmdStr := range cmds {
-		cmdStr = strings.TrimSpace(cmdStr)
-		if cmdStr == "" {
-			continue // Skip empty commands
-		}
-		cmdStr = os.ExpandEnv(cmdStr)
-		cmdArgs := strings.Fields(cmdStr)
-		if len(cmdArgs) == 0 {
-			continue // Skip if no arguments after expansion
-		}
-		fullArgs := append(args, cmdArgs...)
-		if err := e.runCommand(fullArgs); err != nil {
+	commands, err := e.commandLines(cmd)
+	if err != nil {
+		return err
+	}
+	for _, cmdArgs := range commands {
+		fullArgs := append(append([]string(nil), args...), cmdArgs...)
+		if err := e.runCommand(ctx, cmd, fullArgs, out); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (e *CommandExecutor) executeSSHCommand(cmd *Command) error {
+func (e *CommandExecutor) executeSSHCommand(ctx context.Context, cmd *Command, o sshOptions, out *blockOutput) error {
 	// Handle empty values gracefully
-	if len(cmd.Values) == 0 {
-		functions.PrintSwitch(color.FgYellow, string(e.config.Level), string(e.config.Output), "# ssh command with empty values - skipping execution")
+	if len(cmd.Values) == 0 && len(cmd.Argv) == 0 {
+		e.print(color.FgYellow, "# ssh command with empty values - skipping execution")
 		return nil
 	}
 
-	args := e.buildSSHArgs(cmd)
-	cmds := splitCommands(cmd.Values)
-	for _, cmdStr := range cmds {
-		cmdStr = strings.TrimSpace(cmdStr)
-		if cmdStr == "" {
-			continue // Skip empty commands
-		}
-		cmdStr = os.ExpandEnv(cmdStr)
-		cmdArgs := strings.Fields(cmdStr)
-		if len(cmdArgs) == 0 {
-			continue // Skip if no arguments after expansion
+	args := e.buildSSHArgs(cmd, o)
+	commands, err := e.commandLines(cmd)
+	if err != nil {
+		return err
+	}
+	for _, cmdArgs := range commands {
+		// The remote shell parses the command again, so it is passed quoted
+		if cmd.Split != splitFields {
+			cmdArgs = []string{formatArgv(cmdArgs)}
 		}
-		fullArgs := append(args, cmdArgs...)
-		if err := e.runCommand(fullArgs); err != nil {
+		fullArgs := append(append([]string(nil), args...), cmdArgs...)
+		if err := e.runCommand(ctx, cmd, fullArgs, out); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (e *CommandExecutor) buildDockerArgs(cmd *Command) []string {
-	command := cmd.Options["command"].(string)
-	container := cmd.Options["container"].(string)
+func (e *CommandExecutor) buildDockerArgs(o dockerOptions) []string {
+	command := o.Command
+	container := o.Container
 	if command == "run" {
 		return []string{"docker", command, "-it", "--rm", container, "sh", "-c"}
 	}
 	return []string{"docker", command, container, "sh", "-c"}
 }
 
-func (e *CommandExecutor) buildDockerComposeArgs(cmd *Command) []string {
+func (e *CommandExecutor) buildDockerComposeArgs(cmd *Command, o composeOptions) []string {
 	args := []string{"docker", "compose"}
 
-	// Check if environment expansion is enabled
-	expandenv := false
-	if expandenvOpt, exists := cmd.Options["expandenv"]; exists {
-		expandenv = expandenvOpt.(bool)
-	}
-
-	// Handle dcoptions
-	if opts, exists := cmd.Options["dcoptions"]; exists {
-		if optsSlice, ok := opts.([]interface{}); ok {
-			for _, opt := range optsSlice {
-				if strOpt, ok := opt.(string); ok {
-					if expandenv {
-						strOpt = os.ExpandEnv(strOpt)
-					}
-					// Split the option into separate arguments
-					optArgs := strings.Fields(strOpt)
-					args = append(args, optArgs...)
-				}
-			}
-		}
+	// Options are split into separate arguments
+	for _, opt := range o.DCOptions {
+		args = append(args, strings.Fields(e.expandOption(cmd, opt))...)
 	}
 
-	// Add command
-	if cmd, exists := cmd.Options["command"]; exists {
-		if cmdStr, ok := cmd.(string); ok {
-			if expandenv {
-				cmdStr = os.ExpandEnv(cmdStr)
-			}
-			args = append(args, cmdStr)
-		}
-	}
-
-	// Handle cmdoptions
-	if opts, exists := cmd.Options["cmdoptions"]; exists {
-		if optsSlice, ok := opts.([]interface{}); ok {
-			for _, opt := range optsSlice {
-				if strOpt, ok := opt.(string); ok {
-					if expandenv {
-						strOpt = os.ExpandEnv(strOpt)
-					}
-					// Split the option into separate arguments
-					optArgs := strings.Fields(strOpt)
-					args = append(args, optArgs...)
-				}
-			}
-		}
+	if o.Command != "" {
+		args = append(args, e.expandOption(cmd, o.Command))
 	}
 
-	// Add service if it exists
-	if service, exists := cmd.Options["service"]; exists {
-		if serviceStr, ok := service.(string); ok && serviceStr != "" {
-			if expandenv {
-				serviceStr = os.ExpandEnv(serviceStr)
-			}
-			args = append(args, serviceStr)
-		}
+	for _, opt := range o.CmdOptions {
+		args = append(args, strings.Fields(e.expandOption(cmd, opt))...)
+	}
+
+	if o.Service != "" {
+		args = append(args, e.expandOption(cmd, o.Service))
 	}
 
 	return args
 }
 
-func (e *CommandExecutor) buildSSHArgs(cmd *Command) []string {
-	user := cmd.Options["user"].(string)
-	host := cmd.Options["host"].(string)
-	port := cmd.Options["port"].(int)
-
-	if expandenv, exists := cmd.Options["expandenv"]; exists {
-		if expandenv.(bool) {
-			user = os.ExpandEnv(user)
-			host = os.ExpandEnv(host)
-		}
+func (e *CommandExecutor) buildSSHArgs(cmd *Command, o sshOptions) []string {
+	port := o.Port
+	if port == 0 {
+		port = 22
 	}
 
-	args := []string{"ssh", "-p", strconv.Itoa(port), "-l", user, host}
-
-	// Handle SSH options
-	if opts, exists := cmd.Options["options"]; exists {
-		if optsSlice, ok := opts.([]interface{}); ok {
-			for _, opt := range optsSlice {
-				if strOpt, ok := opt.(string); ok {
-					// Apply expandenv to SSH options if enabled
-					if expandenv, exists := cmd.Options["expandenv"]; exists && expandenv.(bool) {
-						strOpt = os.ExpandEnv(strOpt)
-					}
-					args = append(args, strOpt)
-				}
-			}
-		}
+	args := []string{"ssh", "-p", strconv.Itoa(port), "-l", e.expandOption(cmd, o.User), e.expandOption(cmd, o.Host)}
+	for _, opt := range o.Options {
+		args = append(args, e.expandOption(cmd, opt))
 	}
 
 	return args
 }
 
-func (e *CommandExecutor) handleConfigCommand(cmd *Command) error {
-	var confdata, confdest string
-	var confperm os.FileMode
-	expandenv := false
-
-	if expandenvOpt, exists := cmd.Options["expandenv"]; exists {
-		expandenv = expandenvOpt.(bool)
+// expandOption expands variables in an option of a block with expandenv
+func (e *CommandExecutor) expandOption(cmd *Command, value string) string {
+	if !cmd.ExpandEnv {
+		return value
 	}
+	return e.expandEnv(cmd, value)
+}
 
-	if data := cmd.Options["confdata"]; data != nil {
-		confdata = data.(string)
-		if expandenv {
-			confdata = functions.GoTemplate(e.config.Env.variables, confdata)
-		}
+func (e *CommandExecutor) handleConfigCommand(cmd *Command, o confOptions) error {
+	confdata := o.ConfData
+	if confdata != "" && cmd.ExpandEnv {
+		confdata = functions.GoTemplate(e.templateVariables(cmd), confdata)
 	}
 
 	// Only add description if confdata is not empty
@@ -390,57 +589,123 @@ func (e *CommandExecutor) handleConfigCommand(cmd *Command) error {
 		confdata = cmd.Description + confdata
 	}
 
-	if dest := cmd.Options["confdest"]; dest != nil {
-		confdest = dest.(string)
-		if expandenv {
-			confdest = os.ExpandEnv(confdest)
-		}
-	}
-	if perm := cmd.Options["confperm"]; perm != nil {
-		confperm = os.FileMode(int(perm.(int)))
+	var confdest string
+	if o.ConfDest != "" {
+		confdest = e.resolvePath(cmd, e.expandEnv(cmd, o.ConfDest))
 	}
+	confperm := os.FileMode(o.ConfPerm)
 
 	// Handle empty config gracefully
 	if confdata == "" && confdest == "" {
-		functions.PrintSwitch(color.FgYellow, string(e.config.Level), string(e.config.Output), "# config command with empty data and destination - skipping")
+		e.print(color.FgYellow, "# config command with empty data and destination - skipping")
 		return nil
 	}
 
-	if confdata != "" && confdest != "" && string(rune(confperm)) != "" {
+	if confdata != "" && confdest != "" {
+		if e.config.DryRun {
+			e.planConfig(confdata, confdest, confperm)
+			return nil
+		}
 		functions.WriteFile(confdata, confdest, confperm)
-		functions.PrintSwitch(color.FgGreen, string(e.config.Level), string(e.config.Output), "# create ", confdest)
+		e.print(color.FgGreen, "# create ", confdest)
 	} else if confdest != "" {
-		functions.PrintSwitch(color.FgYellow, string(e.config.Level), string(e.config.Output), "# config command missing data or permissions for ", confdest)
+		e.print(color.FgYellow, "# config command missing data or permissions for ", confdest)
 	}
 
 	return nil
 }
 
-func (e *CommandExecutor) runCommand(cmd []string) error {
-	command := exec.Command(cmd[0], cmd[1:]...)
-	command.Env = append(os.Environ(), e.config.Env.shell...)
-	functions.PrintSwitch(color.FgYellow, string(e.config.Level), string(e.config.Output), strings.Trim(fmt.Sprint(cmd), "[]"), "\n")
+// runCommand starts argv in the working directory and with the environment
+// of the block
+func (e *CommandExecutor) runCommand(ctx context.Context, cmd *Command, argv []string, out *blockOutput) error {
+	return e.runProcess(ctx, cmd, argv, out, nil, nil)
+}
+
+// outputFilter takes over the standard output of a process before it reaches
+// the output of the block
+type outputFilter interface {
+	// wrap returns the writer the process writes to, passing on to w
+	wrap(w io.Writer) io.Writer
+	// flush handles what is left after the process exited
+	flush()
+}
+
+// runProcess runs a command like runCommand. A non-nil stdin is read by the
+// command instead of the standard input of the process and filter, if not
+// nil, sees its standard output first.
+func (e *CommandExecutor) runProcess(ctx context.Context, cmd *Command, argv []string, out *blockOutput, stdin io.Reader, filter outputFilter) error {
+	dir := e.workdir(cmd)
+	out.start(argv)
+	if e.config.DryRun {
+		if dir != "" {
+			e.plan("would run in %s: %s", dir, formatArgv(argv))
+		} else {
+			e.plan("would run: %s", formatArgv(argv))
+		}
+		out.setResult(nil)
+		return nil
+	}
+
+	command := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	command.Dir = dir
+	command.Env = e.environ(cmd)
+	command.Stdin = stdin
+	command.WaitDelay = processWaitDelay
+
+	// A process in its own group can't read from the terminal, so interactive
+	// commands stay in our group and receive Ctrl-C from the terminal directly
+	interactive := stdin == nil && e.interactive()
+	if !interactive {
+		setProcessGroup(command)
+	}
+
+	e.print(color.FgYellow, strings.Trim(fmt.Sprint(argv), "[]"), "\n")
+
+	stdout := func(w io.Writer) io.Writer {
+		w = out.stdoutWriter(w)
+		if filter != nil {
+			w = filter.wrap(w)
+		}
+		return w
+	}
+	run := func() error {
+		err := command.Run()
+		if filter != nil {
+			filter.flush()
+		}
+		out.setResult(err)
+		return err
+	}
 
-	switch e.config.Output {
-	case OutputTypeRest:
-		out, err := command.CombinedOutput()
+	switch {
+	case e.capturesOutput():
+		command.Stdout = stdout(writerOrDiscard(e.config.Stdout))
+		command.Stderr = out.stderrWriter(writerOrDiscard(e.config.Stderr))
+		if err := run(); err != nil {
+			e.print(color.FgRed, "Error: ", err)
+			return err
+		}
+	case e.config.Output == OutputTypeRest:
+		combined, err := runCombined(command, stdout, out, run)
 		if err != nil {
-			functions.PrintRest(color.FgRed, "error", "Error: ", err, string(out))
+			functions.PrintRest(color.FgRed, "error", "Error: ", err, combined)
 			return err
 		}
-		functions.PrintRest(color.FgHiWhite, string(e.config.Level), string(out))
-	case OutputTypeFile:
-		out, err := command.CombinedOutput()
+		functions.PrintRest(color.FgHiWhite, string(e.config.Level), combined)
+	case e.config.Output == OutputTypeFile:
+		combined, err := runCombined(command, stdout, out, run)
 		if err != nil {
-			functions.PrintFile("error", "Error: ", err, string(out))
+			functions.PrintFile("error", "Error: ", err, combined)
 			return err
 		}
-		functions.PrintFile(string(e.config.Level), string(out))
-	case OutputTypeStdout:
-		command.Stdout = os.Stdout
-		command.Stdin = os.Stdin
-		command.Stderr = os.Stderr
-		if err := command.Run(); err != nil {
+		functions.PrintFile(string(e.config.Level), combined)
+	case e.config.Output == OutputTypeStdout:
+		command.Stdout = stdout(os.Stdout)
+		if stdin == nil {
+			command.Stdin = os.Stdin
+		}
+		command.Stderr = out.stderrWriter(os.Stderr)
+		if err := run(); err != nil {
 			functions.PrintColor(color.FgRed, "error", "Error: ", err)
 			return err
 		}
@@ -448,141 +713,222 @@ func (e *CommandExecutor) runCommand(cmd []string) error {
 	return nil
 }
 
-func splitCommands(cmd []string) []string {
-	return strings.Split(strings.Join(cmd, " "), ";")
+// writeOutput passes output a block produced itself, instead of a started
+// process, to the output of the workflow like the output of a command
+func (e *CommandExecutor) writeOutput(out *blockOutput, data []byte) {
+	switch {
+	case e.capturesOutput():
+		_, _ = out.stdoutWriter(writerOrDiscard(e.config.Stdout)).Write(data)
+	case e.config.Output == OutputTypeRest:
+		_, _ = out.stdoutWriter(io.Discard).Write(data)
+		functions.PrintRest(color.FgHiWhite, string(e.config.Level), string(data))
+	case e.config.Output == OutputTypeFile:
+		_, _ = out.stdoutWriter(io.Discard).Write(data)
+		functions.PrintFile(string(e.config.Level), string(data))
+	case e.config.Output == OutputTypeStdout:
+		_, _ = out.stdoutWriter(os.Stdout).Write(data)
+	default:
+		_, _ = out.stdoutWriter(io.Discard).Write(data)
+	}
+}
+
+// interactive reports whether commands are connected to the terminal
+func (e *CommandExecutor) interactive() bool {
+	return e.config.Output == OutputTypeStdout && !e.capturesOutput() && isTerminal(os.Stdin)
+}
+
+// capturesOutput reports whether the output of commands goes to the writers
+// of the configuration
+func (e *CommandExecutor) capturesOutput() bool {
+	return e.config.Stdout != nil || e.config.Stderr != nil
 }
 
-// Runfromyaml executes commands from YAML file
-// Runfromyaml processes and executes commands from YAML data
-func Runfromyaml(yamlFile []byte, debug bool) error {
-	var yamlDocument map[interface{}]interface{}
-	if err := yaml.Unmarshal(yamlFile, &yamlDocument); err != nil {
-		return fmt.Errorf("failed to parse YAML: %w", err)
+// print writes a message of the run to the log writer of the configuration,
+// or according to the logging output setting without one
+func (e *CommandExecutor) print(ctype color.Attribute, cstring ...interface{}) {
+	if e.config.Log != nil {
+		_, _ = fmt.Fprintln(e.config.Log, cstring...)
+		return
 	}
+	functions.PrintSwitch(ctype, string(e.config.Level), string(e.config.Output), cstring...)
+}
 
-	env := NewEnvironment()
-	if env == nil {
-		return fmt.Errorf("failed to create environment instance")
+// writerOrDiscard returns w, or a writer dropping everything when it is nil
+func writerOrDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return io.Discard
 	}
+	return w
+}
 
-	parseEnvironmentVariables(yamlDocument, env)
-	outputType, outputLevel := parseLoggingSettings(yamlDocument)
+// runCombined runs the command with run and returns its standard output and
+// error combined, like exec.Cmd.CombinedOutput, while also capturing them into
+// out. stdout connects the standard output to the combined buffer.
+func runCombined(command *exec.Cmd, stdout func(io.Writer) io.Writer, out *blockOutput, run func() error) (string, error) {
+	var combined lockedBuffer
+	command.Stdout = stdout(&combined)
+	command.Stderr = out.stderrWriter(&combined)
+	err := run()
+	return combined.String(), err
+}
 
-	executor := NewCommandExecutor(CommandConfig{
-		Env:       env,
-		Level:     LogLevel(outputLevel),
-		Output:    OutputType(outputType),
-		WaitGroup: &sync.WaitGroup{},
-	})
+func splitCommands(cmd []string) []string {
+	return strings.Split(strings.Join(cmd, " "), ";")
+}
 
-	// Process commands
-	if cmdBlocks, ok := yamlDocument["cmd"].([]interface{}); ok {
-		for i, cmdBlock := range cmdBlocks {
-			if cmdMap, ok := cmdBlock.(map[interface{}]interface{}); ok {
-				// Validate required fields
-				cmdType, ok := cmdMap["type"].(string)
-				if !ok {
-					return fmt.Errorf("command block %d: missing or invalid 'type' field", i+1)
-				}
-
-				cmd := &Command{
-					Type:        CommandType(cmdType),
-					Description: functions.EvaluateDescription(cmdMap),
-					Values:      functions.ExtractAndExpand(cmdMap, "values"),
-					Options:     make(map[string]interface{}),
-					Env:         env,
-				}
-
-				// Copy all options from the YAML block
-				for k, v := range cmdMap {
-					if k != "type" && k != "values" {
-						cmd.Options[k.(string)] = v
-					}
-				}
-
-				// Validate command before execution
-				if err := validateCommand(cmd); err != nil {
-					return fmt.Errorf("command block %d validation failed: %w", i+1, err)
-				}
-
-				if err := executor.Execute(cmd); err != nil {
-					return fmt.Errorf("failed to execute command block %d (%s): %w", i+1, cmdType, err)
-				}
-			} else {
-				return fmt.Errorf("command block %d: invalid format", i+1)
-			}
+// parseCommands converts and validates a list of command blocks. The
+// section is used to refer to the blocks in error messages.
+func parseCommands(blocks []Block, section string, env *Environment) ([]*Command, error) {
+	var commands []*Command
+
+	for i, block := range blocks {
+		if block.Type == "" {
+			return nil, fmt.Errorf("%s %d: missing 'type' field", section, i+1)
+		}
+
+		cmd := &Command{
+			Hash:             blockHash(block),
+			Type:             CommandType(block.Type),
+			Name:             block.Name,
+			Values:           block.Values,
+			Argv:             block.Argv,
+			ExpandEnv:        block.ExpandEnv,
+			Split:            block.Split,
+			Shell:            block.Shell,
+			Script:           strings.Join(block.Script, "\n"),
+			PreserveNewlines: block.PreserveNewlines,
+			Strict:           block.Strict,
+			Workdir:          block.Workdir,
+			EnvVars:          block.Env,
+			Needs:            block.Needs,
+			Tags:             block.Tags,
+			When:             block.When,
+			Register:         block.Register,
+			ContinueOnError:  block.ContinueOnError,
+			Creates:          block.Creates,
+			Unless:           block.Unless,
+			OnlyIf:           block.OnlyIf,
+			Options:          block.Options,
+			Env:              env,
 		}
+		if block.Desc != "" {
+			cmd.Description = "# " + block.Desc
+		}
+
+		timeout, err := parseDuration(block.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("%s %d validation failed: invalid timeout: %w", section, i+1, err)
+		}
+		cmd.Timeout = timeout
+		if cmd.Retry, err = parseRetryPolicy(block.Retry); err != nil {
+			return nil, fmt.Errorf("%s %d validation failed: invalid retry: %w", section, i+1, err)
+		}
+
+		if cmd.Loop, err = parseLoop(block.Foreach, block.Matrix); err != nil {
+			return nil, fmt.Errorf("%s %d validation failed: invalid loop: %w", section, i+1, err)
+		}
+
+		// Validate command before execution
+		if err := validateCommand(cmd); err != nil {
+			return nil, fmt.Errorf("%s %d validation failed: %w", section, i+1, err)
+		}
+
+		commands = append(commands, cmd)
 	}
 
-	return nil
+	return commands, nil
 }
 
-// validateCommand validates a command before execution
-func validateCommand(cmd *Command) error {
-	// Validate command type
-	validTypes := []CommandType{
-		CommandTypeExec,
-		CommandTypeShell,
-		CommandTypeDocker,
-		CommandTypeDockerCompose,
-		CommandTypeSSH,
-		CommandTypeConfig,
-	}
-
-	isValidType := false
-	for _, validType := range validTypes {
-		if cmd.Type == validType {
-			isValidType = true
-			break
+// expandAll applies expand to every value
+func expandAll(values []string, expand func(string) string) []string {
+	result := make([]string, len(values))
+	for i, value := range values {
+		result[i] = expand(value)
+	}
+	return result
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList converts a YAML scalar or sequence into a list of strings
+func stringList(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if item != nil {
+				result = append(result, fmt.Sprint(item))
+			}
 		}
+		return result
+	default:
+		return []string{fmt.Sprint(v)}
 	}
+}
 
-	if !isValidType {
+// validateCommand validates a command before execution, the settings
+// specific to its type are checked by its runner
+func validateCommand(cmd *Command) error {
+	runner, ok := LookupRunner(cmd.Type)
+	if !ok {
 		return fmt.Errorf("invalid command type: %s", cmd.Type)
 	}
 
-	// Validate type-specific requirements only if values are provided
-	// This allows empty command blocks for documentation or placeholder purposes
-	switch cmd.Type {
-	case CommandTypeDocker:
-		// Only validate required fields if values are provided
-		if len(cmd.Values) > 0 {
-			if container, ok := cmd.Options["container"].(string); !ok || container == "" {
-				return fmt.Errorf("docker command with values requires 'container' field")
-			}
-			if command, ok := cmd.Options["command"].(string); !ok || command == "" {
-				return fmt.Errorf("docker command with values requires 'command' field")
-			}
+	// Reject when expressions that don't parse before anything runs
+	if cmd.When != "" {
+		if _, err := parseWhen(cmd.When); err != nil {
+			return err
 		}
+	}
 
-	case CommandTypeSSH:
-		// Only validate required fields if values are provided
-		if len(cmd.Values) > 0 {
-			if user, ok := cmd.Options["user"].(string); !ok || user == "" {
-				return fmt.Errorf("ssh command with values requires 'user' field")
-			}
-			if host, ok := cmd.Options["host"].(string); !ok || host == "" {
-				return fmt.Errorf("ssh command with values requires 'host' field")
-			}
+	if cmd.Register != "" {
+		if err := validateRegisterName(cmd.Register); err != nil {
+			return err
 		}
+	}
+
+	if err := validateSplit(cmd.Split); err != nil {
+		return err
+	}
 
-	case CommandTypeConfig:
-		// Config commands still require destination and data if they exist
-		if dest, ok := cmd.Options["confdest"].(string); ok && dest != "" {
-			if data, ok := cmd.Options["confdata"].(string); !ok || data == "" {
-				return fmt.Errorf("config command with 'confdest' requires 'confdata' field")
+	if err := validateShell(cmd.Shell); err != nil {
+		return err
+	}
+
+	// Empty blocks are allowed, they are useful for documentation,
+	// placeholders or conditional execution
+	if cmd.Loop == nil {
+		return runner.Validate(cmd)
+	}
+
+	// The keys of looped blocks may refer to the loop variables, so every
+	// iteration whose items are known before the run is checked. The others
+	// are checked when they run.
+	known := &Loop{Vars: cmd.Loop.Vars}
+	for _, items := range cmd.Loop.Items {
+		var literal []string
+		for _, item := range items {
+			if match := loopVarPattern.FindString(item); match != item {
+				literal = append(literal, item)
 			}
 		}
-		if data, ok := cmd.Options["confdata"].(string); ok && data != "" {
-			if dest, ok := cmd.Options["confdest"].(string); !ok || dest == "" {
-				return fmt.Errorf("config command with 'confdata' requires 'confdest' field")
-			}
+		known.Items = append(known.Items, literal)
+	}
+	for _, vars := range known.iterations(nil) {
+		if err := runner.Validate(cmd.withLoopVars(vars)); err != nil {
+			return fmt.Errorf("iteration %s: %w", cmd.Loop.describe(vars), err)
 		}
 	}
-
-	// Allow empty values blocks - useful for documentation, placeholders, or conditional execution
-	// No validation required for empty values
-
 	return nil
 }
 
@@ -658,40 +1004,33 @@ func executeAndShowCommand(command, shell string) error {
 	return cmd.Run()
 }
 
-func parseEnvironmentVariables(yamlDocument map[interface{}]interface{}, env *Environment) {
-	// Parse OS environment variables
-	for _, envVar := range os.Environ() {
-		parts := strings.SplitN(envVar, "=", 2)
-		env.Set(parts[0], parts[1])
+func parseEnvironmentVariables(workflow *Workflow, env *Environment) {
+	// Parse OS environment variables, an isolated environment already holds
+	// the variables the workflow starts with
+	if !env.isolated {
+		for _, envVar := range os.Environ() {
+			parts := strings.SplitN(envVar, "=", 2)
+			env.Set(parts[0], parts[1])
+		}
 	}
 
 	// Parse YAML environment variables
-	if envVars, ok := yamlDocument["env"].([]interface{}); ok {
-		for _, envVar := range envVars {
-			if envMap, ok := envVar.(map[interface{}]interface{}); ok {
-				if key, ok := envMap["key"].(string); ok {
-					if value, ok := envMap["value"].(string); ok {
-						env.Set(key, value)
-					}
-				}
-			}
+	for _, entry := range workflow.Env {
+		if entry.Key != "" {
+			env.Set(entry.Key, entry.Value)
 		}
 	}
 }
 
-func parseLoggingSettings(yamlDocument map[interface{}]interface{}) (string, string) {
+func parseLoggingSettings(workflow *Workflow) (string, string) {
 	var outputType, outputLevel string
 
-	if logging, ok := yamlDocument["logging"].([]interface{}); ok {
-		for _, logEntry := range logging {
-			if entry, ok := logEntry.(map[interface{}]interface{}); ok {
-				if output, exists := entry["output"]; exists {
-					outputType = output.(string)
-				}
-				if level, exists := entry["level"]; exists {
-					outputLevel = level.(string)
-				}
-			}
+	for _, entry := range workflow.Logging {
+		if entry.Output != "" {
+			outputType = entry.Output
+		}
+		if entry.Level != "" {
+			outputLevel = entry.Level
 		}
 	}
 
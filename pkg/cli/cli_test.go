@@ -2,6 +2,9 @@ package cli
 
 import (
 	"os"
+	"reflect"
+	"strconv"
+	"sync"
 	"testing"
 )
 
@@ -101,24 +104,14 @@ func TestEnvironment_Set(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Save original env var if it exists
-			originalValue := os.Getenv(tt.key)
-			defer func() {
-				if originalValue != "" {
-					_ = os.Setenv(tt.key, originalValue)
-				} else {
-					_ = os.Unsetenv(tt.key)
-				}
-			}()
-
 			env.Set(tt.key, tt.value)
 
 			if got := env.variables[tt.key]; got != tt.value {
 				t.Errorf("Environment.Set() internal map = %v, want %v", got, tt.value)
 			}
 
-			if got := os.Getenv(tt.key); got != tt.value {
-				t.Errorf("Environment.Set() OS env = %v, want %v", got, tt.value)
+			if _, ok := os.LookupEnv(tt.key); ok {
+				t.Errorf("Environment.Set() changed the OS env for %s", tt.key)
 			}
 		})
 	}
@@ -201,6 +194,55 @@ func TestEnvironment_Shell(t *testing.T) {
 	}
 }
 
+func TestEnvironment_ShellReplacesValues(t *testing.T) {
+	env := NewEnvironment()
+	env.Set("VAR1", "value1")
+	env.Set("VAR2", "value2")
+	env.Set("VAR1", "changed")
+
+	want := []string{"VAR1=changed", "VAR2=value2"}
+	if got := env.Shell(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Environment.Shell() = %v, want %v", got, want)
+	}
+}
+
+func TestEnvironment_Expand(t *testing.T) {
+	t.Setenv("RFY_PROCESS_VAR", "process")
+	t.Setenv("RFY_SHADOWED_VAR", "process")
+
+	env := NewEnvironment()
+	env.Set("RFY_SHADOWED_VAR", "workflow")
+	env.Set("RFY_WORKFLOW_VAR", "workflow")
+
+	got := env.Expand("$RFY_PROCESS_VAR ${RFY_SHADOWED_VAR} $RFY_WORKFLOW_VAR-$RFY_UNSET_VAR")
+	if want := "process workflow workflow-"; got != want {
+		t.Errorf("Environment.Expand() = %q, want %q", got, want)
+	}
+}
+
+func TestEnvironment_Concurrent(t *testing.T) {
+	env := NewEnvironment()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				env.Set("SHARED", strconv.Itoa(i))
+				_ = env.Get("SHARED")
+				_ = env.Expand("$SHARED")
+				_ = env.Shell()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := env.Shell(); len(got) != 1 {
+		t.Errorf("Environment.Shell() = %v, want a single entry", got)
+	}
+}
+
 func TestCommand_Creation(t *testing.T) {
 	env := NewEnvironment()
 
@@ -208,7 +250,6 @@ func TestCommand_Creation(t *testing.T) {
 		Type:        CommandTypeExec,
 		Description: "Test command",
 		Values:      []string{"echo", "hello"},
-		Options:     make(map[string]interface{}),
 		Env:         env,
 	}
 
@@ -0,0 +1,618 @@
+package cli
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Problem is a part of a workflow file that can't be decoded
+type Problem struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+// String formats the problem with its position, like compilers do
+func (p Problem) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("line %d, column %d: %s", p.Line, p.Column, p.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Message)
+}
+
+// DecodeError lists the problems found while decoding a workflow
+type DecodeError struct {
+	Problems []Problem
+}
+
+// Error implements the error interface, with one problem per line
+func (e *DecodeError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		lines[i] = problem.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// yaml11Bools are the boolean words of YAML 1.1 that yaml.v2 accepts in
+// addition to true and false
+var yaml11Bools = map[string]bool{
+	"y": true, "Y": true, "yes": true, "Yes": true, "YES": true,
+	"n": true, "N": true, "no": true, "No": true, "NO": true,
+	"on": true, "On": true, "ON": true,
+	"off": true, "Off": true, "OFF": true,
+}
+
+// sectionNames names the entries of the workflow lists in messages
+var sectionNames = map[string]string{
+	"cmd":        sectionCmd,
+	"on_failure": sectionOnFailure,
+	"finally":    sectionFinally,
+	"logging":    "logging entry",
+	"env":        "env entry",
+	"options":    "options entry",
+}
+
+var (
+	stringListType = reflect.TypeOf(StringList(nil))
+	durationType   = reflect.TypeOf(Duration(""))
+	argvType       = reflect.TypeOf(Argv(nil))
+	blockEnvType   = reflect.TypeOf(BlockEnv(nil))
+	envEntryType   = reflect.TypeOf(EnvEntry{})
+	blockType      = reflect.TypeOf(Block{})
+)
+
+// schemaTypeNames name the types of JSON schemas in messages
+var schemaTypeNames = map[string]string{
+	"string":  "a string",
+	"integer": "an integer",
+	"number":  "a number",
+	"boolean": "true or false",
+	"array":   "a list",
+	"object":  "a mapping",
+}
+
+// ParseWorkflow decodes a workflow. Unknown keys, keys that aren't supported
+// by the type of their block and values of the wrong type are reported as a
+// *DecodeError, with their position within file. The keys specific to a
+// block type are checked against the schema of its runner.
+func ParseWorkflow(data []byte, file string) (*Workflow, error) {
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+
+	c := &workflowChecker{file: file, seen: make(map[Problem]bool)}
+	if len(root.Content) > 0 {
+		c.check(root.Content[0], reflect.TypeOf(Workflow{}), "workflow")
+	}
+	if len(c.problems) > 0 {
+		// The keys of runners are checked after the others of their block
+		sort.SliceStable(c.problems, func(i, j int) bool {
+			a, b := c.problems[i], c.problems[j]
+			return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+		})
+		return nil, &DecodeError{Problems: c.problems}
+	}
+
+	var workflow Workflow
+	if err := yaml.Unmarshal(data, &workflow); err != nil {
+		return nil, fmt.Errorf("failed to decode workflow: %w", err)
+	}
+	return &workflow, nil
+}
+
+// workflowChecker compares the nodes of a YAML document with the types of
+// the workflow model
+type workflowChecker struct {
+	file     string
+	problems []Problem
+	// seen avoids reporting anchors merged into several mappings repeatedly
+	seen map[Problem]bool
+}
+
+// report records a problem at the position of node
+func (c *workflowChecker) report(node *yamlv3.Node, format string, args ...interface{}) {
+	problem := Problem{File: c.file, Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, args...)}
+	if !c.seen[problem] {
+		c.seen[problem] = true
+		c.problems = append(c.problems, problem)
+	}
+}
+
+// check reports the parts of node that don't fit into a value of type t,
+// what names the value in messages
+func (c *workflowChecker) check(node *yamlv3.Node, t reflect.Type, what string) {
+	node = resolveAlias(node)
+	if node.ShortTag() == "!!null" || t.Kind() == reflect.Interface {
+		return
+	}
+
+	switch {
+	case t == stringListType:
+		c.checkStringList(node, what)
+	case t == durationType:
+		c.checkDuration(node, what)
+	case t == argvType:
+		c.checkArgv(node, what)
+	case t == blockEnvType:
+		c.checkBlockEnv(node, what)
+	case t.Kind() == reflect.Ptr:
+		c.check(node, t.Elem(), what)
+	case t.Kind() == reflect.Struct:
+		c.checkMapping(node, t, what)
+	case t.Kind() == reflect.Map:
+		if node.Kind != yamlv3.MappingNode {
+			c.report(node, "%s must be a mapping, got %s", what, describeNode(node))
+			return
+		}
+		for _, pair := range mappingPairs(node) {
+			c.check(pair[1], t.Elem(), fmt.Sprintf("%s of %s", pair[0].Value, what))
+		}
+	case t.Kind() == reflect.Slice:
+		if node.Kind != yamlv3.SequenceNode {
+			c.report(node, "%s must be a list", what)
+			return
+		}
+		for i, item := range node.Content {
+			c.check(item, t.Elem(), fmt.Sprintf("%s %d", sectionNames[what], i+1))
+		}
+	case t.Kind() == reflect.String:
+		if node.Kind != yamlv3.ScalarNode {
+			c.report(node, "%s must be a string", what)
+		}
+	case t.Kind() == reflect.Int:
+		if node.Kind != yamlv3.ScalarNode || node.ShortTag() != "!!int" {
+			c.report(node, "%s must be an integer, got %s", what, describeNode(node))
+		}
+	case t.Kind() == reflect.Bool:
+		if node.Kind != yamlv3.ScalarNode || (node.ShortTag() != "!!bool" && !yaml11Bools[node.Value]) {
+			c.report(node, "%s must be true or false, got %s", what, describeNode(node))
+		}
+	}
+}
+
+// checkStringList accepts a scalar or a list of scalars
+func (c *workflowChecker) checkStringList(node *yamlv3.Node, what string) {
+	switch node.Kind {
+	case yamlv3.ScalarNode:
+		return
+	case yamlv3.SequenceNode:
+		for _, item := range node.Content {
+			if item = resolveAlias(item); item.Kind != yamlv3.ScalarNode {
+				c.report(item, "%s must only contain strings, got %s", what, describeNode(item))
+			}
+		}
+	default:
+		c.report(node, "%s must be a string or a list of strings", what)
+	}
+}
+
+// checkDuration accepts a duration like 90s or a number of seconds
+func (c *workflowChecker) checkDuration(node *yamlv3.Node, what string) {
+	if node.Kind != yamlv3.ScalarNode {
+		c.report(node, "%s must be a duration like 30s or a number of seconds, got %s", what, describeNode(node))
+		return
+	}
+	if _, err := parseDuration(Duration(node.Value)); err != nil {
+		c.report(node, "%s: %v", what, err)
+	}
+}
+
+// checkArgv accepts a list of arguments or a list of commands, each a list
+// of arguments
+func (c *workflowChecker) checkArgv(node *yamlv3.Node, what string) {
+	if node.Kind != yamlv3.SequenceNode {
+		c.report(node, "%s must be a list of arguments or a list of commands", what)
+		return
+	}
+
+	var arguments, commands bool
+	for _, item := range node.Content {
+		item = resolveAlias(item)
+		switch item.Kind {
+		case yamlv3.ScalarNode:
+			arguments = true
+		case yamlv3.SequenceNode:
+			commands = true
+			if len(item.Content) == 0 {
+				c.report(item, "%s contains an empty command", what)
+			}
+			c.checkStringList(item, what)
+		default:
+			c.report(item, "%s entries must be strings or lists of strings, got %s", what, describeNode(item))
+		}
+		if arguments && commands {
+			c.report(item, "%s mixes arguments and commands", what)
+			return
+		}
+	}
+}
+
+// checkBlockEnv accepts a mapping or a list of key and value entries, with
+// valid variable names
+func (c *workflowChecker) checkBlockEnv(node *yamlv3.Node, what string) {
+	checkName := func(key *yamlv3.Node) {
+		if !registerNamePattern.MatchString(key.Value) {
+			c.report(key, "invalid variable name %q in %s: must be a valid environment variable name", key.Value, what)
+		}
+	}
+
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		for _, pair := range mappingPairs(node) {
+			checkName(pair[0])
+			if value := resolveAlias(pair[1]); value.Kind != yamlv3.ScalarNode {
+				c.report(value, "%s of %s must be a string, got %s", pair[0].Value, what, describeNode(value))
+			}
+		}
+	case yamlv3.SequenceNode:
+		for i, item := range node.Content {
+			entry := fmt.Sprintf("entry %d of %s", i+1, what)
+			item = resolveAlias(item)
+			c.checkMapping(item, envEntryType, entry)
+			if item.Kind != yamlv3.MappingNode {
+				continue
+			}
+			key := mappingValue(item, "key")
+			if key == nil {
+				c.report(item, "%s has no key", entry)
+			} else if key.Kind == yamlv3.ScalarNode {
+				checkName(key)
+			}
+		}
+	default:
+		c.report(node, "%s must be a list of key and value entries or a mapping", what)
+	}
+}
+
+// mappingValue returns the value of key in a mapping, or nil
+func mappingValue(node *yamlv3.Node, key string) *yamlv3.Node {
+	for _, pair := range mappingPairs(node) {
+		if pair[0].Value == key {
+			return resolveAlias(pair[1])
+		}
+	}
+	return nil
+}
+
+// checkMapping reports unknown keys and checks the values of known ones
+func (c *workflowChecker) checkMapping(node *yamlv3.Node, t reflect.Type, what string) {
+	if node.Kind != yamlv3.MappingNode {
+		c.report(node, "%s must be a mapping, got %s", what, describeNode(node))
+		return
+	}
+
+	fields := yamlFields(t)
+	pairs := mappingPairs(node)
+	var options [][2]*yamlv3.Node
+	for _, pair := range pairs {
+		key, value := pair[0], pair[1]
+		fieldType, ok := fields[key.Value]
+		if !ok {
+			// The keys specific to the type are left to its runner
+			if t == blockType {
+				options = append(options, pair)
+				continue
+			}
+			c.unknownKey(key, fieldNames(fields), what)
+			continue
+		}
+		c.check(value, fieldType, keyName(key.Value, what))
+	}
+
+	if t == blockType {
+		c.checkBlockType(node, pairs, options, what)
+	}
+}
+
+// checkBlockType reports unknown block types and checks the keys specific
+// to the type against the schema of its runner
+func (c *workflowChecker) checkBlockType(node *yamlv3.Node, pairs, options [][2]*yamlv3.Node, what string) {
+	var typeNode *yamlv3.Node
+	for _, pair := range pairs {
+		if pair[0].Value == "type" {
+			typeNode = resolveAlias(pair[1])
+		}
+	}
+
+	var runner Runner
+	switch {
+	case typeNode == nil:
+		c.report(node, "%s has no type", what)
+	case typeNode.Kind == yamlv3.ScalarNode:
+		var ok bool
+		if runner, ok = LookupRunner(CommandType(typeNode.Value)); !ok {
+			var names []string
+			for _, t := range RunnerTypes() {
+				names = append(names, string(t))
+			}
+			c.report(typeNode, "unknown type %q in %s%s", typeNode.Value, what, didYouMean(typeNode.Value, names))
+		}
+	}
+
+	// Without a runner only keys that no block type knows are reported
+	known := runnerOptionKeys()
+	if runner == nil {
+		for _, pair := range options {
+			if !known[pair[0].Value] {
+				c.unknownKey(pair[0], append(fieldNames(yamlFields(blockType)), mapKeys(known)...), what)
+			}
+		}
+		return
+	}
+
+	schema := runner.Schema()
+	for _, pair := range options {
+		key, value := pair[0], pair[1]
+		if property, ok := schema[key.Value]; ok {
+			c.checkSchema(value, property, keyName(key.Value, what))
+		} else if known[key.Value] {
+			c.report(key, "key %q of %s is not supported by %s blocks", key.Value, what, typeNode.Value)
+		} else {
+			c.unknownKey(key, append(fieldNames(yamlFields(blockType)), mapKeys(schema)...), what)
+		}
+	}
+}
+
+// runnerOptionKeys returns the keys specific to any of the registered block
+// types
+func runnerOptionKeys() map[string]bool {
+	fields := yamlFields(blockType)
+	keys := make(map[string]bool)
+	for _, t := range RunnerTypes() {
+		runner, _ := LookupRunner(t)
+		for key := range runner.Schema() {
+			if _, common := fields[key]; !common {
+				keys[key] = true
+			}
+		}
+	}
+	return keys
+}
+
+// checkSchema reports the parts of node that don't match the JSON schema a
+// runner gives for one of its keys. Types, the items of lists, the values of
+// mappings and enums are checked. Of the alternatives of oneOf, the first
+// one allowing the kind of node is used.
+func (c *workflowChecker) checkSchema(node *yamlv3.Node, schema interface{}, what string) {
+	node = resolveAlias(node)
+	properties, _ := schema.(map[string]interface{})
+	if properties == nil || node.ShortTag() == "!!null" {
+		return
+	}
+
+	if alternatives, ok := properties["oneOf"].([]interface{}); ok {
+		var names []string
+		for _, alternative := range alternatives {
+			types := schemaTypes(alternative)
+			if schemaAllows(types, node) {
+				c.checkSchema(node, alternative, what)
+				return
+			}
+			names = append(names, typeNames(types)...)
+		}
+		c.report(node, "%s must be %s, got %s", what, strings.Join(names, " or "), describeNode(node))
+		return
+	}
+
+	if types := schemaTypes(properties); !schemaAllows(types, node) {
+		c.report(node, "%s must be %s, got %s", what, strings.Join(typeNames(types), " or "), describeNode(node))
+		return
+	}
+
+	switch node.Kind {
+	case yamlv3.SequenceNode:
+		if items, ok := properties["items"]; ok {
+			for i, item := range node.Content {
+				c.checkSchema(item, items, fmt.Sprintf("entry %d of %s", i+1, what))
+			}
+		}
+	case yamlv3.MappingNode:
+		known, _ := properties["properties"].(map[string]interface{})
+		additional, hasAdditional := properties["additionalProperties"]
+		for _, pair := range mappingPairs(node) {
+			name := fmt.Sprintf("%s of %s", pair[0].Value, what)
+			switch property, ok := known[pair[0].Value]; {
+			case ok:
+				c.checkSchema(pair[1], property, name)
+			case additional == false:
+				c.unknownKey(pair[0], mapKeys(known), what)
+			case hasAdditional:
+				c.checkSchema(pair[1], additional, name)
+			}
+		}
+	case yamlv3.ScalarNode:
+		// Values referring to variables are left to the runner
+		if enum, ok := properties["enum"].([]interface{}); ok && !loopVarPattern.MatchString(node.Value) {
+			values := make([]string, len(enum))
+			for i, value := range enum {
+				values[i] = fmt.Sprint(value)
+			}
+			if !containsString(values, node.Value) {
+				c.report(node, "%s must be one of %s, got %q", what, strings.Join(values, ", "), node.Value)
+			}
+		}
+	}
+}
+
+// schemaTypes returns the types a JSON schema allows, none means any
+func schemaTypes(schema interface{}) []string {
+	properties, _ := schema.(map[string]interface{})
+	switch t := properties["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, len(t))
+		for i, name := range t {
+			types[i] = fmt.Sprint(name)
+		}
+		return types
+	}
+	return nil
+}
+
+// schemaAllows reports whether node has one of the JSON schema types
+func schemaAllows(types []string, node *yamlv3.Node) bool {
+	if len(types) == 0 {
+		return true
+	}
+	scalar := node.Kind == yamlv3.ScalarNode
+	for _, t := range types {
+		switch t {
+		case "string":
+			if scalar {
+				return true
+			}
+		case "integer":
+			if scalar && node.ShortTag() == "!!int" {
+				return true
+			}
+		case "number":
+			if scalar && (node.ShortTag() == "!!int" || node.ShortTag() == "!!float") {
+				return true
+			}
+		case "boolean":
+			if scalar && (node.ShortTag() == "!!bool" || yaml11Bools[node.Value]) {
+				return true
+			}
+		case "array":
+			if node.Kind == yamlv3.SequenceNode {
+				return true
+			}
+		case "object":
+			if node.Kind == yamlv3.MappingNode {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// typeNames names JSON schema types for messages
+func typeNames(types []string) []string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		if names[i] = schemaTypeNames[t]; names[i] == "" {
+			names[i] = t
+		}
+	}
+	return names
+}
+
+// unknownKey reports a key that isn't part of the model, suggesting similar
+// known keys
+func (c *workflowChecker) unknownKey(key *yamlv3.Node, names []string, what string) {
+	c.report(key, "unknown key %q in %s%s", key.Value, what, didYouMean(key.Value, names))
+}
+
+// keyName names the value of key within what in messages
+func keyName(key, what string) string {
+	if what == "workflow" {
+		return key
+	}
+	return fmt.Sprintf("%s of %s", key, what)
+}
+
+// fieldNames returns the keys of fields
+func fieldNames(fields map[string]reflect.Type) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	return names
+}
+
+// mapKeys returns the keys of a map with string keys
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// didYouMean returns a suggestion of the candidates similar to name, or an
+// empty string if there are none
+func didYouMean(name string, candidates []string) string {
+	sorted := append([]string(nil), candidates...)
+	sort.Strings(sorted)
+	if similar := similarNames(name, sorted); len(similar) > 0 {
+		return fmt.Sprintf(", did you mean %s?", strings.Join(similar, " or "))
+	}
+	return ""
+}
+
+// mappingPairs returns the key and value nodes of a mapping, including
+// those of merged mappings. Keys of the mapping itself are listed last.
+func mappingPairs(node *yamlv3.Node) [][2]*yamlv3.Node {
+	var merged, own [][2]*yamlv3.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.ShortTag() != "!!merge" {
+			own = append(own, [2]*yamlv3.Node{key, value})
+			continue
+		}
+		value = resolveAlias(value)
+		sources := []*yamlv3.Node{value}
+		if value.Kind == yamlv3.SequenceNode {
+			sources = value.Content
+		}
+		for _, source := range sources {
+			if source = resolveAlias(source); source.Kind == yamlv3.MappingNode {
+				merged = append(merged, mappingPairs(source)...)
+			}
+		}
+	}
+	return append(merged, own...)
+}
+
+// resolveAlias returns the node an alias refers to
+func resolveAlias(node *yamlv3.Node) *yamlv3.Node {
+	for node.Kind == yamlv3.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// describeNode names the kind of a node for messages
+func describeNode(node *yamlv3.Node) string {
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		return "a mapping"
+	case yamlv3.SequenceNode:
+		return "a list"
+	}
+	return fmt.Sprintf("%q", node.Value)
+}
+
+// yamlFields returns the types of the fields of struct type t by their YAML
+// key, including those of inlined structs
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if options == "inline" {
+			// Inlined maps hold the keys that aren't fields
+			if field.Type.Kind() == reflect.Struct {
+				for key, fieldType := range yamlFields(field.Type) {
+					fields[key] = fieldType
+				}
+			}
+			continue
+		}
+		if name != "" && name != "-" {
+			fields[name] = field.Type
+		}
+	}
+	return fields
+}
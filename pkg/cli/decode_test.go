@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWorkflow(t *testing.T) {
+	data := []byte(`
+logging:
+  - level: info
+  - output: file
+env:
+  - key: PORT
+    value: 8080
+timeout: 5m
+defaults: &defaults
+cmd:
+  - &ssh
+    type: ssh
+    expandenv: yes
+    user: deploy
+    host: example.com
+    port: 2222
+    options: -A
+    values: uptime
+  - <<: *ssh
+    name: again
+  - type: conf
+    confdest: app.conf
+    confperm: 0644
+    confdata: "port={{.PORT}}"
+finally:
+  - type: shell
+    values:
+      - echo 1
+      - 2
+`)
+	_, err := ParseWorkflow(data, "")
+	if err == nil || !strings.Contains(err.Error(), `unknown key "defaults" in workflow`) {
+		t.Fatalf("ParseWorkflow() error = %v, want the unknown defaults key", err)
+	}
+
+	workflow, err := ParseWorkflow([]byte(strings.Replace(string(data), "defaults: &defaults\n", "", 1)), "")
+	if err != nil {
+		t.Fatalf("ParseWorkflow() unexpected error: %v", err)
+	}
+	if want := []LoggingEntry{{Level: "info"}, {Output: "file"}}; !reflect.DeepEqual(workflow.Logging, want) {
+		t.Errorf("Logging = %+v, want %+v", workflow.Logging, want)
+	}
+	if want := []EnvEntry{{Key: "PORT", Value: "8080"}}; !reflect.DeepEqual(workflow.Env, want) {
+		t.Errorf("Env = %+v, want %+v", workflow.Env, want)
+	}
+	if workflow.Timeout != "5m" {
+		t.Errorf("Timeout = %v, want 5m", workflow.Timeout)
+	}
+	if len(workflow.Cmd) != 3 || len(workflow.Finally) != 1 {
+		t.Fatalf("got %d cmd and %d finally blocks, want 3 and 1", len(workflow.Cmd), len(workflow.Finally))
+	}
+
+	ssh := workflow.Cmd[0]
+	wantOptions := map[string]interface{}{"user": "deploy", "host": "example.com", "port": 2222, "options": "-A"}
+	if !ssh.ExpandEnv || !reflect.DeepEqual(ssh.Values, StringList{"uptime"}) || !reflect.DeepEqual(ssh.Options, wantOptions) {
+		t.Errorf("ssh block = %+v, want expandenv, a single value and %+v", ssh, wantOptions)
+	}
+	if merged := workflow.Cmd[1]; merged.Name != "again" || merged.Options["host"] != "example.com" {
+		t.Errorf("merged block = %+v, want the ssh settings with its own name", merged)
+	}
+	if perm := workflow.Cmd[2].Options["confperm"]; perm != 0644 {
+		t.Errorf("ConfPerm = %o, want 644", perm)
+	}
+	if values := workflow.Finally[0].Values; !reflect.DeepEqual(values, StringList{"echo 1", "2"}) {
+		t.Errorf("finally values = %q, want them as strings", values)
+	}
+}
+
+func TestParseWorkflowProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{
+			"typo with suggestion",
+			"cmd:\n  - type: conf\n    confdest: a\n    confprem: 0644\n",
+			[]string{`wf.yaml:4:5: unknown key "confprem" in command block 1, did you mean "confperm"?`},
+		},
+		{
+			"unknown top-level key",
+			"loging:\n  - level: info\n",
+			[]string{`wf.yaml:1:1: unknown key "loging" in workflow, did you mean "logging"?`},
+		},
+		{
+			"key of another block type",
+			"cmd:\n  - type: shell\n    container: alpine\n",
+			[]string{`wf.yaml:3:5: key "container" of command block 1 is not supported by shell blocks`},
+		},
+		{
+			"unknown type",
+			"finally:\n  - type: dokcer\n",
+			[]string{`wf.yaml:2:11: unknown type "dokcer" in finally block 1, did you mean "docker"?`},
+		},
+		{
+			"missing type",
+			"on_failure:\n  - name: cleanup\n",
+			[]string{`wf.yaml:2:5: on_failure block 1 has no type`},
+		},
+		{
+			"wrong types",
+			"strict: maybe\ncmd:\n  - type: ssh\n    port: $SSH_PORT\n    values:\n      - echo \"USER: $USER\"\n",
+			[]string{
+				`wf.yaml:1:9: strict must be true or false, got "maybe"`,
+				`wf.yaml:4:11: port of command block 1 must be an integer, got "$SSH_PORT"`,
+				`wf.yaml:6:9: values of command block 1 must only contain strings, got a mapping`,
+			},
+		},
+		{
+			"retry typo",
+			"cmd:\n  - type: shell\n    values: [ls]\n    retry: {attemps: 3}\n",
+			[]string{`wf.yaml:4:13: unknown key "attemps" in retry of command block 1, did you mean "attempts"?`},
+		},
+		{
+			"block settings",
+			"cmd:\n  - type: exec\n    argv: [echo, [a]]\n    timeout: later\n    retry: 3\n    env:\n      - key: MY-VAR\n        vaule: 1\n    foreach: {a: b}\n    matrix: [a]\n",
+			[]string{
+				`wf.yaml:3:18: argv of command block 1 mixes arguments and commands`,
+				`wf.yaml:4:14: timeout of command block 1: invalid duration "later"`,
+				`wf.yaml:5:12: retry of command block 1 must be a mapping, got "3"`,
+				`wf.yaml:7:14: invalid variable name "MY-VAR" in env of command block 1: must be a valid environment variable name`,
+				`wf.yaml:8:9: unknown key "vaule" in entry 1 of env of command block 1, did you mean "value"?`,
+				`wf.yaml:9:14: foreach of command block 1 must be a string or a list of strings`,
+				`wf.yaml:10:13: matrix of command block 1 must be a mapping, got a list`,
+			},
+		},
+		{
+			"wrong section kinds",
+			"env: FOO=bar\ncmd:\n  - ls\n",
+			[]string{
+				`wf.yaml:1:6: env must be a list`,
+				`wf.yaml:3:5: command block 1 must be a mapping, got "ls"`,
+			},
+		},
+		{
+			"not a mapping",
+			"- type: shell\n",
+			[]string{`wf.yaml:1:1: workflow must be a mapping, got a list`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseWorkflow([]byte(tt.yaml), "wf.yaml")
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("ParseWorkflow() error = %v, want a *DecodeError", err)
+			}
+			var got []string
+			for _, problem := range decodeErr.Problems {
+				got = append(got, problem.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("problems = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProblemString(t *testing.T) {
+	problem := Problem{Line: 3, Column: 5, Message: "unknown key"}
+	if got, want := problem.String(), "line 3, column 5: unknown key"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	problem.File = "wf.yaml"
+	if got, want := problem.String(), "wf.yaml:3:5: unknown key"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestRunfromyamlWithOptionsRejectsUnknownKeys(t *testing.T) {
+	err := RunfromyamlWithOptions(context.Background(), []byte("cmd:\n  - type: exec\n    vaules: [echo hi]\n"), RunOptions{File: "wf.yaml", Parallel: 1})
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || err.Error() != `wf.yaml:3:5: unknown key "vaules" in command block 1, did you mean "values"?` {
+		t.Errorf("RunfromyamlWithOptions() error = %v, want the typo with its position", err)
+	}
+}
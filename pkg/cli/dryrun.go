@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+
+	functions "github.com/lanixx/runfromyaml/pkg/functions"
+)
+
+// dryRunPrefix marks everything a dry run reports instead of doing it
+const dryRunPrefix = "# [dry-run] "
+
+// plan reports an action of a dry run, either to the configured plan writer
+// or through the usual output
+func (e *CommandExecutor) plan(format string, args ...interface{}) {
+	line := dryRunPrefix + fmt.Sprintf(format, args...)
+	if e.config.Plan != nil {
+		_, _ = io.WriteString(e.config.Plan, line+"\n")
+		return
+	}
+	e.print(color.FgMagenta, line)
+}
+
+// planConfig reports the file a conf block would write, with the changes
+// against the current content of the destination
+func (e *CommandExecutor) planConfig(confdata, dest string, confperm os.FileMode) {
+	e.plan("would write %s (mode %04o)", dest, confperm)
+
+	current, err := os.ReadFile(dest)
+	oldName := dest
+	if err != nil {
+		oldName = "/dev/null"
+	}
+	if diff := functions.UnifiedDiff(oldName, dest, string(current), confdata); diff != "" {
+		e.plan("changes to %s:\n%s", dest, strings.TrimSuffix(diff, "\n"))
+	} else {
+		e.plan("%s is unchanged", dest)
+	}
+}
+
+// formatArgv quotes the arguments of a command so that it can be copied into
+// a shell
+func formatArgv(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = quoteArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// quoteArg quotes a single argument for POSIX shells if necessary
+func quoteArg(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	if strings.IndexFunc(arg, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r))
+	}) < 0 {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'"'"'`) + "'"
+}
+
+// syncWriter serializes writes from concurrently running blocks, writers
+// sharing a mutex are serialized together
+type syncWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+// lockWriter wraps w into a syncWriter using mu, it returns nil for a nil w
+func lockWriter(w io.Writer, mu *sync.Mutex) io.Writer {
+	if w == nil {
+		return nil
+	}
+	return &syncWriter{mu: mu, w: w}
+}
+
+// Write implements io.Writer
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatArgv(t *testing.T) {
+	tests := []struct {
+		argv []string
+		want string
+	}{
+		{[]string{"echo", "hello"}, "echo hello"},
+		{[]string{"ssh", "-p", "22", "-l", "admin", "host.example.com"}, "ssh -p 22 -l admin host.example.com"},
+		{[]string{"bash", "-c", "echo $HOME; ls"}, "bash -c 'echo $HOME; ls'"},
+		{[]string{"echo", "it's", ""}, `echo 'it'"'"'s' ''`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatArgv(tt.argv); got != tt.want {
+				t.Errorf("formatArgv(%q) = %s, want %s", tt.argv, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunfromyamlWithOptionsDryRun(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.conf")
+	if err := os.WriteFile(existing, []byte("port=80\nhost=localhost\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	yamlData := fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: shell
+    values:
+      - touch %[1]s/created
+  - type: ssh
+    user: admin
+    host: web1
+    port: 2222
+    values:
+      - uptime
+  - type: docker
+    command: exec
+    container: app
+    values:
+      - ls -la
+  - type: conf
+    confdest: %[1]s/existing.conf
+    confperm: 0644
+    confdata: |
+      port=8080
+      host=localhost
+  - type: conf
+    confdest: %[1]s/new.conf
+    confperm: 0600
+    confdata: |
+      key=value
+`, dir)
+
+	var plan strings.Builder
+	err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, DryRun: true, Plan: &plan})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+
+	for _, want := range []string{
+		"# [dry-run] would run: bash -c 'touch " + dir + "/created'",
+		"# [dry-run] would run: ssh -p 2222 -l admin web1 uptime",
+		"# [dry-run] would run: docker exec app sh -c 'ls -la'",
+		"# [dry-run] would write " + dir + "/existing.conf (mode 0644)",
+		"-port=80\n+port=8080\n host=localhost",
+		"--- /dev/null\n+++ " + dir + "/new.conf",
+		"+key=value",
+	} {
+		if !strings.Contains(plan.String(), want) {
+			t.Errorf("plan does not contain %q:\n%s", want, plan.String())
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "created")); err == nil {
+		t.Error("dry run executed a command")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.conf")); err == nil {
+		t.Error("dry run wrote a file")
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "port=80\nhost=localhost\n" {
+		t.Errorf("dry run changed %s to %q", existing, data)
+	}
+}
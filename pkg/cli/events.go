@@ -0,0 +1,49 @@
+package cli
+
+import "sync"
+
+// EventType identifies what an Event reports
+type EventType string
+
+const (
+	// EventBlockStarted is sent when a block is about to execute, after its
+	// when condition was evaluated
+	EventBlockStarted EventType = "block_started"
+	// EventBlockFinished is sent once for every block of the run report,
+	// including blocks that were skipped or never started
+	EventBlockFinished EventType = "block_finished"
+)
+
+// Event reports the progress of a workflow run. Block holds the result of
+// the block so far, only its section, index, name, type, source and start
+// time are set when it started.
+type Event struct {
+	Type  EventType   `json:"type"`
+	Block BlockResult `json:"block"`
+}
+
+// eventSink passes the events of a run to a handler one at a time, so the
+// handler doesn't have to be safe for concurrent use. A nil eventSink drops
+// all events.
+type eventSink struct {
+	mu      sync.Mutex
+	handler func(Event)
+}
+
+// newEventSink returns a sink calling handler, nil when handler is nil
+func newEventSink(handler func(Event)) *eventSink {
+	if handler == nil {
+		return nil
+	}
+	return &eventSink{handler: handler}
+}
+
+// send passes an event to the handler
+func (s *eventSink) send(eventType EventType, block BlockResult) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler(Event{Type: eventType, Block: block})
+}
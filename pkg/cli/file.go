@@ -0,0 +1,423 @@
+package cli
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// States of file blocks
+const (
+	fileStateDirectory = "directory"
+	fileStateAbsent    = "absent"
+	fileStateTouch     = "touch"
+	fileStateLink      = "link"
+	fileStateCopy      = "copy"
+	fileStateMove      = "move"
+)
+
+// fileStates lists the states of file blocks
+var fileStates = []string{fileStateDirectory, fileStateAbsent, fileStateTouch, fileStateLink, fileStateCopy, fileStateMove}
+
+// defaultDirMode is the mode of new directories created without one
+const defaultDirMode os.FileMode = 0755
+
+// fileOptions are the keys of file blocks
+type fileOptions struct {
+	// State is the operation, one of fileStates
+	State string `yaml:"state"`
+	Src   string `yaml:"src"`
+	Dest  string `yaml:"dest"`
+	Mode  int    `yaml:"mode"`
+	Owner string `yaml:"owner"`
+	Group string `yaml:"group"`
+	// Recursive applies the operation to the content of directories
+	Recursive bool `yaml:"recursive"`
+}
+
+// validateFileOptions checks the settings of a file block
+func validateFileOptions(o fileOptions) error {
+	if o.State == "" {
+		return fmt.Errorf("file command requires 'state' field")
+	}
+	known := false
+	for _, state := range fileStates {
+		known = known || o.State == state
+	}
+	if !known {
+		return fmt.Errorf("invalid state %q: must be one of %s", o.State, strings.Join(fileStates, ", "))
+	}
+	if o.Dest == "" {
+		return fmt.Errorf("file command requires 'dest' field")
+	}
+
+	switch o.State {
+	case fileStateLink, fileStateCopy, fileStateMove:
+		if o.Src == "" {
+			return fmt.Errorf("file command with state %s requires 'src' field", o.State)
+		}
+	default:
+		if o.Src != "" {
+			return fmt.Errorf("src is not supported with state %s", o.State)
+		}
+	}
+	switch o.State {
+	case fileStateDirectory, fileStateAbsent, fileStateCopy:
+	default:
+		if o.Recursive {
+			return fmt.Errorf("recursive is not supported with state %s", o.State)
+		}
+	}
+	if o.State == fileStateAbsent && (o.Mode != 0 || o.Owner != "" || o.Group != "") {
+		return fmt.Errorf("mode, owner and group are not supported with state absent")
+	}
+	if o.State == fileStateLink && o.Mode != 0 {
+		return fmt.Errorf("mode is not supported with state link")
+	}
+	return validateMode(o.Mode)
+}
+
+// fileAttributes are the mode and owner a file block applies, ids of -1 are
+// left as they are
+type fileAttributes struct {
+	mode  os.FileMode
+	uid   int
+	gid   int
+	owner string
+}
+
+// fileTask brings a path into the state of a file block. It only checks the
+// filesystem and reports what it would change in a dry run.
+type fileTask struct {
+	executor *CommandExecutor
+	attrs    fileAttributes
+	changed  bool
+}
+
+// executeFile runs a file block, everything already in the wanted state is
+// left alone
+func (e *CommandExecutor) executeFile(cmd *Command, o fileOptions, out *blockOutput) error {
+	dest := e.resolvePath(cmd, e.expandEnv(cmd, o.Dest))
+	src := e.expandEnv(cmd, o.Src)
+	// The target of a link is relative to the link, not the working directory
+	if o.State != fileStateLink {
+		src = e.resolvePath(cmd, src)
+	}
+
+	attrs, err := lookupFileAttributes(os.FileMode(o.Mode), e.expandEnv(cmd, o.Owner), e.expandEnv(cmd, o.Group))
+	if err != nil {
+		return err
+	}
+	task := &fileTask{executor: e, attrs: attrs}
+
+	switch o.State {
+	case fileStateDirectory:
+		err = task.directory(dest, attrs.mode)
+	case fileStateAbsent:
+		err = task.absent(dest, o.Recursive)
+	case fileStateTouch:
+		err = task.touch(dest)
+	case fileStateLink:
+		err = task.link(src, dest)
+	case fileStateCopy:
+		err = task.copy(src, dest, o.Recursive)
+	case fileStateMove:
+		err = task.move(src, dest)
+	}
+	if err == nil && o.State != fileStateAbsent {
+		err = task.applyAttributes(dest, o.Recursive)
+	}
+	out.setChanged(task.changed)
+	if err != nil {
+		return err
+	}
+
+	if !task.changed {
+		if e.config.DryRun {
+			e.plan("%s is unchanged", dest)
+		} else {
+			e.print(color.FgGreen, fmt.Sprintf("# %s is unchanged", dest))
+		}
+	}
+	return nil
+}
+
+// change applies a change described by what, in a dry run it is only
+// reported
+func (t *fileTask) change(what string, apply func() error) error {
+	t.changed = true
+	if t.executor.config.DryRun {
+		t.executor.plan("would %s", what)
+		return nil
+	}
+	if err := apply(); err != nil {
+		return fmt.Errorf("failed to %s: %w", what, err)
+	}
+	t.executor.print(color.FgGreen, "# "+what)
+	return nil
+}
+
+// directory creates the directory path with its parents
+func (t *fileTask) directory(path string, mode os.FileMode) error {
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		return nil
+	case err == nil:
+		return fmt.Errorf("%s exists and is not a directory", path)
+	case !os.IsNotExist(err):
+		return err
+	}
+	if mode == 0 {
+		mode = defaultDirMode
+	}
+	return t.change("create directory "+path, func() error { return os.MkdirAll(path, mode) })
+}
+
+// absent removes path, a directory with content only if recursive is set
+func (t *fileTask) absent(path string, recursive bool) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && !recursive {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return fmt.Errorf("%s is a directory that is not empty, set recursive to remove it", path)
+		}
+	}
+	return t.change("remove "+path, func() error { return os.RemoveAll(path) })
+}
+
+// touch creates an empty file at path unless it exists
+func (t *fileTask) touch(path string) error {
+	_, err := os.Lstat(path)
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	mode := t.attrs.mode
+	if mode == 0 {
+		mode = defaultFileMode
+	}
+	return t.change("create "+path, func() error {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	})
+}
+
+// link makes path a symbolic link to target, replacing a link to another
+// target
+func (t *fileTask) link(target, path string) error {
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil && info.Mode()&os.ModeSymlink == 0:
+		return fmt.Errorf("%s exists and is not a link", path)
+	case err == nil:
+		if current, err := os.Readlink(path); err == nil && current == target {
+			return nil
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	exists := err == nil
+	return t.change(fmt.Sprintf("link %s to %s", path, target), func() error {
+		if exists {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		return os.Symlink(target, path)
+	})
+}
+
+// copy copies the file src to dest, directories only if recursive is set
+func (t *fileTask) copy(src, dest string, recursive bool) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return t.copyFile(src, dest, info.Mode().Perm())
+	}
+	if !recursive {
+		return fmt.Errorf("%s is a directory, set recursive to copy it", src)
+	}
+
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return t.directory(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return t.link(link, target)
+		}
+		return t.copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+// copyFile copies the file src to dest, which gets the mode of the block or
+// perm, the mode of src
+func (t *fileTask) copyFile(src, dest string, perm os.FileMode) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if t.attrs.mode != 0 {
+		perm = t.attrs.mode
+	}
+	changed, err := fileChanged(dest, data, perm)
+	if err != nil || !changed {
+		return err
+	}
+	return t.change(fmt.Sprintf("copy %s to %s", src, dest), func() error { return writeFile(dest, data, perm) })
+}
+
+// move renames src to dest. A missing src with an existing dest counts as
+// already moved.
+func (t *fileTask) move(src, dest string) error {
+	_, srcErr := os.Lstat(src)
+	_, destErr := os.Lstat(dest)
+	switch {
+	case os.IsNotExist(srcErr) && destErr == nil:
+		return nil
+	case srcErr != nil:
+		return srcErr
+	case destErr == nil:
+		return fmt.Errorf("can't move %s, %s already exists", src, dest)
+	case !os.IsNotExist(destErr):
+		return destErr
+	}
+	return t.change(fmt.Sprintf("move %s to %s", src, dest), func() error { return os.Rename(src, dest) })
+}
+
+// applyAttributes gives path, and with recursive everything below it, the
+// mode and owner of the block
+func (t *fileTask) applyAttributes(path string, recursive bool) error {
+	if t.attrs.mode == 0 && t.attrs.uid < 0 && t.attrs.gid < 0 {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) && t.executor.config.DryRun {
+		// The path would have been created with the attributes
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !recursive || !info.IsDir() {
+		return t.setAttributes(path, info)
+	}
+	return filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return t.setAttributes(path, info)
+	})
+}
+
+// setAttributes changes the mode and owner of path where they differ
+func (t *fileTask) setAttributes(path string, info os.FileInfo) error {
+	isLink := info.Mode()&os.ModeSymlink != 0
+	// Windows only knows read-only files and links have no mode of their own
+	if t.attrs.mode != 0 && !isLink && runtime.GOOS != "windows" && info.Mode().Perm() != t.attrs.mode {
+		err := t.change(fmt.Sprintf("change mode of %s to %04o", path, t.attrs.mode), func() error {
+			return os.Chmod(path, t.attrs.mode)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.attrs.uid < 0 && t.attrs.gid < 0 {
+		return nil
+	}
+	uid, gid, ok := fileOwner(info)
+	if ok && (t.attrs.uid < 0 || uid == t.attrs.uid) && (t.attrs.gid < 0 || gid == t.attrs.gid) {
+		return nil
+	}
+	return t.change(fmt.Sprintf("change owner of %s to %s", path, t.attrs.owner), func() error {
+		return os.Lchown(path, t.attrs.uid, t.attrs.gid)
+	})
+}
+
+// lookupFileAttributes resolves the owner and group of a file block, given
+// as names or ids
+func lookupFileAttributes(mode os.FileMode, owner, group string) (fileAttributes, error) {
+	attrs := fileAttributes{mode: mode, uid: -1, gid: -1}
+	if owner == "" && group == "" {
+		return attrs, nil
+	}
+	if runtime.GOOS == "windows" {
+		return attrs, fmt.Errorf("owner and group are not supported on Windows")
+	}
+
+	if owner != "" {
+		id, err := strconv.Atoi(owner)
+		if err != nil {
+			u, lookupErr := user.Lookup(owner)
+			if lookupErr != nil {
+				return attrs, fmt.Errorf("unknown owner %q: %w", owner, lookupErr)
+			}
+			if id, err = strconv.Atoi(u.Uid); err != nil {
+				return attrs, fmt.Errorf("owner %q has no numeric id", owner)
+			}
+		}
+		attrs.uid = id
+	}
+	if group != "" {
+		id, err := strconv.Atoi(group)
+		if err != nil {
+			g, lookupErr := user.LookupGroup(group)
+			if lookupErr != nil {
+				return attrs, fmt.Errorf("unknown group %q: %w", group, lookupErr)
+			}
+			if id, err = strconv.Atoi(g.Gid); err != nil {
+				return attrs, fmt.Errorf("group %q has no numeric id", group)
+			}
+		}
+		attrs.gid = id
+	}
+	attrs.owner = owner
+	if group != "" {
+		attrs.owner += ":" + group
+	}
+	return attrs, nil
+}
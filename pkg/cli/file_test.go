@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeTestFile creates path with its parent directories
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileBlock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping link and mode based test on Windows")
+	}
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "tree", "sub", "a.txt"), "a")
+	writeTestFile(t, filepath.Join(dir, "moveme"), "move")
+	writeTestFile(t, filepath.Join(dir, "gone", "old.txt"), "old")
+
+	yamlData := `
+cmd:
+  - type: file
+    workdir: ` + dir + `
+    state: directory
+    dest: out/conf.d
+    mode: 0750
+  - type: file
+    workdir: ` + dir + `
+    state: touch
+    dest: out/conf.d/empty
+  - type: file
+    workdir: ` + dir + `
+    state: copy
+    src: tree/sub/a.txt
+    dest: out/copy.txt
+    mode: 0600
+  - type: file
+    workdir: ` + dir + `
+    state: copy
+    recursive: true
+    src: tree
+    dest: out/tree
+  - type: file
+    workdir: ` + dir + `
+    state: link
+    src: copy.txt
+    dest: out/link
+  - type: file
+    workdir: ` + dir + `
+    state: move
+    src: moveme
+    dest: out/moved
+  - type: file
+    workdir: ` + dir + `
+    state: absent
+    recursive: true
+    dest: gone
+`
+	for run, wantChanged := range []bool{true, false} {
+		var log strings.Builder
+		report, err := RunfromyamlWithReport(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, Log: &log})
+		if err != nil {
+			t.Fatalf("run %d: RunfromyamlWithReport() unexpected error: %v\nlog: %s", run+1, err, log.String())
+		}
+		for _, block := range report.Blocks {
+			if block.Changed == nil || *block.Changed != wantChanged {
+				t.Errorf("run %d: block %d changed = %v, want %v", run+1, block.Index+1, block.Changed, wantChanged)
+			}
+		}
+		if !wantChanged && !strings.Contains(log.String(), "success, unchanged") {
+			t.Errorf("run %d: log = %q, want unchanged blocks in the summary", run+1, log.String())
+		}
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, "out", "conf.d")); err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("directory = %v, %v, want mode 0750", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out", "conf.d", "empty")); err != nil {
+		t.Errorf("touched file: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "out", "copy.txt")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("copy = %v, %v, want mode 0600", info, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "out", "tree", "sub", "a.txt")); err != nil || string(data) != "a" {
+		t.Errorf("copied tree = %q, %v, want the file", data, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "out", "link")); err != nil || target != "copy.txt" {
+		t.Errorf("link = %q, %v, want copy.txt", target, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "out", "moved")); err != nil || string(data) != "move" {
+		t.Errorf("moved = %q, %v, want the file", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gone")); !os.IsNotExist(err) {
+		t.Errorf("removed directory still exists: %v", err)
+	}
+}
+
+func TestFileBlockDryRun(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "src.txt"), "data")
+
+	yamlData := `
+cmd:
+  - type: file
+    workdir: ` + dir + `
+    state: directory
+    dest: out
+  - type: file
+    workdir: ` + dir + `
+    state: copy
+    src: src.txt
+    dest: out/dest.txt
+  - type: file
+    workdir: ` + dir + `
+    state: absent
+    dest: src.txt
+  - type: file
+    workdir: ` + dir + `
+    state: absent
+    dest: missing
+`
+	var log strings.Builder
+	report, err := RunfromyamlWithReport(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, DryRun: true, Log: &log})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithReport() unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"would create directory " + filepath.Join(dir, "out"),
+		"would copy " + filepath.Join(dir, "src.txt") + " to " + filepath.Join(dir, "out", "dest.txt"),
+		"would remove " + filepath.Join(dir, "src.txt"),
+		filepath.Join(dir, "missing") + " is unchanged",
+	} {
+		if !strings.Contains(log.String(), want) {
+			t.Errorf("log = %q, want %q", log.String(), want)
+		}
+	}
+	if changed := report.Blocks[3].Changed; changed == nil || *changed {
+		t.Errorf("block 4 changed = %v, want false", changed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out")); err == nil {
+		t.Error("dry run created the directory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "src.txt")); err != nil {
+		t.Error("dry run removed the file")
+	}
+}
+
+func TestFileBlockErrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping link based test on Windows")
+	}
+
+	tests := []struct {
+		name  string
+		block string
+		want  string
+	}{
+		{name: "not empty", block: "state: absent\n    dest: full", want: "not empty, set recursive"},
+		{name: "directory over file", block: "state: directory\n    dest: file.txt", want: "exists and is not a directory"},
+		{name: "link over file", block: "state: link\n    src: full\n    dest: file.txt", want: "exists and is not a link"},
+		{name: "copy directory", block: "state: copy\n    src: full\n    dest: copy", want: "set recursive to copy it"},
+		{name: "move over file", block: "state: move\n    src: full\n    dest: file.txt", want: "already exists"},
+		{name: "move missing", block: "state: move\n    src: missing\n    dest: other", want: "no such file"},
+		{name: "unknown owner", block: "state: touch\n    dest: file.txt\n    owner: no-such-user-rfy", want: "unknown owner"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFile(t, filepath.Join(dir, "full", "a.txt"), "a")
+			writeTestFile(t, filepath.Join(dir, "file.txt"), "file")
+
+			yamlData := "cmd:\n  - type: file\n    workdir: " + dir + "\n    " + tt.block + "\n"
+			report, err := RunfromyamlWithReport(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, Log: &strings.Builder{}})
+			if err == nil {
+				t.Fatal("RunfromyamlWithReport() expected error")
+			}
+			if !strings.Contains(report.Blocks[0].Reason, tt.want) {
+				t.Errorf("reason = %q, want %q", report.Blocks[0].Reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileBlockOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping owner based test on Windows")
+	}
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "file.txt"), "file")
+
+	yamlData := "cmd:\n  - type: file\n    workdir: " + dir + "\n    state: touch\n    dest: file.txt\n    owner: " +
+		strconv.Itoa(os.Getuid()) + "\n    group: " + strconv.Itoa(os.Getgid()) + "\n"
+	report, err := RunfromyamlWithReport(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, Log: &strings.Builder{}})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithReport() unexpected error: %v", err)
+	}
+	if changed := report.Blocks[0].Changed; changed == nil || *changed {
+		t.Errorf("changed = %v, want the owner to be unchanged", changed)
+	}
+}
+
+func TestFileBlockValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  string
+	}{
+		{name: "missing state", block: "dest: a", want: "requires 'state' field"},
+		{name: "unknown state", block: "state: present\n    dest: a", want: `state of command block 1 must be one of directory, absent, touch, link, copy, move, got "present"`},
+		{name: "missing dest", block: "state: touch", want: "requires 'dest' field"},
+		{name: "missing src", block: "state: copy\n    dest: a", want: "state copy requires 'src' field"},
+		{name: "src without use", block: "state: touch\n    src: a\n    dest: b", want: "src is not supported with state touch"},
+		{name: "recursive touch", block: "state: touch\n    dest: a\n    recursive: true", want: "recursive is not supported"},
+		{name: "mode of absent", block: "state: absent\n    dest: a\n    mode: 0644", want: "not supported with state absent"},
+		{name: "mode of link", block: "state: link\n    src: a\n    dest: b\n    mode: 0644", want: "mode is not supported with state link"},
+		{name: "values", block: "state: touch\n    dest: a\n    values: [a]", want: "values are not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateWorkflow([]byte("cmd:\n  - type: file\n    "+tt.block+"\n"), RunOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ValidateWorkflow() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
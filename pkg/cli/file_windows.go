@@ -0,0 +1,11 @@
+//go:build windows
+
+package cli
+
+import "os"
+
+// fileOwner returns the ids of the user and group owning a file, Windows has
+// none
+func fileOwner(_ os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
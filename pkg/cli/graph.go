@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// commandGraph describes the dependencies between command blocks
+type commandGraph struct {
+	commands   []*Command
+	needs      [][]int
+	dependents [][]int
+}
+
+// buildCommandGraph resolves the needs of every command block and checks the
+// resulting graph for unknown names and cycles before anything is executed
+func buildCommandGraph(commands []*Command) (*commandGraph, error) {
+	names := make(map[string][]int)
+	for i, cmd := range commands {
+		if cmd.Name != "" {
+			names[cmd.Name] = append(names[cmd.Name], i)
+		}
+	}
+
+	graph := &commandGraph{
+		commands:   commands,
+		needs:      make([][]int, len(commands)),
+		dependents: make([][]int, len(commands)),
+	}
+
+	for i, cmd := range commands {
+		seen := make(map[int]bool)
+		for _, need := range cmd.Needs {
+			indexes, ok := names[need]
+			if !ok {
+				return nil, fmt.Errorf("command block %d (%s)%s: needs unknown block %q", i+1, cmd.label(i), cmd.origin(), need)
+			}
+			if len(indexes) > 1 {
+				return nil, fmt.Errorf("command block %d (%s)%s: needs ambiguous block %q (defined %d times)", i+1, cmd.label(i), cmd.origin(), need, len(indexes))
+			}
+			dep := indexes[0]
+			if dep == i {
+				return nil, fmt.Errorf("command block %d (%s)%s: block cannot depend on itself", i+1, cmd.label(i), cmd.origin())
+			}
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			graph.needs[i] = append(graph.needs[i], dep)
+			graph.dependents[dep] = append(graph.dependents[dep], i)
+		}
+
+		// Blocks whose results are used in the when expression have to finish
+		// first. Names that aren't part of this graph refer to blocks of an
+		// earlier section, which are already done.
+		for _, name := range cmd.whenReferences() {
+			indexes := names[name]
+			if len(indexes) != 1 || indexes[0] == i || seen[indexes[0]] {
+				continue
+			}
+			dep := indexes[0]
+			seen[dep] = true
+			graph.needs[i] = append(graph.needs[i], dep)
+			graph.dependents[dep] = append(graph.dependents[dep], i)
+		}
+	}
+
+	if cycle := graph.findCycle(); cycle != nil {
+		labels := make([]string, len(cycle))
+		for i, index := range cycle {
+			labels[i] = commands[index].label(index)
+		}
+		return nil, fmt.Errorf("dependency cycle detected: %s", strings.Join(labels, " -> "))
+	}
+
+	return graph, nil
+}
+
+// findCycle returns the block indexes forming a dependency cycle, or nil
+func (g *commandGraph) findCycle() []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make([]int, len(g.commands))
+	var stack []int
+	var cycle []int
+
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		state[i] = visiting
+		stack = append(stack, i)
+		for _, dep := range g.needs[i] {
+			switch state[dep] {
+			case visiting:
+				for j, index := range stack {
+					if index == dep {
+						cycle = append(append([]int{}, stack[j:]...), dep)
+						break
+					}
+				}
+				return true
+			case unvisited:
+				if visit(dep) {
+					return true
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = visited
+		return false
+	}
+
+	for i := range g.commands {
+		if state[i] == unvisited && visit(i) {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// run executes every block once all of its needs have completed, with at most
+// parallel blocks running at the same time. Ready blocks are started in file
+// order, so a parallel value of 1 keeps the sequential behaviour. After the
+// first failure no new blocks are started and the first error is returned
+// once the running blocks have finished.
+func (g *commandGraph) run(parallel int, execute func(index int) error) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	type outcome struct {
+		index int
+		err   error
+	}
+
+	unmet := make([]int, len(g.commands))
+	var ready []int
+	for i := range g.commands {
+		unmet[i] = len(g.needs[i])
+		if unmet[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	done := make(chan outcome)
+	running := 0
+	var firstErr error
+
+	for {
+		for firstErr == nil && running < parallel && len(ready) > 0 {
+			index := ready[0]
+			ready = ready[1:]
+			running++
+			go func() {
+				done <- outcome{index: index, err: execute(index)}
+			}()
+		}
+
+		if running == 0 {
+			return firstErr
+		}
+
+		result := <-done
+		running--
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			continue
+		}
+
+		for _, dependent := range g.dependents[result.index] {
+			unmet[dependent]--
+			if unmet[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+		sort.Ints(ready)
+	}
+}
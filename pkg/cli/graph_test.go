@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newGraphCommands(blocks ...[]string) []*Command {
+	commands := make([]*Command, len(blocks))
+	for i, block := range blocks {
+		commands[i] = &Command{Type: CommandTypeShell, Name: block[0], Needs: block[1:]}
+	}
+	return commands
+}
+
+func TestBuildCommandGraph(t *testing.T) {
+	tests := []struct {
+		name    string
+		blocks  [][]string
+		wantErr string
+	}{
+		{"no dependencies", [][]string{{"a"}, {"b"}, {"c"}}, ""},
+		{"chain", [][]string{{"a"}, {"b", "a"}, {"c", "b"}}, ""},
+		{"forward reference", [][]string{{"a", "b"}, {"b"}}, ""},
+		{"unknown name", [][]string{{"a"}, {"b", "missing"}}, `needs unknown block "missing"`},
+		{"self dependency", [][]string{{"a", "a"}}, "cannot depend on itself"},
+		{"ambiguous name", [][]string{{"a"}, {"a"}, {"b", "a"}}, `needs ambiguous block "a"`},
+		{"cycle", [][]string{{"a", "c"}, {"b", "a"}, {"c", "b"}}, "dependency cycle detected: a -> c -> b -> a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := buildCommandGraph(newGraphCommands(tt.blocks...))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("buildCommandGraph() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("buildCommandGraph() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommandGraphRunOrder(t *testing.T) {
+	graph, err := buildCommandGraph(newGraphCommands(
+		[]string{"a", "c"},
+		[]string{"b"},
+		[]string{"c", "b"},
+	))
+	if err != nil {
+		t.Fatalf("buildCommandGraph() unexpected error: %v", err)
+	}
+
+	var order []string
+	err = graph.run(1, func(i int) error {
+		order = append(order, graph.commands[i].Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("run() unexpected error: %v", err)
+	}
+
+	if got := strings.Join(order, ","); got != "b,c,a" {
+		t.Errorf("run() order = %s, want b,c,a", got)
+	}
+}
+
+func TestCommandGraphRunParallel(t *testing.T) {
+	graph, err := buildCommandGraph(newGraphCommands(
+		[]string{"a"}, []string{"b"}, []string{"c"}, []string{"d"},
+		[]string{"e", "a", "b", "c", "d"},
+	))
+	if err != nil {
+		t.Fatalf("buildCommandGraph() unexpected error: %v", err)
+	}
+
+	var current, peak int32
+	var mu sync.Mutex
+	finished := make(map[string]bool)
+
+	err = graph.run(2, func(i int) error {
+		n := atomic.AddInt32(&current, 1)
+		defer atomic.AddInt32(&current, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+
+		name := graph.commands[i].Name
+		mu.Lock()
+		defer mu.Unlock()
+		if name == "e" && len(finished) != 4 {
+			return fmt.Errorf("e started before its needs finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+		finished[name] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("run() unexpected error: %v", err)
+	}
+
+	if peak > 2 {
+		t.Errorf("run() executed %d blocks concurrently, limit is 2", peak)
+	}
+	if len(finished) != 5 {
+		t.Errorf("run() finished %d blocks, want 5", len(finished))
+	}
+}
+
+func TestCommandGraphRunStopsAfterFailure(t *testing.T) {
+	graph, err := buildCommandGraph(newGraphCommands(
+		[]string{"a"}, []string{"b", "a"}, []string{"c"},
+	))
+	if err != nil {
+		t.Fatalf("buildCommandGraph() unexpected error: %v", err)
+	}
+
+	var executed []string
+	err = graph.run(1, func(i int) error {
+		executed = append(executed, graph.commands[i].Name)
+		if graph.commands[i].Name == "a" {
+			return fmt.Errorf("boom")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "boom" {
+		t.Errorf("run() error = %v, want boom", err)
+	}
+	if got := strings.Join(executed, ","); got != "a" {
+		t.Errorf("run() executed %s, want only a", got)
+	}
+}
+
+func TestRunfromyamlWithOptionsNeeds(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	yamlData := fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: shell
+    name: second
+    needs: first
+    values:
+      - echo second >> %[1]s/order
+  - type: shell
+    name: first
+    values:
+      - echo first >> %[1]s/order
+`, dir)
+
+	if err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 2}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+
+	order, err := os.ReadFile(filepath.Join(dir, "order"))
+	if err != nil {
+		t.Fatalf("Failed to read order file: %v", err)
+	}
+	if string(order) != "first\nsecond\n" {
+		t.Errorf("blocks ran in order %q, want first then second", order)
+	}
+}
+
+func TestRunfromyamlWithOptionsUnknownNeeds(t *testing.T) {
+	yamlData := `
+cmd:
+  - type: shell
+    name: only
+    needs: [missing]
+    values:
+      - echo should not run
+`
+
+	err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1})
+	if err == nil || !strings.Contains(err.Error(), `needs unknown block "missing"`) {
+		t.Errorf("RunfromyamlWithOptions() error = %v, want unknown block error", err)
+	}
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// checkGuards evaluates the creates, unless and onlyif options of a block and
+// returns why it should not run, or an empty string when it should. Guard
+// commands are run with the shell of the block, only their exit code counts.
+func (e *CommandExecutor) checkGuards(ctx context.Context, cmd *Command) (string, error) {
+	if cmd.Creates != "" {
+		path := e.resolvePath(cmd, e.expandEnv(cmd, cmd.Creates))
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Sprintf("%s already exists (creates)", path), nil
+		}
+	}
+
+	if cmd.Unless != "" {
+		ok, err := e.runGuard(ctx, cmd, "unless", cmd.Unless)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return fmt.Sprintf("unless command %q succeeded", cmd.Unless), nil
+		}
+	}
+
+	if cmd.OnlyIf != "" {
+		ok, err := e.runGuard(ctx, cmd, "onlyif", cmd.OnlyIf)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return fmt.Sprintf("onlyif command %q failed", cmd.OnlyIf), nil
+		}
+	}
+
+	return "", nil
+}
+
+// runGuard runs a guard command and reports whether it exited with status 0.
+// A dry run doesn't execute guards and assumes the block has to run.
+func (e *CommandExecutor) runGuard(ctx context.Context, cmd *Command, option, script string) (bool, error) {
+	if e.config.DryRun {
+		e.plan("would check %s: %s", option, script)
+		return option == "onlyif", nil
+	}
+
+	ip := interpreters[e.shellFor(cmd)]
+	text, err := ip.script(&Command{Script: script}, false)
+	if err != nil {
+		return false, err
+	}
+	argv, cleanup, err := ip.command(text)
+	if err != nil {
+		return false, err
+	}
+	defer cleanup()
+
+	command := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	command.Dir = e.workdir(cmd)
+	command.Env = e.environ(cmd)
+	command.WaitDelay = processWaitDelay
+	setProcessGroup(command)
+
+	err = command.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return true, nil
+	case ctx.Err() != nil:
+		return false, ctx.Err()
+	case errors.As(err, &exitErr):
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to run %s command: %w", option, err)
+	}
+}
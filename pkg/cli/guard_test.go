@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCheckGuards(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, nil, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		cmd      *Command
+		wantSkip string
+	}{
+		{"no guards", &Command{}, ""},
+		{"creates missing", &Command{Creates: filepath.Join(dir, "missing")}, ""},
+		{"creates existing", &Command{Creates: existing}, "already exists (creates)"},
+		{"unless succeeds", &Command{Unless: "true"}, `unless command "true" succeeded`},
+		{"unless fails", &Command{Unless: "exit 3"}, ""},
+		{"onlyif succeeds", &Command{OnlyIf: "test -f " + existing}, ""},
+		{"onlyif fails", &Command{OnlyIf: "false"}, `onlyif command "false" failed`},
+		{"all guards pass", &Command{Creates: filepath.Join(dir, "missing"), Unless: "false", OnlyIf: "true"}, ""},
+	}
+
+	executor := NewCommandExecutor(CommandConfig{Env: NewEnvironment()})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, err := executor.checkGuards(context.Background(), tt.cmd)
+			if err != nil {
+				t.Fatalf("checkGuards() unexpected error: %v", err)
+			}
+			if (tt.wantSkip == "") != (reason == "") || !strings.Contains(reason, tt.wantSkip) {
+				t.Errorf("checkGuards() = %q, want %q", reason, tt.wantSkip)
+			}
+		})
+	}
+}
+
+func TestRunfromyamlWithOptionsGuards(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	workflow := []byte(fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: shell
+    name: mkdir
+    creates: %[1]s/created
+    values:
+      - mkdir %[1]s/created; echo mkdir >> %[1]s/runs
+  - type: shell
+    name: marker
+    unless: test -f %[1]s/marker
+    values:
+      - touch %[1]s/marker; echo marker >> %[1]s/runs
+  - type: shell
+    name: per-item
+    foreach: [a, b]
+    onlyif: test -d %[1]s/created
+    creates: %[1]s/item-${item}
+    values:
+      - touch %[1]s/item-${item}; echo ${item} >> %[1]s/runs
+`, dir))
+
+	// Running twice only changes something the first time
+	for i := 0; i < 2; i++ {
+		if err := RunfromyamlWithOptions(context.Background(), workflow, RunOptions{Parallel: 1}); err != nil {
+			t.Fatalf("RunfromyamlWithOptions() run %d unexpected error: %v", i+1, err)
+		}
+	}
+
+	runs, _ := os.ReadFile(filepath.Join(dir, "runs"))
+	if want := "mkdir\nmarker\na\nb\n"; string(runs) != want {
+		t.Errorf("blocks wrote %q, want %q", runs, want)
+	}
+}
@@ -0,0 +1,261 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// defaultExpectStatus is accepted when a http block doesn't list its status
+// codes
+const defaultExpectStatus = "2xx"
+
+// maxResponseSize is the largest response body read by http blocks, longer
+// responses fail the block instead of filling the memory
+const maxResponseSize = 32 << 20
+
+var (
+	httpMethodPattern   = regexp.MustCompile(`^[A-Za-z]+$`)
+	expectStatusPattern = regexp.MustCompile(`^[1-5]([0-9]{2}|xx)$`)
+)
+
+// httpOptions are the keys of http blocks
+type httpOptions struct {
+	// Method, URL, Headers and Body describe the request
+	Method   string            `yaml:"method"`
+	URL      string            `yaml:"url"`
+	Headers  map[string]string `yaml:"headers"`
+	Body     string            `yaml:"body"`
+	BodyFile string            `yaml:"body_file"`
+	// ExpectStatus lists the accepted status codes, like 200 or 2xx
+	ExpectStatus StringList `yaml:"expect_status"`
+	// Extract maps variable names to JSONPath expressions into the response
+	Extract map[string]string `yaml:"extract"`
+}
+
+// validateHTTPOptions checks the settings of a http block
+func validateHTTPOptions(o httpOptions) error {
+	if o.URL == "" {
+		return fmt.Errorf("http command requires 'url' field")
+	}
+	if o.Method != "" && !httpMethodPattern.MatchString(o.Method) {
+		return fmt.Errorf("invalid method %q", o.Method)
+	}
+	if o.Body != "" && o.BodyFile != "" {
+		return fmt.Errorf("body and body_file can't be used together")
+	}
+	for _, status := range o.ExpectStatus {
+		if !expectStatusPattern.MatchString(strings.ToLower(status)) {
+			return fmt.Errorf("invalid expect_status %q: must be a status code like 200 or a class like 2xx", status)
+		}
+	}
+	for name, path := range o.Extract {
+		if !registerNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid extract name %q: must be a valid environment variable name", name)
+		}
+		if _, err := parseJSONPath(path); err != nil {
+			return fmt.Errorf("invalid extract path for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// executeHTTPRequest sends the request of a http block. The response body is
+// the output of the block, the headers are never logged.
+func (e *CommandExecutor) executeHTTPRequest(ctx context.Context, cmd *Command, o httpOptions, out *blockOutput) error {
+	method := http.MethodGet
+	if o.Method != "" {
+		method = strings.ToUpper(o.Method)
+	}
+	target := e.expandOption(cmd, o.URL)
+	label := method + " " + redactURL(target)
+	out.start([]string{method, redactURL(target)})
+
+	body, err := e.httpBody(cmd, o)
+	if err != nil {
+		out.setResult(err)
+		return err
+	}
+	if e.config.DryRun {
+		e.plan("would send %s", label)
+		out.setResult(nil)
+		return nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		out.setResult(err)
+		return fmt.Errorf("invalid request %s: %w", label, redactURLError(err))
+	}
+	for key, value := range o.Headers {
+		value = e.expandOption(cmd, value)
+		if strings.EqualFold(key, "Host") {
+			request.Host = value
+			continue
+		}
+		request.Header.Set(key, value)
+	}
+
+	e.print(color.FgYellow, label, "\n")
+	err = e.sendHTTPRequest(o, request, label, out)
+	out.setResult(err)
+	if err != nil {
+		e.print(color.FgRed, "Error: ", err)
+	}
+	return err
+}
+
+// sendHTTPRequest sends request and checks and extracts the response
+func (e *CommandExecutor) sendHTTPRequest(o httpOptions, request *http.Request, label string, out *blockOutput) error {
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", label, redactURLError(err))
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxResponseSize+1))
+	if err != nil {
+		return fmt.Errorf("%s failed to read the response: %w", label, err)
+	}
+	if len(data) > maxResponseSize {
+		return fmt.Errorf("%s returned a response larger than %d bytes", label, maxResponseSize)
+	}
+	if len(data) > 0 {
+		if data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+		e.writeOutput(out, data)
+	}
+
+	expect := []string(o.ExpectStatus)
+	if len(expect) == 0 {
+		expect = []string{defaultExpectStatus}
+	}
+	if !statusExpected(response.StatusCode, expect) {
+		return fmt.Errorf("%s returned status %d, expected %s", label, response.StatusCode, strings.Join(expect, " or "))
+	}
+
+	if len(o.Extract) == 0 {
+		return nil
+	}
+	variables, err := extractJSON(data, o.Extract)
+	if err != nil {
+		return fmt.Errorf("%s: %w", label, err)
+	}
+	if e.config.Env != nil {
+		for key, value := range variables {
+			e.config.Env.Set(key, value)
+		}
+	}
+	return nil
+}
+
+// httpBody returns the body of the request of a http block
+func (e *CommandExecutor) httpBody(cmd *Command, o httpOptions) ([]byte, error) {
+	if o.BodyFile == "" {
+		return []byte(e.expandOption(cmd, o.Body)), nil
+	}
+	path := e.resolvePath(cmd, e.expandOption(cmd, o.BodyFile))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body_file: %w", err)
+	}
+	return data, nil
+}
+
+// statusExpected reports whether code matches one of the accepted status
+// codes or classes
+func statusExpected(code int, expect []string) bool {
+	text := strconv.Itoa(code)
+	for _, status := range expect {
+		status = strings.ToLower(status)
+		if status == text || (strings.HasSuffix(status, "xx") && status[0] == text[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractJSON evaluates the JSONPath expressions of paths against a JSON
+// document and returns the results by variable name. Strings are used as
+// they are, other values in their JSON form.
+func extractJSON(data []byte, paths map[string]string) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("response is not JSON: %w", err)
+	}
+
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	variables := make(map[string]string, len(paths))
+	for _, name := range names {
+		path, err := parseJSONPath(paths[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid extract path for %s: %w", name, err)
+		}
+		value, err := path.lookup(document)
+		if err != nil {
+			return nil, fmt.Errorf("extract %s: %w", name, err)
+		}
+		switch v := value.(type) {
+		case string:
+			variables[name] = v
+		case nil:
+			variables[name] = ""
+		default:
+			text, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("extract %s: %w", name, err)
+			}
+			variables[name] = string(text)
+		}
+	}
+	return variables, nil
+}
+
+// redactURL hides the password and the query values of a URL in messages,
+// they often hold tokens
+func redactURL(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for _, values := range query {
+			for i := range values {
+				values[i] = "xxxxx"
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+// redactURLError hides the password and query values of the URL included in
+// the errors of net/http
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = redactURL(urlErr.URL)
+	}
+	return err
+}
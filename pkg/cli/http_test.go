@@ -0,0 +1,352 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lanixx/runfromyaml/pkg/functions"
+)
+
+// recordedRequest is a request received by the test server
+type recordedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+// newHTTPTestServer answers every request with status and body and records
+// the requests
+func newHTTPTestServer(t *testing.T, status int, body string) (*httptest.Server, func() []recordedRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []recordedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, recordedRequest{method: r.Method, path: r.URL.Path, header: r.Header, body: string(data)})
+		mu.Unlock()
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []recordedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]recordedRequest(nil), requests...)
+	}
+}
+
+func TestHTTPBlock(t *testing.T) {
+	server, requests := newHTTPTestServer(t, http.StatusCreated, `{"data": {"id": 42, "tags": ["a", "b"], "name": "app"}}`)
+
+	var stdout, log strings.Builder
+	yamlData := `
+env:
+  - key: TOKEN
+    value: s3cret
+cmd:
+  - type: http
+    name: create
+    expandenv: true
+    method: post
+    url: ` + server.URL + `/apps
+    headers:
+      Authorization: Bearer $TOKEN
+      Content-Type: application/json
+    body: '{"name": "app"}'
+    expect_status: [200, 201]
+    extract:
+      APP_ID: $.data.id
+      APP_NAME: $['data']['name']
+      LAST_TAG: $.data.tags[-1]
+  - type: http
+    expandenv: true
+    method: PUT
+    url: ` + server.URL + `/apps/$APP_ID
+    body: $APP_NAME $LAST_TAG
+`
+	report, err := RunfromyamlWithReport(context.Background(), []byte(yamlData), RunOptions{
+		Parallel: 1,
+		Stdout:   &stdout,
+		Log:      &log,
+	})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithReport() unexpected error: %v\nlog: %s", err, log.String())
+	}
+
+	got := requests()
+	if len(got) != 2 {
+		t.Fatalf("server got %d requests, want 2", len(got))
+	}
+	if got[0].method != http.MethodPost || got[0].path != "/apps" || got[0].body != `{"name": "app"}` {
+		t.Errorf("first request = %+v, want the POST with its body", got[0])
+	}
+	if got[0].header.Get("Authorization") != "Bearer s3cret" || got[0].header.Get("Content-Type") != "application/json" {
+		t.Errorf("first request headers = %v, want the expanded headers", got[0].header)
+	}
+	if got[1].method != http.MethodPut || got[1].path != "/apps/42" || got[1].body != "app b" {
+		t.Errorf("second request = %+v, want the extracted values", got[1])
+	}
+
+	if !strings.Contains(log.String(), "POST "+server.URL+"/apps") {
+		t.Errorf("log = %q, want the request", log.String())
+	}
+	if strings.Contains(log.String()+stdout.String(), "s3cret") {
+		t.Errorf("log = %q, want no header values", log.String())
+	}
+	if !strings.HasPrefix(report.Blocks[0].Stdout, `{"data"`) {
+		t.Errorf("block stdout = %q, want the response body", report.Blocks[0].Stdout)
+	}
+}
+
+func TestHTTPBlockFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		block  string
+		want   string
+	}{
+		{
+			name:   "unexpected status",
+			status: http.StatusNotFound,
+			block:  "",
+			want:   "returned status 404, expected 2xx",
+		},
+		{
+			name:   "status not listed",
+			status: http.StatusOK,
+			block:  "    expect_status: 3xx\n",
+			want:   "returned status 200, expected 3xx",
+		},
+		{
+			name:   "response not JSON",
+			status: http.StatusOK,
+			body:   "plain",
+			block:  "    extract:\n      ID: $.id\n",
+			want:   "response is not JSON",
+		},
+		{
+			name:   "missing field",
+			status: http.StatusOK,
+			body:   `{"items": []}`,
+			block:  "    extract:\n      ID: $.items[0].id\n",
+			want:   "$.items[0] not found in the response",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newHTTPTestServer(t, tt.status, tt.body)
+			yamlData := "cmd:\n  - type: http\n    url: " + server.URL + "\n" + tt.block
+			report, err := RunfromyamlWithReport(context.Background(), []byte(yamlData), RunOptions{
+				Parallel: 1,
+				Stdout:   &strings.Builder{},
+				Log:      &strings.Builder{},
+			})
+			if err == nil {
+				t.Fatal("RunfromyamlWithReport() expected error")
+			}
+			if report.Blocks[0].Status != BlockStatusFailed || !strings.Contains(report.Blocks[0].Reason, tt.want) {
+				t.Errorf("block result = %+v, want a failure containing %q", report.Blocks[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPBlockBodyFile(t *testing.T) {
+	server, requests := newHTTPTestServer(t, http.StatusOK, "")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "payload.json"), []byte(`{"from": "file"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	yamlData := "cmd:\n  - type: http\n    method: POST\n    workdir: " + dir + "\n    url: " + server.URL + "\n    body_file: payload.json\n"
+	if err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, Stdout: io.Discard, Log: io.Discard}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	if got := requests(); len(got) != 1 || got[0].body != `{"from": "file"}` {
+		t.Errorf("requests = %+v, want the body of the file", got)
+	}
+}
+
+func TestHTTPBlockRestOutput(t *testing.T) {
+	server, _ := newHTTPTestServer(t, http.StatusOK, `{"ok": true}`)
+	recorder := httptest.NewRecorder()
+	previous := functions.RestOut
+	functions.RestOut = recorder
+	t.Cleanup(func() { functions.RestOut = previous })
+
+	yamlData := `
+logging:
+  - output: rest
+cmd:
+  - type: http
+    url: ` + server.URL + `
+    headers:
+      X-Api-Key: s3cret
+`
+	if err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, `{"ok": true}`) || !strings.Contains(body, "GET "+server.URL) {
+		t.Errorf("rest output = %q, want the request and the response", body)
+	}
+	if strings.Contains(body, "s3cret") {
+		t.Errorf("rest output = %q, want no header values", body)
+	}
+}
+
+func TestHTTPBlockRedactsURL(t *testing.T) {
+	server, _ := newHTTPTestServer(t, http.StatusOK, "")
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	var log strings.Builder
+	target := strings.Replace(server.URL, "http://", "http://deploy:s3cret@", 1) + "/apps?token=t0ken&page=2"
+	yamlData := "cmd:\n  - type: http\n    url: " + target + "\n  - type: http\n    url: " + closed.URL + "/apps?token=t0ken\n"
+	report, err := RunfromyamlWithReport(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, Stdout: io.Discard, Log: &log})
+	if err == nil {
+		t.Fatal("RunfromyamlWithReport() expected error for the closed server")
+	}
+
+	if !strings.Contains(log.String(), "/apps?page=xxxxx&token=xxxxx") {
+		t.Errorf("log = %q, want the query values hidden", log.String())
+	}
+	for _, text := range []string{log.String(), err.Error(), strings.Join(report.Blocks[0].Argv[0], " ")} {
+		if strings.Contains(text, "s3cret") || strings.Contains(text, "t0ken") {
+			t.Errorf("output %q contains the password or token of the URL", text)
+		}
+	}
+}
+
+func TestHTTPBlockLargeResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.CopyN(w, zeroReader{}, maxResponseSize+1)
+	}))
+	t.Cleanup(server.Close)
+
+	yamlData := "cmd:\n  - type: http\n    url: " + server.URL + "\n"
+	err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, Stdout: io.Discard, Log: io.Discard})
+	if err == nil || !strings.Contains(err.Error(), "response larger than") {
+		t.Errorf("RunfromyamlWithOptions() error = %v, want the response too large", err)
+	}
+}
+
+// zeroReader reads zero bytes without end
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestHTTPBlockResumeExtract(t *testing.T) {
+	server, requests := newHTTPTestServer(t, http.StatusOK, `{"id": 42}`)
+
+	stateFile := filepath.Join(t.TempDir(), "workflow.yaml"+StateFileSuffix)
+	workflow := func(status int) []byte {
+		return []byte(fmt.Sprintf(`
+cmd:
+  - type: http
+    name: create
+    url: %[1]s/apps
+    extract:
+      APP_ID: $.id
+  - type: http
+    expandenv: true
+    url: %[1]s/apps/$APP_ID
+    expect_status: %[2]d
+`, server.URL, status))
+	}
+
+	options := RunOptions{Parallel: 1, StateFile: stateFile, Stdout: io.Discard, Log: io.Discard}
+	if err := RunfromyamlWithOptions(context.Background(), workflow(404), options); err == nil {
+		t.Fatal("RunfromyamlWithOptions() expected error for the unexpected status")
+	}
+
+	// Resuming skips the first request but keeps the extracted variable
+	options.Resume = true
+	if err := RunfromyamlWithOptions(context.Background(), workflow(200), options); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error on resume: %v", err)
+	}
+
+	var paths []string
+	for _, request := range requests() {
+		paths = append(paths, request.path)
+	}
+	if want := []string{"/apps", "/apps/42", "/apps/42"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("requests = %q, want %q", paths, want)
+	}
+}
+
+func TestHTTPBlockDryRun(t *testing.T) {
+	server, requests := newHTTPTestServer(t, http.StatusOK, "")
+
+	var log strings.Builder
+	yamlData := "cmd:\n  - type: http\n    method: DELETE\n    url: " + server.URL + "/apps/1\n"
+	if err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, DryRun: true, Log: &log}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	if !strings.Contains(log.String(), "would send DELETE "+server.URL+"/apps/1") {
+		t.Errorf("log = %q, want the planned request", log.String())
+	}
+	if got := requests(); len(got) != 0 {
+		t.Errorf("dry run sent %d requests", len(got))
+	}
+}
+
+func TestHTTPBlockValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  string
+	}{
+		{name: "missing url", block: "method: GET", want: "requires 'url' field"},
+		{name: "invalid method", block: "url: http://localhost\n    method: GET /", want: "invalid method"},
+		{name: "body and file", block: "url: http://localhost\n    body: a\n    body_file: b", want: "can't be used together"},
+		{name: "invalid status", block: "url: http://localhost\n    expect_status: 2x", want: "invalid expect_status"},
+		{name: "invalid extract name", block: "url: http://localhost\n    extract:\n      1ID: $.id", want: "invalid extract name"},
+		{name: "invalid extract path", block: "url: http://localhost\n    extract:\n      ID: data.id", want: "must start with $"},
+		{name: "values", block: "url: http://localhost\n    values: [a]", want: "values are not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateWorkflow([]byte("cmd:\n  - type: http\n    "+tt.block+"\n"), RunOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ValidateWorkflow() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusExpected(t *testing.T) {
+	tests := []struct {
+		code   int
+		expect []string
+		want   bool
+	}{
+		{200, []string{"2xx"}, true},
+		{204, []string{"2XX"}, true},
+		{301, []string{"2xx"}, false},
+		{404, []string{"200", "404"}, true},
+		{500, []string{"200", "404"}, false},
+	}
+	for _, tt := range tests {
+		if got := statusExpected(tt.code, tt.expect); got != tt.want {
+			t.Errorf("statusExpected(%d, %v) = %v, want %v", tt.code, tt.expect, got, tt.want)
+		}
+	}
+}
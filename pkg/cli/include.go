@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// workflowDocument is a parsed workflow file. The name is empty for the
+// workflow that was run, and the path of the file for included workflows.
+type workflowDocument struct {
+	name     string
+	workflow *Workflow
+}
+
+// includeResolver loads the workflows included by a document
+type includeResolver struct {
+	loaded    map[string]bool
+	stack     []string
+	documents []workflowDocument
+}
+
+// resolveIncludes returns all workflows included by workflow followed by
+// workflow itself, in the order their sections are merged. Includes are resolved
+// relative to the directory of file, or the working directory if file is
+// empty. Variables in include paths are expanded with the env sections of the
+// including files and env. Every file is only included once, include cycles
+// are an error.
+func resolveIncludes(workflow *Workflow, file string, env *Environment) ([]workflowDocument, error) {
+	r := &includeResolver{loaded: make(map[string]bool)}
+
+	if file != "" {
+		path, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		r.loaded[path] = true
+		r.stack = append(r.stack, path)
+	}
+
+	if err := r.resolve(workflow, filepath.Dir(file), env.resolve); err != nil {
+		return nil, err
+	}
+	r.documents = append(r.documents, workflowDocument{workflow: workflow})
+	return r.documents, nil
+}
+
+// resolve loads the includes of workflow, recursively adding them to the
+// documents. The env section of workflow takes precedence over the variables
+// of lookup.
+func (r *includeResolver) resolve(workflow *Workflow, dir string, lookup func(string) (string, bool)) error {
+	vars := make(map[string]string)
+	for _, entry := range workflow.Env {
+		if entry.Key != "" {
+			vars[entry.Key] = entry.Value
+		}
+	}
+	scoped := func(key string) (string, bool) {
+		if value, ok := vars[key]; ok {
+			return value, true
+		}
+		return lookup(key)
+	}
+
+	for _, include := range workflow.Include {
+		name := os.Expand(include, func(key string) string {
+			value, _ := scoped(key)
+			return value
+		})
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		path, err := filepath.Abs(name)
+		if err != nil {
+			return err
+		}
+
+		for i, parent := range r.stack {
+			if parent == path {
+				cycle := append(append([]string{}, r.stack[i:]...), path)
+				return fmt.Errorf("include cycle detected: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		if r.loaded[path] {
+			continue
+		}
+		r.loaded[path] = true
+
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("failed to read include %q: %w", include, err)
+		}
+		included, err := ParseWorkflow(data, name)
+		if err != nil {
+			// Decode errors already name the file
+			var decodeErr *DecodeError
+			if errors.As(err, &decodeErr) {
+				return err
+			}
+			return fmt.Errorf("failed to parse include %s: %w", name, err)
+		}
+
+		r.stack = append(r.stack, path)
+		if err := r.resolve(included, filepath.Dir(name), scoped); err != nil {
+			return err
+		}
+		r.stack = r.stack[:len(r.stack)-1]
+
+		r.documents = append(r.documents, workflowDocument{name: name, workflow: included})
+	}
+	return nil
+}
+
+// mergeDocuments combines the env and logging sections of all documents.
+// Entries of later documents take precedence, so the including workflow
+// overrides what it includes. Other top-level settings like timeout are
+// taken from the last document defining them. Command blocks are left out,
+// they are parsed per document to keep their source.
+func mergeDocuments(documents []workflowDocument) *Workflow {
+	merged := &Workflow{}
+	for _, d := range documents {
+		w := d.workflow
+		merged.Env = append(merged.Env, w.Env...)
+		merged.Logging = append(merged.Logging, w.Logging...)
+		if w.Options != nil {
+			merged.Options = w.Options
+		}
+		if w.Shell != "" {
+			merged.Shell = w.Shell
+		}
+		if w.Strict != nil {
+			merged.Strict = w.Strict
+		}
+		if w.Timeout != "" {
+			merged.Timeout = w.Timeout
+		}
+	}
+	return merged
+}
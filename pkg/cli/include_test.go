@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeWorkflows creates the given files below dir
+func writeWorkflows(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestRunfromyamlWithOptionsInclude(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	writeWorkflows(t, dir, map[string]string{
+		"common/base.yaml": `
+include:
+  - shared.yaml
+logging:
+  - level: info
+  - output: stdout
+env:
+  - key: RFY_TEST_GREETING
+    value: hello
+  - key: RFY_TEST_TARGET
+    value: base
+cmd:
+  - type: shell
+    name: base
+    values:
+      - echo "base $RFY_TEST_GREETING $RFY_TEST_TARGET" >> $RFY_TEST_OUT
+`,
+		"common/shared.yaml": `
+cmd:
+  - type: shell
+    name: shared
+    values:
+      - echo shared >> $RFY_TEST_OUT
+`,
+		"other.yaml": `
+include: common/shared.yaml
+cmd:
+  - type: shell
+    name: other
+    needs: base
+    values:
+      - echo other >> $RFY_TEST_OUT
+`,
+	})
+
+	mainFile := filepath.Join(dir, "main.yaml")
+	yamlData := `
+include:
+  - common/base.yaml
+  - other.yaml
+logging:
+  - output: file
+env:
+  - key: RFY_TEST_TARGET
+    value: main
+  - key: RFY_TEST_OUT
+    value: ` + filepath.Join(dir, "out") + `
+cmd:
+  - type: shell
+    needs: [shared, other]
+    values:
+      - echo main >> $RFY_TEST_OUT
+`
+
+	err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{File: mainFile, Parallel: 1})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+
+	out, _ := os.ReadFile(filepath.Join(dir, "out"))
+	want := "shared\nbase hello main\nother\nmain\n"
+	if string(out) != want {
+		t.Errorf("blocks wrote %q, want %q", out, want)
+	}
+}
+
+func TestRunfromyamlWithOptionsIncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeWorkflows(t, dir, map[string]string{
+		"a.yaml": "include: b.yaml\n",
+		"b.yaml": "include: [a.yaml]\n",
+		"invalid.yaml": `
+cmd:
+  - type: unknown
+    values:
+      - echo
+`,
+		"needs.yaml": `
+cmd:
+  - type: shell
+    needs: missing
+    values:
+      - echo
+`,
+	})
+
+	tests := []struct {
+		name    string
+		include string
+		wantErr string
+	}{
+		{"cycle", "a.yaml", "include cycle detected: " + filepath.Join(dir, "a.yaml") + " -> " + filepath.Join(dir, "b.yaml") + " -> " + filepath.Join(dir, "a.yaml")},
+		{"missing file", "missing.yaml", `failed to read include "missing.yaml"`},
+		{"invalid block", "invalid.yaml", filepath.Join(dir, "invalid.yaml") + `:3:11: unknown type "unknown" in command block 1`},
+		{"unknown needs", "needs.yaml", "command block 1 (shell #1) in " + filepath.Join(dir, "needs.yaml") + `: needs unknown block "missing"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yamlData := "include: " + tt.include + "\n"
+			err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{File: filepath.Join(dir, "main.yaml"), Parallel: 1})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("RunfromyamlWithOptions() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunfromyamlWithOptionsIncludeEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping echo based test on Windows")
+	}
+
+	dir := t.TempDir()
+	writeWorkflows(t, dir, map[string]string{
+		"prod/base.yaml": "include: ${RFY_TEST_ROLE}.yaml\n",
+		"prod/web.yaml": `
+cmd:
+  - type: exec
+    name: web
+    values:
+      - echo web
+`,
+	})
+
+	yamlData := `
+include:
+  - ${RFY_TEST_STAGE}/base.yaml
+env:
+  - key: RFY_TEST_STAGE
+    value: prod
+`
+	var stdout strings.Builder
+	err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{
+		File:     filepath.Join(dir, "main.yaml"),
+		Parallel: 1,
+		Env:      map[string]string{"RFY_TEST_ROLE": "web"},
+		Stdout:   &stdout,
+		Log:      &strings.Builder{},
+	})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	if stdout.String() != "web\n" {
+		t.Errorf("stdout = %q, want the block of the included file", stdout.String())
+	}
+}
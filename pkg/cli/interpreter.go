@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultShell runs shell blocks without a shell option or workflow default
+const defaultShell = "bash"
+
+// interpreter describes how the values of a shell block are run by a program
+type interpreter struct {
+	program string
+	args    []string
+	// scriptExt is set for interpreters that are given the values in a
+	// temporary script file instead of on the command line
+	scriptExt string
+	// strict is prepended to the script of blocks with strict error
+	// handling, interpreters without it don't support the strict option
+	strict string
+}
+
+// interpreters lists the programs shell blocks can be run with
+var interpreters = map[string]interpreter{
+	"bash":       {program: "bash", args: []string{"-c"}, strict: "set -euo pipefail"},
+	"sh":         {program: "sh", args: []string{"-c"}, strict: "set -eu"},
+	"zsh":        {program: "zsh", args: []string{"-c"}, strict: "set -euo pipefail"},
+	"fish":       {program: "fish", args: []string{"-c"}},
+	"cmd":        {program: "cmd", args: []string{"/C"}},
+	"pwsh":       {program: "pwsh", args: []string{"-NoProfile", "-NonInteractive", "-File"}, scriptExt: ".ps1"},
+	"powershell": {program: "powershell", args: []string{"-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File"}, scriptExt: ".ps1"},
+	"python3":    {program: "python3", scriptExt: ".py"},
+	"node":       {program: "node", scriptExt: ".js"},
+}
+
+// validateShell checks that a block or workflow shell option names a known
+// interpreter
+func validateShell(shell string) error {
+	if shell == "" {
+		return nil
+	}
+	if _, ok := interpreters[shell]; !ok {
+		names := make([]string, 0, len(interpreters))
+		for name := range interpreters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("invalid shell %q: must be one of %s", shell, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// strictFor reports whether a block runs with strict error handling. The
+// strict option of the workflow only applies to interpreters supporting it.
+func (e *CommandExecutor) strictFor(cmd *Command) bool {
+	if cmd.Strict != nil {
+		return *cmd.Strict
+	}
+	return e.config.Strict && interpreters[e.shellFor(cmd)].strict != ""
+}
+
+// shellFor returns the interpreter name of a block, falling back to the
+// workflow default and bash
+func (e *CommandExecutor) shellFor(cmd *Command) string {
+	switch {
+	case cmd.Shell != "":
+		return cmd.Shell
+	case e.config.Shell != "":
+		return e.config.Shell
+	}
+	return defaultShell
+}
+
+// script returns the text run by the interpreter for a block. The script
+// option is used as written. Values are joined into a single command line for
+// shells, as they are separated by semicolons already, and into one line per
+// value for script files or when newlines are preserved. Empty values are
+// left out.
+func (ip interpreter) script(cmd *Command, strict bool) (string, error) {
+	values := make([]string, 0, len(cmd.Values))
+	for _, value := range cmd.Values {
+		if strings.TrimSpace(value) != "" {
+			values = append(values, value)
+		}
+	}
+
+	var script string
+	switch {
+	case cmd.Script != "":
+		script = cmd.Script
+	case cmd.PreserveNewlines || ip.scriptExt != "":
+		script = strings.Join(values, "\n")
+	default:
+		script = strings.Join(values, " ")
+	}
+
+	if strict {
+		if ip.strict == "" {
+			return "", fmt.Errorf("strict is not supported by %s", ip.program)
+		}
+		script = ip.strict + "\n" + script
+	}
+	if ip.scriptExt != "" && !strings.HasSuffix(script, "\n") {
+		script += "\n"
+	}
+	return script, nil
+}
+
+// command returns the command line running script. Interpreters that read
+// a script file get a temporary one, which is removed by cleanup.
+func (ip interpreter) command(script string) (argv []string, cleanup func(), err error) {
+	argv = append([]string{ip.program}, ip.args...)
+	if ip.scriptExt == "" {
+		return append(argv, script), func() {}, nil
+	}
+
+	file, err := os.CreateTemp("", "runfromyaml-*"+ip.scriptExt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create script file: %w", err)
+	}
+	cleanup = func() { _ = os.Remove(file.Name()) }
+	if _, err := file.WriteString(script); err != nil {
+		_ = file.Close()
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to write script file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to write script file: %w", err)
+	}
+	return append(argv, file.Name()), cleanup, nil
+}
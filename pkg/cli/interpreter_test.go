@@ -0,0 +1,240 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRunfromyamlWithOptionsShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping shell interpreter test on Windows")
+	}
+
+	tests := []struct {
+		name   string
+		shell  string
+		values string
+		want   string
+	}{
+		{"sh", "sh", `echo "$0"`, "sh\n"},
+		{"bash", "bash", `echo "${BASH_VERSION:+from bash}"`, "from bash\n"},
+		{"python3", "python3", "import os\n      - print('from ' + os.environ['RFY_LANGUAGE'])", "from python\n"},
+		{"node", "node", "console.log('from node')", "from node\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := exec.LookPath(tt.shell); err != nil {
+				t.Skipf("%s is not installed", tt.shell)
+			}
+
+			dir := t.TempDir()
+			workflow := []byte(fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+env:
+  - key: RFY_LANGUAGE
+    value: python
+cmd:
+  - type: shell
+    shell: %s
+    register: OUT
+    values:
+      - %s
+  - type: shell
+    values:
+      - printf '%%s\n' "$OUT" > %s/out
+`, tt.shell, tt.values, dir))
+
+			if err := RunfromyamlWithOptions(context.Background(), workflow, RunOptions{Parallel: 1}); err != nil {
+				t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+			}
+			out, _ := os.ReadFile(filepath.Join(dir, "out"))
+			if string(out) != tt.want {
+				t.Errorf("%s block wrote %q, want %q", tt.shell, out, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunfromyamlWithOptionsWorkflowShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping shell interpreter test on Windows")
+	}
+
+	dir := t.TempDir()
+	workflow := []byte(fmt.Sprintf(`
+shell: sh
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: shell
+    unless: test "$0" != sh
+    values:
+      - test "$0" = sh && touch %[1]s/sh
+  - type: shell
+    shell: bash
+    values:
+      - test "$0" = bash && touch %[1]s/bash
+`, dir))
+
+	if err := RunfromyamlWithOptions(context.Background(), workflow, RunOptions{Parallel: 1}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	for _, name := range []string{"sh", "bash"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("block run with %s didn't create its file: %v", name, err)
+		}
+	}
+}
+
+func TestRunfromyamlWithOptionsInvalidShell(t *testing.T) {
+	tests := []struct {
+		name     string
+		workflow string
+		want     string
+	}{
+		{"block", "cmd:\n  - type: shell\n    shell: csh\n    values: [echo]\n", `invalid shell "csh"`},
+		{"workflow", "shell: ksh\ncmd: []\n", `invalid workflow shell: invalid shell "ksh"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RunfromyamlWithOptions(context.Background(), []byte(tt.workflow), RunOptions{Parallel: 1})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("RunfromyamlWithOptions() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDryRunScriptInterpreter(t *testing.T) {
+	var plan strings.Builder
+	workflow := []byte(`
+cmd:
+  - type: shell
+    shell: python3
+    values:
+      - import sys
+      - print(sys.version)
+`)
+
+	err := RunfromyamlWithOptions(context.Background(), workflow, RunOptions{Parallel: 1, DryRun: true, Plan: &plan})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	want := "# [dry-run] would run python3 with script:\n    import sys\n    print(sys.version)\n"
+	if !strings.Contains(plan.String(), want) {
+		t.Errorf("plan = %q, want it to contain %q", plan.String(), want)
+	}
+}
+
+func TestInterpreterScript(t *testing.T) {
+	strict, lax := true, false
+
+	tests := []struct {
+		name   string
+		shell  string
+		cmd    *Command
+		strict bool
+		want   string
+	}{
+		{"values joined", "bash", &Command{Values: []string{"echo a;", "", "echo b"}}, false, "echo a; echo b"},
+		{"preserve newlines", "bash", &Command{Values: []string{"if true; then", "echo a", "fi"}, PreserveNewlines: true}, false, "if true; then\necho a\nfi"},
+		{"script as written", "bash", &Command{Script: "cat <<EOF\n  a  b\nEOF\n"}, false, "cat <<EOF\n  a  b\nEOF\n"},
+		{"strict bash", "bash", &Command{Script: "false\n", Strict: &strict}, true, "set -euo pipefail\nfalse\n"},
+		{"strict sh", "sh", &Command{Values: []string{"true"}}, true, "set -eu\ntrue"},
+		{"script file", "python3", &Command{Values: []string{"import os", "print(1)"}}, false, "import os\nprint(1)\n"},
+		{"not strict", "bash", &Command{Values: []string{"true"}, Strict: &lax}, false, "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := interpreters[tt.shell].script(tt.cmd, tt.strict)
+			if err != nil {
+				t.Fatalf("script() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("script() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := interpreters["fish"].script(&Command{Values: []string{"true"}}, true); err == nil {
+		t.Error("script() expected error for strict fish block")
+	}
+}
+
+func TestRunfromyamlWithOptionsScript(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	workflow := func(strict string) []byte {
+		return []byte(fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+%[2]s
+cmd:
+  - type: shell
+    name: heredoc
+    script: |
+      if true; then
+        cat > %[1]s/out <<EOF
+      first
+        second
+      EOF
+      fi
+  - type: shell
+    name: pipeline
+    values:
+      - false | true;
+      - echo survived > %[1]s/pipeline
+`, dir, strict))
+	}
+
+	if err := RunfromyamlWithOptions(context.Background(), workflow(""), RunOptions{Parallel: 1}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	out, _ := os.ReadFile(filepath.Join(dir, "out"))
+	if want := "first\n  second\n"; string(out) != want {
+		t.Errorf("script wrote %q, want %q", out, want)
+	}
+
+	// With strict error handling the failing pipeline stops the block
+	_ = os.Remove(filepath.Join(dir, "pipeline"))
+	if err := RunfromyamlWithOptions(context.Background(), workflow("strict: true"), RunOptions{Parallel: 1}); err == nil {
+		t.Error("RunfromyamlWithOptions() expected error for failing pipeline in strict mode")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pipeline")); err == nil {
+		t.Error("strict block kept running after the pipeline failed")
+	}
+}
+
+func TestRunfromyamlWithOptionsScriptInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		workflow string
+		want     string
+	}{
+		{"script and values", "cmd:\n  - type: shell\n    script: echo a\n    values: [echo b]\n", "script and values can't be used together"},
+		{"script on exec", "cmd:\n  - type: exec\n    script: echo a\n", "only supported by shell blocks"},
+		{"strict fish", "cmd:\n  - type: shell\n    shell: fish\n    strict: true\n    values: [echo]\n", "strict is not supported by fish"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RunfromyamlWithOptions(context.Background(), []byte(tt.workflow), RunOptions{Parallel: 1})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("RunfromyamlWithOptions() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
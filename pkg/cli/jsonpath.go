@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a parsed JSONPath expression. Only the subset selecting a
+// single value is supported: $ followed by .key, ['key'] and [index] steps.
+// Negative indexes count from the end of an array.
+type jsonPath []jsonPathStep
+
+// jsonPathStep selects a key of an object or an element of an array
+type jsonPathStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// String returns the step as it is written in an expression
+func (s jsonPathStep) String() string {
+	if s.isIndex {
+		return fmt.Sprintf("[%d]", s.index)
+	}
+	return "." + s.key
+}
+
+// parseJSONPath parses a JSONPath expression like $.items[0].name
+func parseJSONPath(expr string) (jsonPath, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("%q must start with $", expr)
+	}
+	var path jsonPath
+	rest := expr[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("%q has an empty key", expr)
+			}
+			path = append(path, jsonPathStep{key: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			if len(rest) > 1 && (rest[1] == '\'' || rest[1] == '"') {
+				end := strings.IndexByte(rest[2:], rest[1])
+				if end < 0 || !strings.HasPrefix(rest[2+end+1:], "]") {
+					return nil, fmt.Errorf("%q has an unterminated key", expr)
+				}
+				path = append(path, jsonPathStep{key: rest[2 : 2+end]})
+				rest = rest[2+end+2:]
+				continue
+			}
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%q has an unterminated index", expr)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("%q has an invalid index %q", expr, rest[1:end])
+			}
+			path = append(path, jsonPathStep{index: index, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("%q has an unexpected %q", expr, rest[:1])
+		}
+	}
+	return path, nil
+}
+
+// lookup returns the value the path selects in a decoded JSON document
+func (p jsonPath) lookup(document interface{}) (interface{}, error) {
+	value := document
+	selected := "$"
+	for _, step := range p {
+		selected += step.String()
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if step.isIndex {
+				return nil, fmt.Errorf("%s: not an array", selected)
+			}
+			item, ok := v[step.key]
+			if !ok {
+				return nil, fmt.Errorf("%s not found in the response", selected)
+			}
+			value = item
+		case []interface{}:
+			if !step.isIndex {
+				return nil, fmt.Errorf("%s: not an object", selected)
+			}
+			index := step.index
+			if index < 0 {
+				index += len(v)
+			}
+			if index < 0 || index >= len(v) {
+				return nil, fmt.Errorf("%s not found in the response", selected)
+			}
+			value = v[index]
+		default:
+			return nil, fmt.Errorf("%s not found in the response", selected)
+		}
+	}
+	return value, nil
+}
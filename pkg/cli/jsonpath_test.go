@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	var document interface{}
+	if err := json.Unmarshal([]byte(`{"a": {"b-c": [1, {"d": "x"}]}, "e": null}`), &document); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr    string
+		want    interface{}
+		wantErr string
+	}{
+		{expr: "$.a['b-c'][0]", want: float64(1)},
+		{expr: `$["a"]["b-c"][1].d`, want: "x"},
+		{expr: "$.a.b-c[-1].d", want: "x"},
+		{expr: "$.e", want: nil},
+		{expr: "a.b", wantErr: "must start with $"},
+		{expr: "$.a..b", wantErr: "empty key"},
+		{expr: "$.a[x]", wantErr: "invalid index"},
+		{expr: "$.a['b", wantErr: "unterminated key"},
+		{expr: "$a", wantErr: "unexpected"},
+		{expr: "$.missing", wantErr: "$.missing not found"},
+		{expr: "$.a['b-c'][2]", wantErr: "$.a.b-c[2] not found"},
+		{expr: "$.a[0]", wantErr: "not an array"},
+		{expr: "$.a['b-c'].d", wantErr: "not an object"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			path, err := parseJSONPath(tt.expr)
+			var got interface{}
+			if err == nil {
+				got, err = path.lookup(document)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("lookup = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// foreachVariable is the name of the loop variable of foreach blocks
+const foreachVariable = "item"
+
+// loopVarPattern matches $name and ${name} references
+var loopVarPattern = regexp.MustCompile(`\$\{(\w+)\}|\$(\w+)`)
+
+// Loop repeats a command block for every combination of its variables
+type Loop struct {
+	// Vars are the names of the loop variables, "item" for foreach
+	Vars []string
+	// Items holds the values of every variable. An entry that is just a
+	// variable reference like $HOSTS is replaced by the value of that
+	// variable at run time, split on whitespace and commas.
+	Items [][]string
+}
+
+// parseLoop converts the foreach or matrix section of a command block
+func parseLoop(foreach StringList, matrix map[string]StringList) (*Loop, error) {
+	switch {
+	case foreach != nil && matrix != nil:
+		return nil, fmt.Errorf("foreach and matrix can't be used together")
+	case foreach != nil:
+		return &Loop{Vars: []string{foreachVariable}, Items: [][]string{foreach}}, nil
+	case matrix != nil:
+		if len(matrix) == 0 {
+			return nil, fmt.Errorf("matrix must be a map of variable names to lists")
+		}
+
+		loop := &Loop{}
+		for k := range matrix {
+			loop.Vars = append(loop.Vars, k)
+		}
+		sort.Strings(loop.Vars)
+
+		for _, name := range loop.Vars {
+			if !registerNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("invalid matrix variable name %q", name)
+			}
+			loop.Items = append(loop.Items, matrix[name])
+		}
+		return loop, nil
+	}
+	return nil, nil
+}
+
+// iterations returns the values of the loop variables for every run of the
+// block, resolving variable references with env
+func (l *Loop) iterations(env *Environment) []map[string]string {
+	iterations := []map[string]string{{}}
+	for i, name := range l.Vars {
+		values := resolveLoopItems(l.Items[i], env)
+
+		var next []map[string]string
+		for _, iteration := range iterations {
+			for _, value := range values {
+				vars := make(map[string]string, len(iteration)+1)
+				for k, v := range iteration {
+					vars[k] = v
+				}
+				vars[name] = value
+				next = append(next, vars)
+			}
+		}
+		iterations = next
+	}
+	return iterations
+}
+
+// describe formats the loop variables of one iteration for log messages
+func (l *Loop) describe(vars map[string]string) string {
+	parts := make([]string, len(l.Vars))
+	for i, name := range l.Vars {
+		parts[i] = name + "=" + vars[name]
+	}
+	return strings.Join(parts, " ")
+}
+
+// resolveLoopItems replaces variable references by the items they hold
+func resolveLoopItems(items []string, env *Environment) []string {
+	var values []string
+	for _, item := range items {
+		match := loopVarPattern.FindStringSubmatch(item)
+		if match == nil || match[0] != item {
+			values = append(values, item)
+			continue
+		}
+
+		name := match[1] + match[2]
+		value, _ := env.resolve(name)
+		values = append(values, strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})...)
+	}
+	return values
+}
+
+// withLoopVars returns a copy of the command in which references to the loop
+// variables are replaced by their values for one iteration. Other variables
+// are left for the usual expansion.
+func (c *Command) withLoopVars(vars map[string]string) *Command {
+	expand := func(s string) string {
+		return loopVarPattern.ReplaceAllStringFunc(s, func(ref string) string {
+			match := loopVarPattern.FindStringSubmatch(ref)
+			if value, ok := vars[match[1]+match[2]]; ok {
+				return value
+			}
+			return ref
+		})
+	}
+
+	iteration := *c
+	iteration.Loop = nil
+	iteration.Description = expand(c.Description)
+	iteration.Script = expand(c.Script)
+	iteration.Workdir = expand(c.Workdir)
+	if c.EnvVars != nil {
+		iteration.EnvVars = make(map[string]string, len(c.EnvVars))
+		for key, value := range c.EnvVars {
+			iteration.EnvVars[key] = expand(value)
+		}
+	}
+	iteration.Creates = expand(c.Creates)
+	iteration.Unless = expand(c.Unless)
+	iteration.OnlyIf = expand(c.OnlyIf)
+	iteration.Values = make([]string, len(c.Values))
+	for i, value := range c.Values {
+		iteration.Values[i] = expand(value)
+	}
+	iteration.Argv = make([][]string, len(c.Argv))
+	for i, argv := range c.Argv {
+		iteration.Argv[i] = make([]string, len(argv))
+		for j, arg := range argv {
+			iteration.Argv[i][j] = expand(arg)
+		}
+	}
+	if c.Options != nil {
+		iteration.Options = expandStrings(c.Options, expand).(map[string]interface{})
+	}
+	return &iteration
+}
@@ -0,0 +1,253 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseLoop(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    *Loop
+		wantErr string
+	}{
+		{"no loop", `{}`, nil, ""},
+		{"foreach list", `{foreach: [a, b]}`, &Loop{Vars: []string{"item"}, Items: [][]string{{"a", "b"}}}, ""},
+		{"foreach variable", `{foreach: $HOSTS}`, &Loop{Vars: []string{"item"}, Items: [][]string{{"$HOSTS"}}}, ""},
+		{
+			"matrix",
+			`{matrix: {os: [linux, darwin], arch: [amd64]}}`,
+			&Loop{Vars: []string{"arch", "os"}, Items: [][]string{{"amd64"}, {"linux", "darwin"}}},
+			"",
+		},
+		{"empty foreach", `{foreach: []}`, &Loop{Vars: []string{"item"}, Items: [][]string{{}}}, ""},
+		{"both", `{foreach: [a], matrix: {x: [b]}}`, nil, "can't be used together"},
+		{"empty matrix", `{matrix: {}}`, nil, "matrix must be a map"},
+		{"matrix invalid name", `{matrix: {my-var: [a]}}`, nil, `invalid matrix variable name "my-var"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var block Block
+			if err := yaml.Unmarshal([]byte(tt.yaml), &block); err != nil {
+				t.Fatalf("Failed to parse test YAML: %v", err)
+			}
+
+			got, err := parseLoop(block.Foreach, block.Matrix)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseLoop() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLoop() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLoop() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoopIterations(t *testing.T) {
+	env := NewEnvironment()
+	env.Set("RFY_TEST_HOSTS", "web1, web2\nweb3")
+
+	loop := &Loop{
+		Vars:  []string{"host", "port"},
+		Items: [][]string{{"db", "$RFY_TEST_HOSTS"}, {"22", "2222"}},
+	}
+
+	var got []string
+	for _, vars := range loop.iterations(env) {
+		got = append(got, loop.describe(vars))
+	}
+
+	want := []string{
+		"host=db port=22", "host=db port=2222",
+		"host=web1 port=22", "host=web1 port=2222",
+		"host=web2 port=22", "host=web2 port=2222",
+		"host=web3 port=22", "host=web3 port=2222",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("iterations() = %v, want %v", got, want)
+	}
+}
+
+func TestCommandWithLoopVars(t *testing.T) {
+	cmd := &Command{
+		Type:        CommandTypeSSH,
+		Description: "deploy to $item",
+		Values:      []string{"echo ${item} $HOME ${items}"},
+		Options: map[string]interface{}{
+			"host":    "${item}",
+			"options": []interface{}{"-p", "$item"},
+			"port":    22,
+			"extract": map[interface{}]interface{}{"ID": "$item"},
+		},
+		Loop: &Loop{Vars: []string{"item"}, Items: [][]string{{"web1"}}},
+	}
+
+	got := cmd.withLoopVars(map[string]string{"item": "web1"})
+
+	if got.Loop != nil {
+		t.Error("withLoopVars() kept the loop")
+	}
+	if got.Description != "deploy to web1" {
+		t.Errorf("Description = %q, want %q", got.Description, "deploy to web1")
+	}
+	if want := []string{"echo web1 $HOME ${items}"}; !reflect.DeepEqual(got.Values, want) {
+		t.Errorf("Values = %q, want %q", got.Values, want)
+	}
+	wantOptions := map[string]interface{}{
+		"host":    "web1",
+		"options": []interface{}{"-p", "web1"},
+		"port":    22,
+		"extract": map[interface{}]interface{}{"ID": "web1"},
+	}
+	if !reflect.DeepEqual(got.Options, wantOptions) {
+		t.Errorf("Options = %+v, want %+v", got.Options, wantOptions)
+	}
+	if cmd.Values[0] != "echo ${item} $HOME ${items}" || cmd.Options["host"] != "${item}" || cmd.Options["options"].([]interface{})[1] != "$item" {
+		t.Error("withLoopVars() modified the original command")
+	}
+}
+
+func TestRunfromyamlWithOptionsLoops(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	yamlData := fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+env:
+  - key: RFY_TEST_FILES
+    value: one two
+cmd:
+  - type: conf
+    foreach: $RFY_TEST_FILES
+    confdest: %[1]s/${item}.conf
+    confperm: 0644
+    confdata: |
+      name=${item}
+  - type: shell
+    matrix:
+      os: [linux, darwin]
+      arch: [amd64, arm64]
+    values:
+      - echo ${os}-${arch} >> %[1]s/matrix
+`, dir)
+
+	if err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"one", "two"} {
+		data, err := os.ReadFile(filepath.Join(dir, name+".conf"))
+		if err != nil {
+			t.Errorf("Failed to read %s.conf: %v", name, err)
+			continue
+		}
+		if !strings.Contains(string(data), "name="+name) {
+			t.Errorf("%s.conf = %q, want name=%s", name, data, name)
+		}
+	}
+
+	matrix, _ := os.ReadFile(filepath.Join(dir, "matrix"))
+	want := "linux-amd64\ndarwin-amd64\nlinux-arm64\ndarwin-arm64\n"
+	if string(matrix) != want {
+		t.Errorf("matrix runs = %q, want %q", matrix, want)
+	}
+}
+
+func TestRunfromyamlWithOptionsLoopsBlockKeys(t *testing.T) {
+	server, requests := newHTTPTestServer(t, http.StatusOK, `{"GET": 1, "DELETE": 2}`)
+
+	dir := t.TempDir()
+	yamlData := fmt.Sprintf(`
+cmd:
+  - type: http
+    foreach: [GET, DELETE]
+    method: ${item}
+    url: %[1]s/apps
+    extract:
+      ID: $.${item}
+  - type: http
+    expandenv: true
+    method: PUT
+    url: %[1]s/apps/${ID}
+  - type: file
+    foreach: [directory, touch]
+    state: ${item}
+    dest: %[2]s/${item}
+`, server.URL, dir)
+
+	if err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, Stdout: io.Discard, Log: io.Discard}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, request := range requests() {
+		got = append(got, request.method+" "+request.path)
+	}
+	if want := []string{"GET /apps", "DELETE /apps", "PUT /apps/2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "directory")); err != nil || !info.IsDir() {
+		t.Errorf("Stat(directory) = %v, %v, want a directory", info, err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "touch")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("Stat(touch) = %v, %v, want a file", info, err)
+	}
+}
+
+func TestRunfromyamlWithOptionsLoopsExpandEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	yamlData := `
+env:
+  - key: RFY_TEST_PREFIX
+    value: pre
+cmd:
+  - type: shell
+    expandenv: true
+    foreach: [a, b]
+    env:
+      RFY_TEST_ITEM: ${RFY_TEST_PREFIX}-${item}
+    values:
+      - echo 'item=${item}' "$RFY_TEST_ITEM"
+  - type: exec
+    expandenv: true
+    matrix:
+      os: [linux]
+      arch: [amd64]
+    values:
+      - echo $RFY_TEST_PREFIX ${os}-${arch}
+`
+	var stdout strings.Builder
+	err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, Stdout: &stdout, Log: &strings.Builder{}})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	want := "item=a pre-a\nitem=b pre-b\npre linux-amd64\n"
+	if stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+}
@@ -0,0 +1,174 @@
+package cli
+
+import "fmt"
+
+// Workflow is the content of a workflow file
+type Workflow struct {
+	Options []OptionEntry  `yaml:"options,omitempty"`
+	Logging []LoggingEntry `yaml:"logging,omitempty"`
+	Env     []EnvEntry     `yaml:"env,omitempty"`
+	// Include lists workflow files whose sections are merged into this one
+	Include StringList `yaml:"include,omitempty"`
+	// Shell is the default interpreter of shell blocks
+	Shell string `yaml:"shell,omitempty"`
+	// Strict enables strict error handling for shell blocks by default
+	Strict *bool `yaml:"strict,omitempty"`
+	// Timeout limits the whole run
+	Timeout   Duration `yaml:"timeout,omitempty"`
+	Cmd       []Block  `yaml:"cmd,omitempty"`
+	OnFailure []Block  `yaml:"on_failure,omitempty"`
+	Finally   []Block  `yaml:"finally,omitempty"`
+}
+
+// section returns the blocks of the cmd, on_failure or finally section
+func (w *Workflow) section(key string) []Block {
+	switch key {
+	case "cmd":
+		return w.Cmd
+	case "on_failure":
+		return w.OnFailure
+	case "finally":
+		return w.Finally
+	}
+	return nil
+}
+
+// OptionEntry is an entry of the options section, see the config package
+type OptionEntry struct {
+	Key   string      `yaml:"key"`
+	Value interface{} `yaml:"value"`
+}
+
+// LoggingEntry is an entry of the logging section, each entry usually sets
+// one of its fields
+type LoggingEntry struct {
+	Level  string `yaml:"level,omitempty"`
+	Output string `yaml:"output,omitempty"`
+}
+
+// EnvEntry is an entry of the env section
+type EnvEntry struct {
+	Key   string `yaml:"key"`
+	Value string `yaml:"value"`
+}
+
+// Block is a command block of the cmd, on_failure or finally section
+type Block struct {
+	Type             string     `yaml:"type"`
+	Name             string     `yaml:"name,omitempty"`
+	Desc             string     `yaml:"desc,omitempty"`
+	ExpandEnv        bool       `yaml:"expandenv,omitempty"`
+	Values           StringList `yaml:"values,omitempty"`
+	Argv             Argv       `yaml:"argv,omitempty"`
+	Split            string     `yaml:"split,omitempty"`
+	Script           StringList `yaml:"script,omitempty"`
+	Shell            string     `yaml:"shell,omitempty"`
+	PreserveNewlines bool       `yaml:"preserve_newlines,omitempty"`
+	Strict           *bool      `yaml:"strict,omitempty"`
+	Workdir          string     `yaml:"workdir,omitempty"`
+	Env              BlockEnv   `yaml:"env,omitempty"`
+	Needs            StringList `yaml:"needs,omitempty"`
+	Tags             StringList `yaml:"tags,omitempty"`
+	When             string     `yaml:"when,omitempty"`
+	Register         string     `yaml:"register,omitempty"`
+	Timeout          Duration   `yaml:"timeout,omitempty"`
+	Retry            *RetrySpec `yaml:"retry,omitempty"`
+	ContinueOnError  bool       `yaml:"continue_on_error,omitempty"`
+	Creates          string     `yaml:"creates,omitempty"`
+	Unless           string     `yaml:"unless,omitempty"`
+	OnlyIf           string     `yaml:"onlyif,omitempty"`
+	// Foreach lists the items of a loop, or a variable holding them
+	Foreach StringList `yaml:"foreach,omitempty"`
+	// Matrix maps the variables of a loop to their items
+	Matrix map[string]StringList `yaml:"matrix,omitempty"`
+
+	// Options holds the keys specific to the type of the block, as decoded.
+	// Its runner lists them in its schema and decodes them itself.
+	Options map[string]interface{} `yaml:",inline"`
+}
+
+// RetrySpec is the retry setting of a block
+type RetrySpec struct {
+	// Attempts is the number of runs including the first one, 1 when nil
+	Attempts    *int       `yaml:"attempts,omitempty"`
+	Delay       Duration   `yaml:"delay,omitempty"`
+	Backoff     string     `yaml:"backoff,omitempty"`
+	OnExitCodes StringList `yaml:"on_exit_codes,omitempty"`
+}
+
+// Duration is a duration like 90s or 1m30s, or a number of seconds
+type Duration string
+
+// UnmarshalYAML implements yaml.Unmarshaler, numbers are kept as seconds
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	switch value.(type) {
+	case nil:
+		*d = ""
+	case []interface{}, map[interface{}]interface{}:
+		return fmt.Errorf("expected a duration")
+	default:
+		*d = Duration(fmt.Sprint(value))
+	}
+	return nil
+}
+
+// Argv holds the commands of a block as lists of arguments. In YAML it is a
+// list of arguments for a single command or a list of commands.
+type Argv [][]string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (a *Argv) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	commands, err := parseArgv(value)
+	if err != nil {
+		return err
+	}
+	*a = commands
+	return nil
+}
+
+// BlockEnv holds the variables of a block. In YAML it is a mapping or a list
+// of key and value entries like the env section.
+type BlockEnv map[string]string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (e *BlockEnv) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	vars, err := parseBlockEnv(value)
+	if err != nil {
+		return err
+	}
+	*e = vars
+	return nil
+}
+
+// StringList is a list of strings that can also be written as a single
+// scalar in YAML. Other scalars in the list are converted to strings.
+type StringList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (l *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	if s, ok := value.(string); ok && s == "" {
+		*l = nil
+		return nil
+	}
+	if _, ok := value.(map[interface{}]interface{}); ok {
+		return fmt.Errorf("expected a string or a list of strings")
+	}
+	*l = stringList(value)
+	return nil
+}
@@ -0,0 +1,298 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Block types without a registered runner are implemented by plugins: an
+// executable named runfromyaml-<type> in one of the plugin directories or on
+// PATH. A plugin is started once per run of a block, in the working directory
+// and with the environment of the block. It reads a pluginRequest as JSON from
+// its standard input and may write pluginEvents to its standard output, one
+// JSON object per line. Other lines and its standard error are the output of
+// the block.
+
+// pluginPrefix starts the names of the executables implementing plugins
+const pluginPrefix = "runfromyaml-"
+
+// pluginProtocolVersion is sent to plugins so that they can reject requests
+// they don't understand
+const pluginProtocolVersion = 1
+
+// pluginNamePattern matches the block types that can be implemented by a
+// plugin, keeping the executable within the plugin directories
+var pluginNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// SetPluginDirs sets the directories searched for plugins, in order and
+// before PATH
+func SetPluginDirs(dirs ...string) {
+	runners.mu.Lock()
+	defer runners.mu.Unlock()
+	runners.pluginDirs = append([]string(nil), dirs...)
+}
+
+// findPlugin returns the path of the executable implementing a block type
+func findPlugin(t CommandType, dirs []string) (string, bool) {
+	if !pluginNamePattern.MatchString(string(t)) {
+		return "", false
+	}
+	name := pluginPrefix + string(t)
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if path, err := exec.LookPath(filepath.Join(dir, name)); err == nil {
+			if abs, err := filepath.Abs(path); err == nil {
+				return abs, true
+			}
+			return path, true
+		}
+	}
+	path, err := exec.LookPath(name)
+	return path, err == nil
+}
+
+// pluginRequest is sent to a plugin on its standard input
+type pluginRequest struct {
+	Version int                    `json:"version"`
+	Type    CommandType            `json:"type"`
+	Name    string                 `json:"name,omitempty"`
+	Values  []string               `json:"values"`
+	With    map[string]interface{} `json:"with"`
+	// Env holds the variables seen by the block, the plugin is started with
+	// them as well
+	Env     map[string]string `json:"env"`
+	Workdir string            `json:"workdir,omitempty"`
+}
+
+// Types of the events written by plugins
+const (
+	pluginEventLog    = "log"
+	pluginEventResult = "result"
+)
+
+// pluginEvent is a line of JSON written by a plugin. Log events have a level
+// and a message, the result event ends the block with a status, an optional
+// message and variables to set for the following blocks.
+type pluginEvent struct {
+	Type      string            `json:"type"`
+	Level     string            `json:"level,omitempty"`
+	Message   string            `json:"message,omitempty"`
+	Status    BlockStatus       `json:"status,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+// pluginRunner runs blocks with a plugin executable
+type pluginRunner struct {
+	path string
+}
+
+func (p *pluginRunner) Validate(cmd *Command) error {
+	if err := rejectShellOptions(cmd); err != nil {
+		return err
+	}
+	return rejectArgv(cmd)
+}
+
+func (p *pluginRunner) Schema() map[string]interface{} {
+	return map[string]interface{}{
+		"with": map[string]interface{}{"type": "object"},
+	}
+}
+
+func (p *pluginRunner) Explain() string {
+	return fmt.Sprintf("Run the %s plugin", filepath.Base(p.path))
+}
+
+func (p *pluginRunner) Execute(ctx context.Context, run *BlockRun) error {
+	e, cmd := run.executor, run.Command
+	request, err := json.Marshal(pluginRequest{
+		Version: pluginProtocolVersion,
+		Type:    cmd.Type,
+		Name:    cmd.Name,
+		Values:  append([]string{}, cmd.Values...),
+		With:    jsonObject(expandStrings(cmd.Options["with"], func(s string) string { return e.expandOption(cmd, s) })),
+		Env:     e.templateVariables(cmd),
+		Workdir: e.workdir(cmd),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode the request for %s: %w", p.path, err)
+	}
+
+	events := &pluginEvents{executor: e}
+	err = e.runProcess(ctx, cmd, []string{p.path}, run.out, bytes.NewReader(request), events)
+	result := events.result
+	if err != nil {
+		if result != nil && result.Message != "" {
+			return fmt.Errorf("%s: %w", result.Message, err)
+		}
+		return err
+	}
+	if result == nil || e.config.DryRun {
+		return nil
+	}
+
+	switch result.Status {
+	case "", BlockStatusSuccess:
+	case BlockStatusFailed:
+		message := result.Message
+		if message == "" {
+			message = "the plugin reported a failure"
+		}
+		e.print(color.FgRed, "Error: ", message)
+		return fmt.Errorf("%s", message)
+	default:
+		return fmt.Errorf("plugin %s reported unknown status %q", filepath.Base(p.path), result.Status)
+	}
+
+	for key, value := range result.Variables {
+		if !registerNamePattern.MatchString(key) {
+			return fmt.Errorf("plugin %s set invalid variable name %q", filepath.Base(p.path), key)
+		}
+		if e.config.Env != nil {
+			e.config.Env.Set(key, value)
+		}
+	}
+	if result.Message != "" {
+		e.print(color.FgGreen, result.Message)
+	}
+	return nil
+}
+
+// pluginEvents takes the events out of the standard output of a plugin,
+// other lines are passed on to the output of the block
+type pluginEvents struct {
+	executor *CommandExecutor
+	out      io.Writer
+	line     []byte
+	result   *pluginEvent
+}
+
+func (p *pluginEvents) wrap(w io.Writer) io.Writer {
+	p.out = w
+	return p
+}
+
+// Write implements io.Writer
+func (p *pluginEvents) Write(b []byte) (int, error) {
+	p.line = append(p.line, b...)
+	for {
+		i := bytes.IndexByte(p.line, '\n')
+		if i < 0 {
+			break
+		}
+		p.handle(p.line[:i+1])
+		p.line = p.line[i+1:]
+	}
+	return len(b), nil
+}
+
+func (p *pluginEvents) flush() {
+	if len(p.line) > 0 {
+		p.handle(p.line)
+		p.line = nil
+	}
+}
+
+// handle processes a line of output, the last one may lack its newline
+func (p *pluginEvents) handle(line []byte) {
+	trimmed := bytes.TrimSpace(line)
+	var event pluginEvent
+	if bytes.HasPrefix(trimmed, []byte("{")) && json.Unmarshal(trimmed, &event) == nil {
+		switch event.Type {
+		case pluginEventLog:
+			p.executor.print(pluginLogColor(event.Level), event.Message)
+			return
+		case pluginEventResult:
+			p.result = &event
+			return
+		}
+	}
+	_, _ = p.out.Write(line)
+}
+
+// pluginLogColor returns the color of the messages of a log level
+func pluginLogColor(level string) color.Attribute {
+	switch strings.ToLower(level) {
+	case "error":
+		return color.FgRed
+	case "warn", "warning":
+		return color.FgYellow
+	case "debug":
+		return color.FgHiBlack
+	}
+	return color.FgCyan
+}
+
+// expandStrings passes all strings within a decoded YAML value through expand
+func expandStrings(value interface{}, expand func(string) string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return expand(v)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = expandStrings(item, expand)
+		}
+		return items
+	case map[string]interface{}:
+		entries := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			entries[key] = expandStrings(item, expand)
+		}
+		return entries
+	case map[interface{}]interface{}:
+		entries := make(map[interface{}]interface{}, len(v))
+		for key, item := range v {
+			entries[key] = expandStrings(item, expand)
+		}
+		return entries
+	}
+	return value
+}
+
+// jsonObject converts a decoded YAML mapping into one that can be encoded as
+// JSON, an empty one for nil
+func jsonObject(value interface{}) map[string]interface{} {
+	object, _ := jsonValue(value).(map[string]interface{})
+	if object == nil {
+		object = make(map[string]interface{})
+	}
+	return object
+}
+
+// jsonValue converts the mappings with arbitrary keys decoded from YAML into
+// mappings with string keys
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = jsonValue(item)
+		}
+		return items
+	case map[string]interface{}:
+		entries := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			entries[key] = jsonValue(item)
+		}
+		return entries
+	case map[interface{}]interface{}:
+		entries := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			entries[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return entries
+	}
+	return value
+}
@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writePlugin creates an executable plugin for block type name in a new
+// plugin directory and makes it the only one searched
+func writePlugin(t *testing.T, name, script string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, pluginPrefix+name)
+	if err := os.WriteFile(path, []byte("#!/usr/bin/env bash\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	SetPluginDirs(dir)
+	t.Cleanup(func() { SetPluginDirs() })
+	return dir
+}
+
+func TestPluginRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+	dir := writePlugin(t, "deploy", `
+cat > request.json
+echo '{"type":"log","level":"info","message":"deploying"}'
+echo "plain output"
+echo oops >&2
+echo '{"type":"result","status":"success","message":"deployed","variables":{"DEPLOY_ID":"42"}}'
+`)
+
+	var stdout, stderr, log strings.Builder
+	yamlData := `
+env:
+  - key: TARGET
+    value: prod
+cmd:
+  - type: deploy
+    name: ship
+    workdir: ` + dir + `
+    expandenv: true
+    with:
+      target: $TARGET
+      replicas: 3
+      labels: {tier: web}
+    values:
+      - app
+  - type: shell
+    values:
+      - echo "id=$DEPLOY_ID"
+`
+	report, err := RunfromyamlWithReport(context.Background(), []byte(yamlData), RunOptions{
+		Parallel: 1,
+		Stdout:   &stdout,
+		Stderr:   &stderr,
+		Log:      &log,
+	})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithReport() unexpected error: %v\nlog: %s", err, log.String())
+	}
+
+	if stdout.String() != "plain output\nid=42\n" || stderr.String() != "oops\n" {
+		t.Errorf("stdout = %q, stderr = %q, want the output without events", stdout.String(), stderr.String())
+	}
+	for _, want := range []string{"deploying", "deployed"} {
+		if !strings.Contains(log.String(), want) {
+			t.Errorf("log = %q, want %q", log.String(), want)
+		}
+	}
+	if report.Blocks[0].Stdout != "plain output\n" || report.Blocks[0].Status != BlockStatusSuccess {
+		t.Errorf("block result = %+v, want the plugin output and success", report.Blocks[0])
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "request.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request pluginRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		t.Fatalf("invalid request %s: %v", data, err)
+	}
+	if request.Version != pluginProtocolVersion || request.Type != "deploy" || request.Name != "ship" || request.Workdir != dir {
+		t.Errorf("request = %+v, want the block", request)
+	}
+	if len(request.Values) != 1 || request.Values[0] != "app" || request.Env["TARGET"] != "prod" {
+		t.Errorf("request = %+v, want the values and environment", request)
+	}
+	labels, _ := request.With["labels"].(map[string]interface{})
+	if request.With["target"] != "prod" || request.With["replicas"] != float64(3) || labels["tier"] != "web" {
+		t.Errorf("with = %v, want the expanded settings", request.With)
+	}
+}
+
+func TestPluginFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{
+			name:   "reported failure",
+			script: `echo '{"type":"result","status":"failed","message":"rollout stuck"}'`,
+			want:   "rollout stuck",
+		},
+		{
+			name:   "exit code",
+			script: `echo '{"type":"result","message":"no cluster"}'; exit 3`,
+			want:   "no cluster: exit status 3",
+		},
+		{
+			name:   "unknown status",
+			script: `echo '{"type":"result","status":"maybe"}'`,
+			want:   `unknown status "maybe"`,
+		},
+		{
+			name:   "invalid variable",
+			script: `echo '{"type":"result","variables":{"1X":"y"}}'`,
+			want:   `invalid variable name "1X"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writePlugin(t, "deploy", tt.script)
+			report, err := RunfromyamlWithReport(context.Background(), []byte("cmd:\n  - type: deploy\n"), RunOptions{
+				Parallel: 1,
+				Stdout:   &strings.Builder{},
+				Log:      &strings.Builder{},
+			})
+			if err == nil {
+				t.Fatal("RunfromyamlWithReport() expected error")
+			}
+			if report.Blocks[0].Status != BlockStatusFailed || !strings.Contains(report.Blocks[0].Reason, tt.want) {
+				t.Errorf("block result = %+v, want a failure containing %q", report.Blocks[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestPluginDryRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+	dir := writePlugin(t, "deploy", "touch ran\n")
+
+	var log strings.Builder
+	err := RunfromyamlWithOptions(context.Background(), []byte("cmd:\n  - type: deploy\n    workdir: "+dir+"\n"), RunOptions{
+		Parallel: 1,
+		DryRun:   true,
+		Log:      &log,
+	})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	if !strings.Contains(log.String(), "would run in "+dir) {
+		t.Errorf("log = %q, want the planned plugin", log.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ran")); err == nil {
+		t.Error("dry run started the plugin")
+	}
+}
+
+func TestPluginLookup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+	dir := writePlugin(t, "deploy", "")
+
+	runner, ok := LookupRunner("deploy")
+	if !ok || runner.(*pluginRunner).path != filepath.Join(dir, pluginPrefix+"deploy") {
+		t.Errorf("LookupRunner(deploy) = %v, %v, want the plugin", runner, ok)
+	}
+	for _, name := range []CommandType{"missing", "../deploy", "deploy/x", ""} {
+		if _, ok := LookupRunner(name); ok {
+			t.Errorf("LookupRunner(%q) found a plugin", name)
+		}
+	}
+
+	if _, err := ParseWorkflow([]byte("cmd:\n  - type: deploy\n    with: {target: prod}\n"), ""); err != nil {
+		t.Errorf("ParseWorkflow() unexpected error for a plugin block: %v", err)
+	}
+	for _, data := range []string{
+		"cmd:\n  - type: shell\n    with: {target: prod}\n",
+		"cmd:\n  - type: deploy\n    container: app\n",
+		"cmd:\n  - type: deploy\n    with: prod\n",
+	} {
+		if _, err := ParseWorkflow([]byte(data), ""); err == nil {
+			t.Errorf("ParseWorkflow(%q) expected error", data)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package cli
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on Windows, where cancellation falls back to
+// killing the started process only
+func setProcessGroup(command *exec.Cmd) {}
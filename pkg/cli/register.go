@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Suffixes of the variables set by register in addition to the stdout one
+const (
+	registerStderrSuffix   = "_STDERR"
+	registerExitCodeSuffix = "_EXIT_CODE"
+)
+
+// registerNamePattern matches names that can be used as environment variables
+var registerNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateRegisterName checks that the output of a block can be stored under name
+func validateRegisterName(name string) error {
+	if !registerNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid register name %q: must be a valid environment variable name", name)
+	}
+	return nil
+}
+
+// blockOutput collects the output of all processes started by one run of a
+// command block. A nil blockOutput collects nothing.
+type blockOutput struct {
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+	exitCode int
+	// capture keeps the whole output in stdout and stderr for register
+	capture bool
+	// record receives the commands and output for the run report
+	record *blockRecord
+}
+
+// stdoutWriter returns w extended to also capture the standard output
+func (o *blockOutput) stdoutWriter(w io.Writer) io.Writer {
+	if o == nil {
+		return w
+	}
+	writers := []io.Writer{w}
+	if o.capture {
+		writers = append(writers, &o.stdout)
+	}
+	if o.record != nil {
+		writers = append(writers, &o.record.stdout)
+	}
+	return io.MultiWriter(writers...)
+}
+
+// stderrWriter returns w extended to also capture the standard error
+func (o *blockOutput) stderrWriter(w io.Writer) io.Writer {
+	if o == nil {
+		return w
+	}
+	writers := []io.Writer{w}
+	if o.capture {
+		writers = append(writers, &o.stderr)
+	}
+	if o.record != nil {
+		writers = append(writers, &o.record.stderr)
+	}
+	return io.MultiWriter(writers...)
+}
+
+// start records a command that is about to run
+func (o *blockOutput) start(argv []string) {
+	if o == nil {
+		return
+	}
+	o.record.addArgv(argv)
+}
+
+// setResult records the outcome of a finished process
+func (o *blockOutput) setResult(err error) {
+	if o == nil {
+		return
+	}
+	o.exitCode = 0
+	if err != nil {
+		o.exitCode = exitCode(err)
+	}
+	o.record.setExitCode(o.exitCode)
+}
+
+// setChanged records whether the run changed something
+func (o *blockOutput) setChanged(changed bool) {
+	if o == nil {
+		return
+	}
+	o.record.addChanged(changed)
+}
+
+// register stores the captured output in the environment, making it available
+// to the expansion and templates of later blocks
+func (o *blockOutput) register(env *Environment, name string) {
+	env.Set(name, strings.TrimSpace(o.stdout.String()))
+	env.Set(name+registerStderrSuffix, strings.TrimSpace(o.stderr.String()))
+	env.Set(name+registerExitCodeSuffix, strconv.Itoa(o.exitCode))
+}
+
+// lockedBuffer is a buffer that can be written from several goroutines, used
+// to combine the standard output and error of a process
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write implements io.Writer
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// String returns the collected output
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// registeredVariables returns the variables set by registering output under
+// name, as they are currently defined in env
+func registeredVariables(env *Environment, name string) map[string]string {
+	variables := make(map[string]string)
+	for _, key := range []string{name, name + registerStderrSuffix, name + registerExitCodeSuffix} {
+		if value, ok := env.Lookup(key); ok {
+			variables[key] = value
+		}
+	}
+	return variables
+}
@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExecuteContextRegister(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	tests := []struct {
+		name         string
+		output       OutputType
+		script       string
+		wantErr      bool
+		wantStdout   string
+		wantStderr   string
+		wantExitCode string
+	}{
+		{"file output", OutputTypeFile, "echo ' hello '; echo oops >&2", false, "hello", "oops", "0"},
+		{"stdout output", OutputTypeStdout, "echo hello; echo oops >&2; exit 4", true, "hello", "oops", "4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := NewEnvironment()
+			executor := NewCommandExecutor(CommandConfig{
+				Env:    env,
+				Level:  LogLevelInfo,
+				Output: tt.output,
+			})
+			cmd := &Command{Type: CommandTypeShell, Values: []string{tt.script}, Register: "RESULT"}
+
+			err := executor.ExecuteContext(context.Background(), cmd)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ExecuteContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got := env.Get("RESULT"); got != tt.wantStdout {
+				t.Errorf("RESULT = %q, want %q", got, tt.wantStdout)
+			}
+			if got := env.Get("RESULT_STDERR"); got != tt.wantStderr {
+				t.Errorf("RESULT_STDERR = %q, want %q", got, tt.wantStderr)
+			}
+			if got := env.Get("RESULT_EXIT_CODE"); got != tt.wantExitCode {
+				t.Errorf("RESULT_EXIT_CODE = %q, want %q", got, tt.wantExitCode)
+			}
+		})
+	}
+}
+
+func TestRunfromyamlWithOptionsRegister(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	yamlData := fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: exec
+    name: version
+    register: VERSION
+    values:
+      - echo 1.2.3
+  - type: shell
+    needs: version
+    values:
+      - echo "shell $VERSION" >> %[1]s/out
+  - type: exec
+    name: expanded
+    needs: version
+    register: EXPANDED
+    values:
+      - echo exec $VERSION
+  - type: conf
+    needs: expanded
+    expandenv: true
+    confdest: %[1]s/conf
+    confperm: 0644
+    confdata: |
+      version={{.VERSION}} rc={{.VERSION_EXIT_CODE}} {{.EXPANDED}}
+`, dir)
+
+	if err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 2}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+
+	out, _ := os.ReadFile(filepath.Join(dir, "out"))
+	if string(out) != "shell 1.2.3\n" {
+		t.Errorf("shell block wrote %q, want %q", out, "shell 1.2.3\n")
+	}
+	conf, _ := os.ReadFile(filepath.Join(dir, "conf"))
+	if !strings.Contains(string(conf), "version=1.2.3 rc=0 exec 1.2.3") {
+		t.Errorf("conf block wrote %q, want registered values", conf)
+	}
+}
+
+func TestRunfromyamlWithOptionsRegisterExpandEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	yamlData := `
+cmd:
+  - type: exec
+    name: version
+    register: VERSION
+    values:
+      - echo 1.2.3
+  - type: shell
+    needs: version
+    expandenv: true
+    env:
+      RELEASE: v$VERSION
+    values:
+      - echo 'shell $VERSION' "$RELEASE"
+`
+	var stdout strings.Builder
+	err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, Stdout: &stdout, Log: &strings.Builder{}})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "shell 1.2.3 v1.2.3\n") {
+		t.Errorf("stdout = %q, want the registered value expanded", stdout.String())
+	}
+}
+
+func TestValidateRegisterName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"VERSION", false},
+		{"_private_1", false},
+		{"1ST", true},
+		{"MY-VAR", true},
+		{"with space", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRegisterName(tt.name); (err != nil) != tt.wantErr {
+				t.Errorf("validateRegisterName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// defaultOutputLimit is the number of bytes of the standard output and error
+// of every block kept in the run report
+const defaultOutputLimit = 64 * 1024
+
+// RunReport describes the outcome of a workflow run and of all its blocks
+type RunReport struct {
+	// Status is success when the workflow succeeded and failed otherwise
+	Status   BlockStatus   `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Duration time.Duration `json:"duration"`
+	Blocks   []BlockResult `json:"blocks"`
+}
+
+// Counts returns the number of blocks that passed, failed and were skipped
+func (r *RunReport) Counts() (passed, failed, skipped int) {
+	for _, block := range r.Blocks {
+		switch block.Status {
+		case BlockStatusSuccess:
+			passed++
+		case BlockStatusFailed:
+			failed++
+		case BlockStatusSkipped:
+			skipped++
+		}
+	}
+	return passed, failed, skipped
+}
+
+// blockRecord collects the commands and output of all runs of a block for
+// the run report. A nil blockRecord collects nothing.
+type blockRecord struct {
+	mu       sync.Mutex
+	argv     [][]string
+	exitCode *int
+	changed  *bool
+	stdout   limitedBuffer
+	stderr   limitedBuffer
+}
+
+// newBlockRecord creates a record keeping up to limit bytes of each output
+func newBlockRecord(limit int) *blockRecord {
+	return &blockRecord{stdout: limitedBuffer{limit: limit}, stderr: limitedBuffer{limit: limit}}
+}
+
+// addArgv records a started command
+func (r *blockRecord) addArgv(argv []string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.argv = append(r.argv, append([]string(nil), argv...))
+}
+
+// setExitCode records the exit code of the last finished command
+func (r *blockRecord) setExitCode(code int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.exitCode = &code
+}
+
+// addChanged records whether a run of the block changed something, the
+// block changed if any of its runs did
+func (r *blockRecord) addChanged(changed bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.changed != nil {
+		changed = changed || *r.changed
+	}
+	r.changed = &changed
+}
+
+// fill adds the recorded commands and output to result
+func (r *blockRecord) fill(result *BlockResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result.Argv = r.argv
+	result.ExitCode = r.exitCode
+	result.Changed = r.changed
+	result.Stdout, result.Stderr = r.stdout.String(), r.stderr.String()
+	result.Truncated = r.stdout.isTruncated() || r.stderr.isTruncated()
+}
+
+// limitedBuffer keeps the first limit bytes written to it and drops the rest
+type limitedBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+// Write implements io.Writer, it never fails so the command isn't disturbed
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keep := p
+	if room := b.limit - b.buf.Len(); len(keep) > room {
+		keep = keep[:max(room, 0)]
+		b.truncated = true
+	}
+	b.buf.Write(keep)
+	return len(p), nil
+}
+
+// String returns the kept output
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// isTruncated reports whether output was dropped
+func (b *limitedBuffer) isTruncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.truncated
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLimitedBuffer(t *testing.T) {
+	b := limitedBuffer{limit: 5}
+	for _, chunk := range []string{"abc", "defg", "h"} {
+		if n, err := b.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v, want %d, nil", chunk, n, err, len(chunk))
+		}
+	}
+	if got := b.String(); got != "abcde" {
+		t.Errorf("String() = %q, want %q", got, "abcde")
+	}
+	if !b.isTruncated() {
+		t.Error("isTruncated() = false, want true")
+	}
+}
+
+func TestRunfromyamlWithReport(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	workflow := []byte(`
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: exec
+    name: greet
+    values:
+      - echo hello
+  - type: shell
+    name: noisy
+    values:
+      - printf 'abcdefghij'; echo oops >&2
+  - type: shell
+    name: skipped
+    when: os == "plan9"
+    values:
+      - echo never
+  - type: shell
+    name: broken
+    continue_on_error: true
+    values:
+      - exit 3
+  - type: exec
+    name: loop
+    foreach: [a, b]
+    values:
+      - echo ${item}
+`)
+
+	report, err := RunfromyamlWithReport(context.Background(), workflow, RunOptions{Parallel: 1, OutputLimit: 8})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithReport() unexpected error: %v", err)
+	}
+	if report.Status != BlockStatusSuccess || report.Duration <= 0 {
+		t.Errorf("report status = %s, duration = %s, want success with a duration", report.Status, report.Duration)
+	}
+	if passed, failed, skipped := report.Counts(); passed != 3 || failed != 1 || skipped != 1 {
+		t.Errorf("Counts() = %d, %d, %d, want 3, 1, 1", passed, failed, skipped)
+	}
+
+	blocks := make(map[string]BlockResult)
+	for _, block := range report.Blocks {
+		blocks[block.Name] = block
+	}
+
+	greet := blocks["greet"]
+	if want := [][]string{{"echo", "hello"}}; !reflect.DeepEqual(greet.Argv, want) {
+		t.Errorf("greet argv = %q, want %q", greet.Argv, want)
+	}
+	if greet.Stdout != "hello\n" || greet.ExitCode == nil || *greet.ExitCode != 0 || greet.Duration <= 0 {
+		t.Errorf("greet = %+v, want its output, exit code 0 and a duration", greet)
+	}
+
+	noisy := blocks["noisy"]
+	if noisy.Stdout != "abcdefgh" || noisy.Stderr != "oops\n" || !noisy.Truncated {
+		t.Errorf("noisy stdout = %q, stderr = %q, truncated = %v, want the first 8 bytes", noisy.Stdout, noisy.Stderr, noisy.Truncated)
+	}
+
+	skippedBlock := blocks["skipped"]
+	if skippedBlock.Status != BlockStatusSkipped || !strings.Contains(skippedBlock.Reason, "when condition") || skippedBlock.Argv != nil || skippedBlock.ExitCode != nil {
+		t.Errorf("skipped = %+v, want a skip reason without commands", skippedBlock)
+	}
+
+	broken := blocks["broken"]
+	if broken.Status != BlockStatusFailed || broken.ExitCode == nil || *broken.ExitCode != 3 || broken.Reason == "" {
+		t.Errorf("broken = %+v, want a failure with exit code 3", broken)
+	}
+
+	if loop := blocks["loop"]; len(loop.Argv) != 2 || loop.Stdout != "a\nb\n" {
+		t.Errorf("loop argv = %q, stdout = %q, want both iterations", loop.Argv, loop.Stdout)
+	}
+}
+
+func TestRunfromyamlWithReportErrors(t *testing.T) {
+	report, err := RunfromyamlWithReport(context.Background(), []byte("cmd:\n  - type: shell\n    shell: csh\n"), RunOptions{Parallel: 1})
+	if err == nil || report != nil {
+		t.Errorf("RunfromyamlWithReport() = %v, %v, want no report and an error for an invalid workflow", report, err)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	report, err = RunfromyamlWithReport(context.Background(), []byte("logging:\n  - output: file\ncmd:\n  - type: shell\n    values: [exit 1]\n"), RunOptions{Parallel: 1, OutputLimit: -1})
+	if err == nil || report == nil || report.Status != BlockStatusFailed || report.Error != err.Error() {
+		t.Fatalf("RunfromyamlWithReport() = %+v, %v, want a failed report", report, err)
+	}
+	if block := report.Blocks[0]; block.Argv != nil || block.ExitCode != nil {
+		t.Errorf("block = %+v, want nothing recorded with a negative output limit", block)
+	}
+}
@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Backoff strategies for retry delays
+const (
+	BackoffLinear      = "linear"
+	BackoffExponential = "exponential"
+)
+
+// RetryPolicy describes how a failing command block is retried
+type RetryPolicy struct {
+	// Attempts is the total number of runs, including the first one
+	Attempts int
+	// Delay is the wait before the first retry
+	Delay time.Duration
+	// Backoff increases the delay between retries: "linear" multiplies it
+	// with the retry number, "exponential" doubles it every time. Without a
+	// backoff the delay stays the same.
+	Backoff string
+	// OnExitCodes limits retries to these exit codes, all failures are
+	// retried when it is empty
+	OnExitCodes []int
+}
+
+// maxRetryDelay caps the delay a backoff grows to, so many attempts neither
+// wait forever nor overflow
+const maxRetryDelay = time.Hour
+
+// delayFor returns the wait before the given retry (starting with 1)
+func (p *RetryPolicy) delayFor(retry int) time.Duration {
+	if p.Delay <= 0 || retry <= 1 || p.Backoff == "" || p.Delay >= maxRetryDelay {
+		return p.Delay
+	}
+	var factor time.Duration
+	switch p.Backoff {
+	case BackoffLinear:
+		factor = time.Duration(retry)
+	case BackoffExponential:
+		if retry-1 >= 32 {
+			return maxRetryDelay
+		}
+		factor = time.Duration(1) << uint(retry-1)
+	default:
+		return p.Delay
+	}
+	if factor > maxRetryDelay/p.Delay {
+		return maxRetryDelay
+	}
+	return p.Delay * factor
+}
+
+// shouldRetry reports whether a failure with err may be retried
+func (p *RetryPolicy) shouldRetry(err error) bool {
+	if len(p.OnExitCodes) == 0 {
+		return true
+	}
+	code := exitCode(err)
+	for _, c := range p.OnExitCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// exitCode extracts the exit code of a failed process, or -1 if the error
+// didn't come from a process exiting
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// parseRetryPolicy converts the retry section of a command block
+func parseRetryPolicy(spec *RetrySpec) (*RetryPolicy, error) {
+	if spec == nil {
+		return nil, nil
+	}
+
+	policy := &RetryPolicy{Attempts: 1, Backoff: spec.Backoff}
+	if spec.Attempts != nil {
+		if *spec.Attempts < 1 {
+			return nil, fmt.Errorf("retry attempts must be a number greater than 0, got %d", *spec.Attempts)
+		}
+		policy.Attempts = *spec.Attempts
+	}
+
+	delay, err := parseDuration(spec.Delay)
+	if err != nil {
+		return nil, fmt.Errorf("retry delay: %w", err)
+	}
+	policy.Delay = delay
+
+	if spec.Backoff != "" && spec.Backoff != BackoffLinear && spec.Backoff != BackoffExponential {
+		return nil, fmt.Errorf("retry backoff must be %q or %q, got %q", BackoffLinear, BackoffExponential, spec.Backoff)
+	}
+
+	for _, item := range spec.OnExitCodes {
+		code, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, fmt.Errorf("retry on_exit_codes must contain numbers, got %q", item)
+		}
+		policy.OnExitCodes = append(policy.OnExitCodes, code)
+	}
+
+	return policy, nil
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,201 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    *RetryPolicy
+		wantErr string
+	}{
+		{"not set", `{}`, nil, ""},
+		{
+			"full policy",
+			`{retry: {attempts: 4, delay: 2s, backoff: exponential, on_exit_codes: [1, 255]}}`,
+			&RetryPolicy{Attempts: 4, Delay: 2 * time.Second, Backoff: BackoffExponential, OnExitCodes: []int{1, 255}},
+			"",
+		},
+		{"attempts only", `{retry: {attempts: 2}}`, &RetryPolicy{Attempts: 2}, ""},
+		{"delay only", `{retry: {delay: 5}}`, &RetryPolicy{Attempts: 1, Delay: 5 * time.Second}, ""},
+		{"zero attempts", `{retry: {attempts: 0}}`, nil, "greater than 0"},
+		{"invalid backoff", `{retry: {attempts: 2, backoff: random}}`, nil, "backoff must be"},
+		{"invalid exit code", `{retry: {attempts: 2, on_exit_codes: [abc]}}`, nil, "must contain numbers"},
+		{"invalid delay", `{retry: {attempts: 2, delay: soon}}`, nil, "retry delay"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var block Block
+			if err := yaml.Unmarshal([]byte(tt.yaml), &block); err != nil {
+				t.Fatalf("Failed to parse test YAML: %v", err)
+			}
+
+			got, err := parseRetryPolicy(block.Retry)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseRetryPolicy() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRetryPolicy() unexpected error: %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("parseRetryPolicy() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelayFor(t *testing.T) {
+	tests := []struct {
+		backoff string
+		want    []time.Duration
+	}{
+		{"", []time.Duration{time.Second, time.Second, time.Second}},
+		{BackoffLinear, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}},
+		{BackoffExponential, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.backoff, func(t *testing.T) {
+			policy := &RetryPolicy{Delay: time.Second, Backoff: tt.backoff}
+			for i, want := range tt.want {
+				if got := policy.delayFor(i + 1); got != want {
+					t.Errorf("delayFor(%d) = %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelayForLimit(t *testing.T) {
+	tests := []struct {
+		backoff string
+		retries []int
+	}{
+		{BackoffLinear, []int{3601, 1 << 40, math.MaxInt}},
+		{BackoffExponential, []int{13, 64, 65, 1000, math.MaxInt}},
+	}
+	for _, tt := range tests {
+		policy := &RetryPolicy{Delay: time.Second, Backoff: tt.backoff}
+		for _, retry := range tt.retries {
+			if got := policy.delayFor(retry); got != maxRetryDelay {
+				t.Errorf("%s: delayFor(%d) = %v, want %v", tt.backoff, retry, got, maxRetryDelay)
+			}
+		}
+	}
+
+	policy := &RetryPolicy{Delay: 2 * time.Hour, Backoff: BackoffExponential}
+	if got := policy.delayFor(10); got != 2*time.Hour {
+		t.Errorf("delayFor(10) = %v, want the delay itself when it exceeds the limit", got)
+	}
+}
+
+func TestExecuteContextRetry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	counter := filepath.Join(t.TempDir(), "attempts")
+	// Fails with exit code 3 until the third attempt
+	script := fmt.Sprintf(`echo x >> %[1]s; [ $(wc -l < %[1]s) -ge 3 ] || exit 3`, counter)
+
+	tests := []struct {
+		name         string
+		policy       *RetryPolicy
+		wantErr      bool
+		wantAttempts int
+	}{
+		{"succeeds on third attempt", &RetryPolicy{Attempts: 3, Delay: time.Millisecond}, false, 3},
+		{"gives up after two attempts", &RetryPolicy{Attempts: 2, Delay: time.Millisecond, Backoff: BackoffLinear}, true, 2},
+		{"exit code not retried", &RetryPolicy{Attempts: 3, OnExitCodes: []int{1}}, true, 1},
+		{"exit code retried", &RetryPolicy{Attempts: 3, OnExitCodes: []int{3}}, false, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Remove(counter)
+
+			executor := NewCommandExecutor(CommandConfig{
+				Env:    NewEnvironment(),
+				Level:  LogLevelInfo,
+				Output: OutputTypeFile,
+			})
+			cmd := &Command{Type: CommandTypeShell, Values: []string{script}, Retry: tt.policy}
+
+			err := executor.ExecuteContext(context.Background(), cmd)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ExecuteContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			data, _ := os.ReadFile(counter)
+			if got := strings.Count(string(data), "x"); got != tt.wantAttempts {
+				t.Errorf("command ran %d times, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestExecuteContextRetryLogsAttempts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	var log strings.Builder
+	executor := NewCommandExecutor(CommandConfig{
+		Env:    NewEnvironment(),
+		Level:  LogLevelInfo,
+		Output: OutputTypeFile,
+		Log:    &log,
+	})
+	cmd := &Command{Type: CommandTypeShell, Values: []string{"true"}, Retry: &RetryPolicy{Attempts: 3}}
+
+	if err := executor.ExecuteContext(context.Background(), cmd); err != nil {
+		t.Fatalf("ExecuteContext() unexpected error: %v", err)
+	}
+	if !strings.Contains(log.String(), "# attempt 1/3 succeeded") {
+		t.Errorf("log = %q, want the first attempt to be logged", log.String())
+	}
+}
+
+func TestExecuteContextRetryStopsOnCancel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	executor := NewCommandExecutor(CommandConfig{
+		Env:    NewEnvironment(),
+		Level:  LogLevelInfo,
+		Output: OutputTypeFile,
+	})
+	cmd := &Command{
+		Type:   CommandTypeShell,
+		Values: []string{"exit 1"},
+		Retry:  &RetryPolicy{Attempts: 5, Delay: time.Minute},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := executor.ExecuteContext(ctx, cmd); err == nil {
+		t.Error("ExecuteContext() expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("ExecuteContext() kept waiting for %s after cancellation", elapsed)
+	}
+}
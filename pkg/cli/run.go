@@ -0,0 +1,471 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Section labels used to refer to command blocks in messages
+const (
+	sectionCmd       = "command block"
+	sectionOnFailure = "on_failure block"
+	sectionFinally   = "finally block"
+)
+
+// RunOptions controls how a workflow is executed
+type RunOptions struct {
+	Debug    bool
+	Parallel int
+	// File is the path of the workflow, includes are resolved relative to
+	// it. They are resolved relative to the working directory when empty.
+	File string
+	// StateFile records the outcome of every cmd block, it is removed once
+	// the run succeeded. Nothing is recorded when empty.
+	StateFile string
+	// Resume skips blocks that succeeded with the same content in the run
+	// recorded in StateFile
+	Resume bool
+	// Timeout limits the whole run. It overrides the workflow's own
+	// top-level timeout when set.
+	Timeout time.Duration
+	// DryRun prints the commands and file changes of every block instead
+	// of executing them
+	DryRun bool
+	// Plan receives the report of a dry run instead of the workflow's
+	// logging output
+	Plan io.Writer
+	// Selection limits which cmd blocks run, the others are reported as
+	// skipped. The on_failure and finally blocks are not affected.
+	Selection Selection
+	// OutputLimit is the number of bytes of the standard output and error
+	// of every block kept in the run report, 64 KiB when 0. No output is
+	// kept when it is negative.
+	OutputLimit int
+	// Stdout and Stderr receive the output of started commands, and Log the
+	// messages of the run, in place of the workflow's logging output. They
+	// may be the same writer.
+	Stdout io.Writer
+	Stderr io.Writer
+	Log    io.Writer
+	// Env holds the variables the workflow starts with in place of the
+	// process environment, which it doesn't see at all then
+	Env map[string]string
+	// OnEvent is called for every event of the run, one at a time
+	OnEvent func(Event)
+}
+
+// Runfromyaml processes and executes commands from YAML data
+func Runfromyaml(yamlFile []byte, debug bool) error {
+	return RunfromyamlWithOptions(context.Background(), yamlFile, RunOptions{Debug: debug, Parallel: 1})
+}
+
+// RunfromyamlWithOptions processes and executes commands from YAML data like
+// RunfromyamlWithReport, without returning the report
+func RunfromyamlWithOptions(ctx context.Context, yamlFile []byte, opts RunOptions) error {
+	_, err := RunfromyamlWithReport(ctx, yamlFile, opts)
+	return err
+}
+
+// RunfromyamlWithReport processes and executes commands from YAML data and
+// returns a report on every block. The report is nil when the workflow is
+// rejected before any block runs. Command blocks are scheduled according to
+// their needs, with up to opts.Parallel independent blocks running
+// concurrently. Cancelling ctx kills all running commands and prevents
+// further blocks from starting.
+//
+// After the cmd list the workflow's on_failure blocks run if it failed,
+// followed by its finally blocks in any case. Both are neither affected by
+// the workflow timeout nor by cancelling ctx, so cleanup still happens.
+func RunfromyamlWithReport(ctx context.Context, yamlFile []byte, opts RunOptions) (*RunReport, error) {
+	run, err := prepareRun(yamlFile, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.StateFile != "" {
+		if run.state, err = loadRunState(opts.StateFile, opts.Resume); err != nil {
+			return nil, err
+		}
+		run.state.keys = stateKeys(run.graphs[0].commands)
+	}
+
+	// Cleanup sections must not be stopped by the workflow timeout or by
+	// cancellation of the main run
+	cleanupCtx := context.WithoutCancel(ctx)
+	if run.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, run.timeout)
+		defer cancel()
+	}
+
+	report := &RunReport{Started: time.Now()}
+	mainErr := run.runSection(ctx, sectionCmd, run.graphs[0])
+
+	var onFailureErr error
+	if mainErr != nil {
+		onFailureErr = run.runSection(cleanupCtx, sectionOnFailure, run.graphs[1])
+	}
+	finallyErr := run.runSection(cleanupCtx, sectionFinally, run.graphs[2])
+
+	err = errors.Join(mainErr, onFailureErr, finallyErr)
+	report.Finished = time.Now()
+	report.Duration = report.Finished.Sub(report.Started)
+	report.Blocks = run.summary
+	report.Status = BlockStatusSuccess
+	if err != nil {
+		report.Status = BlockStatusFailed
+		report.Error = err.Error()
+	}
+	run.executor.printSummary(report)
+
+	// A successful run starts from scratch next time
+	if mainErr == nil && run.state != nil && !opts.DryRun {
+		if err := run.state.remove(); err != nil {
+			run.executor.print(color.FgRed, fmt.Sprintf("# failed to remove run state: %v", err))
+		}
+	}
+
+	return report, err
+}
+
+// ValidateWorkflow checks a workflow, with its includes, like
+// RunfromyamlWithReport does before any block runs. Nothing is executed.
+func ValidateWorkflow(yamlFile []byte, opts RunOptions) error {
+	_, err := prepareRun(yamlFile, opts)
+	return err
+}
+
+// prepareRun parses a workflow and checks all of its sections, so that
+// nothing runs unless the whole workflow is valid
+func prepareRun(yamlFile []byte, opts RunOptions) (*workflowRun, error) {
+	workflow, err := ParseWorkflow(yamlFile, opts.File)
+	if err != nil {
+		return nil, err
+	}
+
+	env := NewEnvironment()
+	if opts.Env != nil {
+		env = NewIsolatedEnvironment(opts.Env)
+	}
+
+	documents, err := resolveIncludes(workflow, opts.File, env)
+	if err != nil {
+		return nil, err
+	}
+	workflow = mergeDocuments(documents)
+
+	parseEnvironmentVariables(workflow, env)
+	outputType, outputLevel := parseLoggingSettings(workflow)
+
+	if err := validateShell(workflow.Shell); err != nil {
+		return nil, fmt.Errorf("invalid workflow shell: %w", err)
+	}
+
+	config := CommandConfig{
+		Env:       env,
+		Level:     LogLevel(outputLevel),
+		Output:    OutputType(outputType),
+		WaitGroup: &sync.WaitGroup{},
+		DryRun:    opts.DryRun,
+		Shell:     workflow.Shell,
+	}
+	if workflow.Strict != nil {
+		config.Strict = *workflow.Strict
+	}
+	// Writers of the caller may be the same, so all of them share one lock
+	var writeMu sync.Mutex
+	config.Plan = lockWriter(opts.Plan, &writeMu)
+	config.Stdout = lockWriter(opts.Stdout, &writeMu)
+	config.Stderr = lockWriter(opts.Stderr, &writeMu)
+	config.Log = lockWriter(opts.Log, &writeMu)
+
+	results := newBlockResults()
+	run := &workflowRun{
+		executor:    NewCommandExecutor(config),
+		results:     results,
+		whenCtx:     newWhenContext(env, results),
+		parallel:    opts.Parallel,
+		outputLimit: opts.OutputLimit,
+		events:      newEventSink(opts.OnEvent),
+	}
+	if run.outputLimit == 0 {
+		run.outputLimit = defaultOutputLimit
+	}
+
+	// Parse and check every section before anything runs
+	sections := []struct{ key, label string }{
+		{key: "cmd", label: sectionCmd},
+		{key: "on_failure", label: sectionOnFailure},
+		{key: "finally", label: sectionFinally},
+	}
+	for _, section := range sections {
+		var commands []*Command
+		for _, d := range documents {
+			parsed, err := parseCommands(d.workflow.section(section.key), section.label, env)
+			if err != nil {
+				if d.name != "" {
+					return nil, fmt.Errorf("%s: %w", d.name, err)
+				}
+				return nil, err
+			}
+			dir := ""
+			switch {
+			case d.name != "":
+				dir = filepath.Dir(d.name)
+			case opts.File != "":
+				dir = filepath.Dir(opts.File)
+			}
+			for _, cmd := range parsed {
+				cmd.Source, cmd.Dir = d.name, dir
+			}
+			commands = append(commands, parsed...)
+		}
+		graph, err := buildCommandGraph(commands)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", section.key, err)
+		}
+		run.graphs = append(run.graphs, graph)
+	}
+
+	if !opts.Selection.IsEmpty() {
+		if run.selected, err = opts.Selection.selectBlocks(run.graphs[0].commands); err != nil {
+			return nil, err
+		}
+	}
+
+	run.timeout = opts.Timeout
+	if run.timeout == 0 {
+		if run.timeout, err = parseDuration(workflow.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid workflow timeout: %w", err)
+		}
+	}
+
+	return run, nil
+}
+
+// workflowRun holds the state shared by all sections of a single run
+type workflowRun struct {
+	executor *CommandExecutor
+	results  *blockResults
+	whenCtx  *whenContext
+	parallel int
+	timeout  time.Duration
+	state    *runState
+	selected []bool
+	// graphs holds the blocks of the cmd, on_failure and finally sections
+	graphs []*commandGraph
+	// outputLimit is the number of bytes of output kept for every block
+	outputLimit int
+	summary     []BlockResult
+	events      *eventSink
+}
+
+// runSection executes the blocks of a section and adds their outcome to the
+// summary. Blocks that never started are reported as skipped.
+func (r *workflowRun) runSection(ctx context.Context, section string, graph *commandGraph) error {
+	outcomes := make([]*BlockResult, len(graph.commands))
+
+	err := graph.run(r.parallel, func(i int) error {
+		result, err := r.reportBlock(ctx, section, i, graph.commands[i])
+		outcomes[i] = result
+		r.events.send(EventBlockFinished, *result)
+		return err
+	})
+
+	for i, cmd := range graph.commands {
+		if outcomes[i] == nil {
+			outcomes[i] = r.newResult(section, i, cmd, BlockStatusSkipped, "not run because of an earlier failure")
+			r.events.send(EventBlockFinished, *outcomes[i])
+		}
+		r.summary = append(r.summary, *outcomes[i])
+	}
+
+	return err
+}
+
+// reportBlock runs a block and adds its timing, commands and output to the
+// result
+func (r *workflowRun) reportBlock(ctx context.Context, section string, i int, cmd *Command) (*BlockResult, error) {
+	var record *blockRecord
+	if r.outputLimit >= 0 {
+		record = newBlockRecord(r.outputLimit)
+	}
+
+	started := time.Now()
+	result, err := r.runBlock(ctx, section, i, cmd, record)
+	result.Started, result.Finished = started, time.Now()
+	result.Duration = result.Finished.Sub(started)
+	if record != nil {
+		record.fill(result)
+	}
+	return result, err
+}
+
+// runBlock evaluates the conditions of a block and executes it. The returned
+// error stops the section, failures of blocks with continue_on_error are only
+// recorded.
+func (r *workflowRun) runBlock(ctx context.Context, section string, i int, cmd *Command, record *blockRecord) (*BlockResult, error) {
+	if err := ctx.Err(); err != nil {
+		err = r.interruptedError(section, i, cmd, err)
+		return r.newResult(section, i, cmd, BlockStatusFailed, err.Error()), err
+	}
+
+	if r.selected != nil && section == sectionCmd && !r.selected[i] {
+		return r.newResult(section, i, cmd, BlockStatusSkipped, "not selected"), nil
+	}
+
+	if r.state != nil && section == sectionCmd {
+		if block, ok := r.state.succeeded(r.state.keys[i], cmd.Hash); ok {
+			for name, value := range block.Registered {
+				r.executor.config.Env.Set(name, value)
+			}
+			reason := fmt.Sprintf("succeeded in an earlier run at %s", block.Finished.Format(time.RFC3339))
+			r.executor.print(color.FgYellow, fmt.Sprintf("# skipping %s %d (%s)%s: %s", section, i+1, cmd.label(i), cmd.origin(), reason))
+			return r.newResult(section, i, cmd, BlockStatusSuccess, reason), nil
+		}
+	}
+
+	if cmd.When != "" {
+		run, err := evaluateWhen(cmd.When, r.whenCtx)
+		if err != nil {
+			err = fmt.Errorf("%s %d (%s)%s: %w", section, i+1, cmd.label(i), cmd.origin(), err)
+			return r.newResult(section, i, cmd, BlockStatusFailed, err.Error()), err
+		}
+		if !run {
+			reason := fmt.Sprintf("when condition %q is false", cmd.When)
+			r.executor.print(color.FgYellow, fmt.Sprintf("# skipping %s %d (%s)%s: %s", section, i+1, cmd.label(i), cmd.origin(), reason))
+			return r.newResult(section, i, cmd, BlockStatusSkipped, reason), nil
+		}
+	}
+
+	started := blockInfo(section, i, cmd)
+	started.Started = time.Now()
+	r.events.send(EventBlockStarted, started)
+
+	reason, err := r.executor.executeBlock(ctx, cmd, record)
+	if err == nil && reason != "" {
+		r.executor.print(color.FgYellow, fmt.Sprintf("# skipping %s %d (%s)%s: %s", section, i+1, cmd.label(i), cmd.origin(), reason))
+		return r.newResult(section, i, cmd, BlockStatusSkipped, reason), nil
+	}
+	if err == nil {
+		r.recordState(section, i, cmd, BlockStatusSuccess)
+		return r.newResult(section, i, cmd, BlockStatusSuccess, ""), nil
+	}
+	r.recordState(section, i, cmd, BlockStatusFailed)
+
+	var timeoutErr *TimeoutError
+	switch {
+	case errors.As(err, &timeoutErr):
+		err = fmt.Errorf("%s %d (%s)%s %w", section, i+1, cmd.label(i), cmd.origin(), err)
+	case ctx.Err() != nil:
+		err = r.interruptedError(section, i, cmd, ctx.Err())
+	default:
+		err = fmt.Errorf("failed to execute %s %d (%s)%s: %w", section, i+1, cmd.Type, cmd.origin(), err)
+	}
+
+	result := r.newResult(section, i, cmd, BlockStatusFailed, err.Error())
+	if cmd.ContinueOnError {
+		r.executor.print(color.FgRed, fmt.Sprintf("# %v - continuing (continue_on_error)", err))
+		return result, nil
+	}
+	return result, err
+}
+
+// recordState stores the outcome of an executed cmd block in the run state
+func (r *workflowRun) recordState(section string, i int, cmd *Command, status BlockStatus) {
+	if r.state == nil || section != sectionCmd || r.executor.config.DryRun {
+		return
+	}
+
+	block := blockState{Hash: cmd.Hash, Status: status, Finished: time.Now()}
+	if cmd.Register != "" {
+		block.Registered = registeredVariables(r.executor.config.Env, cmd.Register)
+	}
+	if runner, ok := LookupRunner(cmd.Type); ok {
+		if setter, ok := runner.(variableSetter); ok {
+			for _, name := range setter.variables(cmd) {
+				if value, ok := r.executor.config.Env.Lookup(name); ok {
+					if block.Registered == nil {
+						block.Registered = make(map[string]string)
+					}
+					block.Registered[name] = value
+				}
+			}
+		}
+	}
+	if err := r.state.record(r.state.keys[i], block); err != nil {
+		r.executor.print(color.FgRed, fmt.Sprintf("# failed to record run state: %v", err))
+	}
+}
+
+// newResult records the status of a block and returns its summary entry
+func (r *workflowRun) newResult(section string, i int, cmd *Command, status BlockStatus, reason string) *BlockResult {
+	r.results.set(cmd.Name, status)
+	result := blockInfo(section, i, cmd)
+	result.Status, result.Reason = status, reason
+	return &result
+}
+
+// blockInfo returns a result identifying a block, without its outcome
+func blockInfo(section string, i int, cmd *Command) BlockResult {
+	return BlockResult{
+		Section: section,
+		Index:   i,
+		Name:    cmd.label(i),
+		Type:    cmd.Type,
+		Source:  cmd.Source,
+	}
+}
+
+// interruptedError describes a block that was stopped because the whole run
+// timed out or was cancelled
+func (r *workflowRun) interruptedError(section string, i int, cmd *Command, err error) error {
+	if err == context.DeadlineExceeded {
+		return fmt.Errorf("%s %d (%s)%s aborted: workflow timed out after %s", section, i+1, cmd.label(i), cmd.origin(), r.timeout)
+	}
+	return fmt.Errorf("%s %d (%s)%s cancelled: %w", section, i+1, cmd.label(i), cmd.origin(), err)
+}
+
+// printSummary lists which blocks of a run passed, failed or were skipped
+func (e *CommandExecutor) printSummary(report *RunReport) {
+	if len(report.Blocks) == 0 {
+		return
+	}
+
+	passed, failed, skipped := report.Counts()
+	e.print(color.FgCyan, fmt.Sprintf("# summary: %d passed, %d failed, %d skipped in %s",
+		passed, failed, skipped, report.Duration.Round(time.Millisecond)))
+
+	for _, result := range report.Blocks {
+		line := fmt.Sprintf("#   %s %d (%s): %s", result.Section, result.Index+1, result.Name, result.Status)
+		if result.Source != "" {
+			line = fmt.Sprintf("#   %s %d (%s) in %s: %s", result.Section, result.Index+1, result.Name, result.Source, result.Status)
+		}
+		if result.Changed != nil && *result.Changed {
+			line += ", changed"
+		} else if result.Changed != nil {
+			line += ", unchanged"
+		}
+		if result.Argv != nil {
+			line += fmt.Sprintf(" (%s)", result.Duration.Round(time.Millisecond))
+		}
+		if result.Reason != "" {
+			line += " - " + result.Reason
+		}
+
+		ctype := color.FgGreen
+		switch result.Status {
+		case BlockStatusFailed:
+			ctype = color.FgRed
+		case BlockStatusSkipped:
+			ctype = color.FgYellow
+		}
+		e.print(ctype, line)
+	}
+}
@@ -0,0 +1,388 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRunfromyamlWithOptionsCleanupSections(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	tests := []struct {
+		name      string
+		cmd       string
+		wantErr   bool
+		wantOrder string
+	}{
+		{
+			name:      "main succeeds",
+			cmd:       "echo main >> %[1]s/order",
+			wantOrder: "main\nfinally\n",
+		},
+		{
+			name:      "main fails",
+			cmd:       "echo main >> %[1]s/order; exit 1",
+			wantErr:   true,
+			wantOrder: "main\non_failure\nfinally\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			yamlData := fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: shell
+    name: main
+    values:
+      - `+tt.cmd+`
+  - type: shell
+    name: after
+    needs: main
+    values:
+      - echo after >> %[1]s/unexpected
+on_failure:
+  - type: shell
+    values:
+      - echo on_failure >> %[1]s/order
+finally:
+  - type: shell
+    values:
+      - echo finally >> %[1]s/order
+`, dir)
+
+			err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RunfromyamlWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			order, _ := os.ReadFile(filepath.Join(dir, "order"))
+			if string(order) != tt.wantOrder {
+				t.Errorf("blocks ran in order %q, want %q", order, tt.wantOrder)
+			}
+			if tt.wantErr {
+				if _, err := os.Stat(filepath.Join(dir, "unexpected")); err == nil {
+					t.Error("block depending on the failed block was executed")
+				}
+			}
+		})
+	}
+}
+
+func TestRunfromyamlWithOptionsContinueOnError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	yamlData := fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: shell
+    name: flaky
+    continue_on_error: true
+    values:
+      - exit 1
+  - type: shell
+    name: next
+    values:
+      - echo next >> %[1]s/order
+finally:
+  - type: shell
+    when: failed("flaky") && succeeded("next")
+    values:
+      - echo finally >> %[1]s/order
+on_failure:
+  - type: shell
+    values:
+      - echo on_failure >> %[1]s/order
+`, dir)
+
+	if err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+
+	order, _ := os.ReadFile(filepath.Join(dir, "order"))
+	if string(order) != "next\nfinally\n" {
+		t.Errorf("blocks ran in order %q, want next then finally", order)
+	}
+}
+
+func TestRunfromyamlWithOptionsFinallyError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	yamlData := `
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: shell
+    values:
+      - exit 1
+finally:
+  - type: shell
+    name: cleanup
+    values:
+      - exit 2
+`
+
+	err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1})
+	if err == nil {
+		t.Fatal("RunfromyamlWithOptions() expected error")
+	}
+	for _, want := range []string{"failed to execute command block 1", "failed to execute finally block 1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("RunfromyamlWithOptions() error = %v, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestWorkflowRunSummary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	commands := []*Command{
+		{Type: CommandTypeShell, Name: "ok", Values: []string{"true"}},
+		{Type: CommandTypeShell, Name: "skip", When: "false", Values: []string{"true"}},
+		{Type: CommandTypeShell, Name: "fail", Values: []string{"exit 1"}},
+		{Type: CommandTypeShell, Name: "never", Needs: []string{"fail"}, Values: []string{"true"}},
+	}
+	graph, err := buildCommandGraph(commands)
+	if err != nil {
+		t.Fatalf("buildCommandGraph() unexpected error: %v", err)
+	}
+
+	env := NewEnvironment()
+	results := newBlockResults()
+	run := &workflowRun{
+		executor: NewCommandExecutor(CommandConfig{Env: env, Level: LogLevelInfo, Output: OutputTypeFile}),
+		results:  results,
+		whenCtx:  newWhenContext(env, results),
+		parallel: 1,
+	}
+
+	if err := run.runSection(context.Background(), sectionCmd, graph); err == nil {
+		t.Error("runSection() expected error")
+	}
+
+	want := []BlockStatus{BlockStatusSuccess, BlockStatusSkipped, BlockStatusFailed, BlockStatusSkipped}
+	if len(run.summary) != len(want) {
+		t.Fatalf("summary has %d entries, want %d", len(run.summary), len(want))
+	}
+	for i, status := range want {
+		if run.summary[i].Status != status {
+			t.Errorf("summary[%d] (%s) = %s, want %s", i, run.summary[i].Name, run.summary[i].Status, status)
+		}
+	}
+}
+
+func TestRunfromyamlWithOptionsIsolatedEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	workflow := func(value string) []byte {
+		return []byte(fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+env:
+  - key: RFY_ISOLATED
+    value: %[2]s
+cmd:
+  - type: shell
+    register: RFY_REGISTERED
+    values:
+      - sleep 0.2; echo %[2]s
+  - type: shell
+    values:
+      - echo "$RFY_ISOLATED $RFY_REGISTERED" > %[1]s/%[2]s
+`, dir, value))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, value := range []string{"first", "second"} {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+			errs <- RunfromyamlWithOptions(context.Background(), workflow(value), RunOptions{Parallel: 1})
+		}(value)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+		}
+	}
+
+	for _, value := range []string{"first", "second"} {
+		got, _ := os.ReadFile(filepath.Join(dir, value))
+		if want := value + " " + value + "\n"; string(got) != want {
+			t.Errorf("workflow %s saw %q, want %q", value, got, want)
+		}
+	}
+	if _, ok := os.LookupEnv("RFY_ISOLATED"); ok {
+		t.Error("workflow env leaked into the process environment")
+	}
+}
+
+func TestRunfromyamlWithOptionsWriters(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	var stdout, stderr, log strings.Builder
+	yamlData := `
+cmd:
+  - type: shell
+    name: greet
+    values:
+      - echo out; echo err >&2
+`
+	err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{
+		Parallel: 1,
+		Stdout:   &stdout,
+		Stderr:   &stderr,
+		Log:      &log,
+	})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("stdout = %q, stderr = %q, want the output of the block", stdout.String(), stderr.String())
+	}
+	if !strings.Contains(log.String(), "# summary: 1 passed, 0 failed, 0 skipped") {
+		t.Errorf("log = %q, want the summary of the run", log.String())
+	}
+	if strings.Contains(log.String(), "\x1b[") {
+		t.Errorf("log = %q, want no color codes", log.String())
+	}
+}
+
+func TestRunfromyamlWithOptionsEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+	t.Setenv("RFY_PROCESS", "leaked")
+
+	var stdout strings.Builder
+	yamlData := `
+env:
+  - key: RFY_WORKFLOW
+    value: workflow
+cmd:
+  - type: shell
+    values:
+      - echo "$RFY_GIVEN $RFY_WORKFLOW ${RFY_PROCESS:-unset}"
+  - type: shell
+    expandenv: true
+    values:
+      - echo "expanded [$RFY_PROCESS]"
+`
+	err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{
+		Parallel: 1,
+		Stdout:   &stdout,
+		Log:      io.Discard,
+		Env:      map[string]string{"RFY_GIVEN": "given"},
+	})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	if want := "given workflow unset\nexpanded []\n"; stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+}
+
+func TestRunfromyamlWithOptionsEvents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	yamlData := `
+cmd:
+  - type: shell
+    name: skipped
+    when: "false"
+    values: "true"
+  - type: shell
+    name: fails
+    values: exit 3
+  - type: shell
+    name: never
+    needs: fails
+    values: "true"
+finally:
+  - type: shell
+    name: cleanup
+    values: "true"
+`
+	var events []string
+	_, err := RunfromyamlWithReport(context.Background(), []byte(yamlData), RunOptions{
+		Parallel: 1,
+		Stdout:   io.Discard,
+		Log:      io.Discard,
+		OnEvent: func(event Event) {
+			line := fmt.Sprintf("%s %s", event.Type, event.Block.Name)
+			if event.Type == EventBlockFinished {
+				line += " " + string(event.Block.Status)
+			}
+			events = append(events, line)
+		},
+	})
+	if err == nil {
+		t.Fatal("RunfromyamlWithReport() expected error")
+	}
+
+	want := []string{
+		"block_finished skipped skipped",
+		"block_started fails",
+		"block_finished fails failed",
+		"block_finished never skipped",
+		"block_started cleanup",
+		"block_finished cleanup success",
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+}
+
+func TestValidateWorkflow(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+
+	valid := fmt.Sprintf("cmd:\n  - type: conf\n    confdest: %q\n    confdata: x\n", marker)
+	if err := ValidateWorkflow([]byte(valid), RunOptions{}); err != nil {
+		t.Errorf("ValidateWorkflow() unexpected error: %v", err)
+	}
+
+	invalid := valid + "  - type: shell\n    needs: missing\n    values: \"true\"\n"
+	err := ValidateWorkflow([]byte(invalid), RunOptions{})
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("ValidateWorkflow() error = %v, want the unknown need", err)
+	}
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("ValidateWorkflow() executed a block")
+	}
+}
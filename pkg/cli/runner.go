@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/fatih/color"
+	rfyerrors "github.com/lanixx/runfromyaml/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Runner implements a block type. Everything specific to the type is
+// provided by its runner, so a type registered with RegisterRunner is
+// decoded, validated, described and executed like the built-in ones.
+type Runner interface {
+	// Validate checks the settings of a block before anything runs
+	Validate(cmd *Command) error
+	// Schema returns the JSON schema of the keys specific to the type by
+	// key. Keys that aren't common to all blocks are only accepted for the
+	// type when they are listed here, and their values are checked against
+	// the schema before anything runs. Runners read them with
+	// Command.DecodeOptions.
+	Schema() map[string]interface{}
+	// Explain describes in a few words what blocks of the type do
+	Explain() string
+	// Execute runs a block once, retries and loops are handled by the caller
+	Execute(ctx context.Context, run *BlockRun) error
+}
+
+// variableSetter is implemented by the runners whose blocks set variables
+// for later blocks, besides register. The variables are kept in the run state
+// so resumed runs still see them.
+type variableSetter interface {
+	// variables returns the names of the variables a block sets
+	variables(cmd *Command) []string
+}
+
+// BlockRun is a single execution of a block, it gives runners access to
+// the environment and output of the workflow
+type BlockRun struct {
+	Command  *Command
+	executor *CommandExecutor
+	out      *blockOutput
+}
+
+// Run starts a command in the working directory and with the environment of
+// the block and waits for it. Its output goes wherever the output of the
+// workflow goes and is recorded for register and the run report. A dry run
+// only reports the command.
+func (r *BlockRun) Run(ctx context.Context, argv []string) error {
+	return r.executor.runCommand(ctx, r.Command, argv, r.out)
+}
+
+// Expand replaces $var and ${var} with the variables seen by the block
+func (r *BlockRun) Expand(s string) string {
+	return r.executor.expandEnv(r.Command, s)
+}
+
+// ResolvePath interprets a relative path within the working directory of
+// the block
+func (r *BlockRun) ResolvePath(path string) string {
+	return r.executor.resolvePath(r.Command, path)
+}
+
+// SetChanged reports whether the block changed something, for types that can
+// tell. It is shown in the summary and the run report.
+func (r *BlockRun) SetChanged(changed bool) {
+	r.out.setChanged(changed)
+}
+
+// Print writes a message to the log of the workflow
+func (r *BlockRun) Print(ctype color.Attribute, cstring ...interface{}) {
+	r.executor.print(ctype, cstring...)
+}
+
+// DryRun reports whether the block should only report what it would do
+func (r *BlockRun) DryRun() bool {
+	return r.executor.config.DryRun
+}
+
+// Plan reports an action of a dry run
+func (r *BlockRun) Plan(format string, args ...interface{}) {
+	r.executor.plan(format, args...)
+}
+
+// DecodeOptions decodes the keys specific to the type of the block into out,
+// a pointer to a struct with yaml tags. Loop variables are already replaced,
+// other variables are left for the runner to expand.
+func (c *Command) DecodeOptions(out interface{}) error {
+	data, err := yaml.Marshal(c.Options)
+	if err != nil {
+		return fmt.Errorf("invalid options of %s block: %w", c.Type, err)
+	}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid options of %s block: %w", c.Type, err)
+	}
+	return nil
+}
+
+func init() {
+	rfyerrors.CommandTypes = func(cmdType string) (bool, []string) {
+		_, ok := LookupRunner(CommandType(cmdType))
+		var names []string
+		for _, t := range RunnerTypes() {
+			names = append(names, string(t))
+		}
+		return ok, names
+	}
+}
+
+// runnerRegistry holds the runners of all block types
+type runnerRegistry struct {
+	mu     sync.RWMutex
+	byType map[CommandType]Runner
+	// types lists the block types in the order they were registered
+	types []CommandType
+	// pluginDirs are searched for plugins before PATH
+	pluginDirs []string
+}
+
+var runners = &runnerRegistry{byType: make(map[CommandType]Runner)}
+
+// RegisterRunner makes a block type available to workflows. It panics if
+// the type is already registered.
+func RegisterRunner(t CommandType, runner Runner) {
+	runners.mu.Lock()
+	defer runners.mu.Unlock()
+	if runner == nil {
+		panic(fmt.Sprintf("cli: runner for %s blocks is nil", t))
+	}
+	if _, ok := runners.byType[t]; ok {
+		panic(fmt.Sprintf("cli: runner for %s blocks registered twice", t))
+	}
+	runners.byType[t] = runner
+	runners.types = append(runners.types, t)
+}
+
+// LookupRunner returns the runner of a block type. Types that aren't
+// registered are looked up as plugins.
+func LookupRunner(t CommandType) (Runner, bool) {
+	runners.mu.RLock()
+	runner, ok := runners.byType[t]
+	dirs := runners.pluginDirs
+	runners.mu.RUnlock()
+	if ok {
+		return runner, true
+	}
+	if path, ok := findPlugin(t, dirs); ok {
+		return &pluginRunner{path: path}, true
+	}
+	return nil, false
+}
+
+// RunnerTypes returns the registered block types in the order they were
+// registered, plugins aren't included
+func RunnerTypes() []CommandType {
+	runners.mu.RLock()
+	defer runners.mu.RUnlock()
+	return append([]CommandType(nil), runners.types...)
+}
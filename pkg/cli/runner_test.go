@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	rfyerrors "github.com/lanixx/runfromyaml/pkg/errors"
+)
+
+// echoRunner is a block type of the tests that echoes its values
+type echoRunner struct{}
+
+func (echoRunner) Validate(cmd *Command) error {
+	if len(cmd.Values) == 0 {
+		return fmt.Errorf("echo blocks need values")
+	}
+	return nil
+}
+
+// echoOptions are the keys of echo blocks
+type echoOptions struct {
+	Greeting string `yaml:"greeting"`
+}
+
+func (echoRunner) Schema() map[string]interface{} {
+	return map[string]interface{}{"greeting": map[string]interface{}{"type": "string"}}
+}
+
+func (echoRunner) Explain() string {
+	return "Echo values"
+}
+
+func (echoRunner) Execute(ctx context.Context, run *BlockRun) error {
+	var o echoOptions
+	if err := run.Command.DecodeOptions(&o); err != nil {
+		return err
+	}
+	return run.Run(ctx, append([]string{"echo", run.Expand("$RFY_ECHO"), o.Greeting}, run.Command.Values...))
+}
+
+// registerTestRunner registers a runner for the duration of a test
+func registerTestRunner(t *testing.T, name CommandType, runner Runner) {
+	t.Helper()
+	RegisterRunner(name, runner)
+	t.Cleanup(func() {
+		runners.mu.Lock()
+		defer runners.mu.Unlock()
+		delete(runners.byType, name)
+		runners.types = runners.types[:len(runners.types)-1]
+	})
+}
+
+func TestRunnerSchemas(t *testing.T) {
+	fields := yamlFields(blockType)
+	described := make(map[string]interface{})
+	all := map[CommandType]Runner{"plugin": &pluginRunner{path: "runfromyaml-plugin"}}
+	for _, name := range RunnerTypes() {
+		all[name], _ = LookupRunner(name)
+	}
+	for name, runner := range all {
+		if runner.Explain() == "" {
+			t.Errorf("%s runner has no explanation", name)
+		}
+		for key, property := range runner.Schema() {
+			if _, common := fields[key]; common {
+				continue
+			}
+			// Keys of several types have to be described alike, the
+			// decoder tells which type supports a key by its name
+			if other, ok := described[key]; ok && !reflect.DeepEqual(other, property) {
+				t.Errorf("%s runner describes %q as %v, another runner as %v", name, key, property, other)
+			}
+			described[key] = property
+		}
+	}
+}
+
+func TestRegisterRunner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping echo based test on Windows")
+	}
+	registerTestRunner(t, "echo", echoRunner{})
+
+	if types := RunnerTypes(); types[len(types)-1] != "echo" {
+		t.Errorf("RunnerTypes() = %q, want echo last", types)
+	}
+
+	var stdout strings.Builder
+	yamlData := "env:\n  - key: RFY_ECHO\n    value: from-env\ncmd:\n  - type: echo\n    greeting: web\n    values: [hello]\n"
+	if err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, Stdout: &stdout, Log: &strings.Builder{}}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	if want := "from-env web hello\n"; stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"own validation", "cmd:\n  - type: echo\n", "echo blocks need values"},
+		{"key of another type", "cmd:\n  - type: echo\n    host: example.com\n    values: [hi]\n", `key "host" of command block 1 is not supported by echo blocks`},
+		{"key of the type elsewhere", "cmd:\n  - type: shell\n    greeting: web\n", `key "greeting" of command block 1 is not supported by shell blocks`},
+		{"own key checked", "cmd:\n  - type: echo\n    greeting: [web]\n    values: [hi]\n", `line 3, column 15: greeting of command block 1 must be a string, got a list`},
+		{"own key misspelled", "cmd:\n  - type: echo\n    greting: web\n    values: [hi]\n", `unknown key "greting" in command block 1, did you mean "greeting"?`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateWorkflow([]byte(tt.yaml), RunOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ValidateWorkflow() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorCommandType(t *testing.T) {
+	registerTestRunner(t, "echo", echoRunner{})
+
+	for _, name := range []string{"shell", "http", "echo"} {
+		validator := rfyerrors.NewValidator()
+		if validator.ValidateCommandType(name); validator.HasErrors() {
+			t.Errorf("ValidateCommandType(%s) error = %v, want none", name, validator.GetCombinedError())
+		}
+	}
+
+	validator := rfyerrors.NewValidator()
+	validator.ValidateCommandType("dokcer")
+	if err := validator.GetCombinedError(); err == nil || !strings.Contains(err.Error(), "Valid types: exec, shell") || !strings.Contains(err.Error(), ", echo") {
+		t.Errorf("ValidateCommandType(dokcer) error = %v, want the registered types", err)
+	}
+}
+
+func TestRegisterRunnerTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterRunner() expected a panic for a registered type")
+		}
+	}()
+	RegisterRunner(CommandTypeShell, shellRunner{})
+}
+
+func TestLookupRunnerUnknown(t *testing.T) {
+	if runner, ok := LookupRunner("dokcer"); ok || runner != nil {
+		t.Errorf("LookupRunner(dokcer) = %v, %v, want no runner", runner, ok)
+	}
+	if !reflect.DeepEqual(RunnerTypes()[:6], []CommandType{CommandTypeExec, CommandTypeShell, CommandTypeDocker, CommandTypeDockerCompose, CommandTypeSSH, CommandTypeConfig}) {
+		t.Errorf("RunnerTypes() = %q, want the built-in types first", RunnerTypes())
+	}
+}
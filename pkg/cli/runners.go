@@ -0,0 +1,492 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterRunner(CommandTypeExec, execRunner{})
+	RegisterRunner(CommandTypeShell, shellRunner{})
+	RegisterRunner(CommandTypeDocker, dockerRunner{})
+	RegisterRunner(CommandTypeDockerCompose, dockerComposeRunner{})
+	RegisterRunner(CommandTypeSSH, sshRunner{})
+	RegisterRunner(CommandTypeConfig, configRunner{})
+	RegisterRunner(CommandTypeHTTP, httpRunner{})
+	RegisterRunner(CommandTypeTemplate, templateRunner{})
+	RegisterRunner(CommandTypeFile, fileRunner{})
+}
+
+// execRunner starts the commands of its values directly
+type execRunner struct{}
+
+func (execRunner) Validate(cmd *Command) error {
+	if err := rejectShellOptions(cmd); err != nil {
+		return err
+	}
+	return validateCommandLines(cmd)
+}
+
+func (execRunner) Schema() map[string]interface{} {
+	return commandLineSchema(nil)
+}
+
+func (execRunner) Explain() string {
+	return "Execute system commands directly"
+}
+
+func (execRunner) Execute(ctx context.Context, run *BlockRun) error {
+	return run.executor.executeExecCommand(ctx, run.Command, run.out)
+}
+
+// shellRunner runs its values or script with a shell
+type shellRunner struct{}
+
+func (shellRunner) Validate(cmd *Command) error {
+	if cmd.Script != "" && len(cmd.Values) > 0 {
+		return fmt.Errorf("script and values can't be used together")
+	}
+	if cmd.Strict != nil && *cmd.Strict && cmd.Shell != "" && interpreters[cmd.Shell].strict == "" {
+		return fmt.Errorf("strict is not supported by %s", cmd.Shell)
+	}
+	return rejectArgv(cmd)
+}
+
+func (shellRunner) Schema() map[string]interface{} {
+	return map[string]interface{}{
+		"script":            stringListSchema(),
+		"preserve_newlines": map[string]interface{}{"type": "boolean"},
+		"strict":            map[string]interface{}{"type": "boolean"},
+	}
+}
+
+func (shellRunner) Explain() string {
+	return "Execute shell commands"
+}
+
+func (shellRunner) Execute(ctx context.Context, run *BlockRun) error {
+	return run.executor.executeShellCommand(ctx, run.Command, run.out)
+}
+
+// dockerRunner runs its values inside a container
+type dockerRunner struct{}
+
+// dockerOptions are the keys of docker blocks
+type dockerOptions struct {
+	// Command is the docker subcommand, like run or exec
+	Command string `yaml:"command"`
+	// Container is the image or container
+	Container string `yaml:"container"`
+}
+
+func (dockerRunner) Validate(cmd *Command) error {
+	if err := rejectShellOptions(cmd); err != nil {
+		return err
+	}
+	if err := validateCommandLines(cmd); err != nil {
+		return err
+	}
+	var o dockerOptions
+	if err := cmd.DecodeOptions(&o); err != nil {
+		return err
+	}
+	// Only validate required fields if values or argv are provided
+	if len(cmd.Values)+len(cmd.Argv) > 0 {
+		if o.Container == "" {
+			return fmt.Errorf("docker command with values or argv requires 'container' field")
+		}
+		if o.Command == "" {
+			return fmt.Errorf("docker command with values or argv requires 'command' field")
+		}
+	}
+	return nil
+}
+
+func (dockerRunner) Schema() map[string]interface{} {
+	return commandLineSchema(map[string]interface{}{
+		"command":   map[string]interface{}{"type": "string"},
+		"container": map[string]interface{}{"type": "string"},
+	})
+}
+
+func (dockerRunner) Explain() string {
+	return "Run Docker container operations"
+}
+
+func (dockerRunner) Execute(ctx context.Context, run *BlockRun) error {
+	var o dockerOptions
+	if err := run.Command.DecodeOptions(&o); err != nil {
+		return err
+	}
+	return run.executor.executeDockerCommand(ctx, run.Command, o, run.out)
+}
+
+// dockerComposeRunner runs docker compose, with its values as commands
+// inside a service
+type dockerComposeRunner struct{}
+
+// composeOptions are the keys of docker-compose blocks
+type composeOptions struct {
+	// Command is the docker compose subcommand
+	Command    string     `yaml:"command"`
+	DCOptions  StringList `yaml:"dcoptions"`
+	CmdOptions StringList `yaml:"cmdoptions"`
+	Service    string     `yaml:"service"`
+}
+
+func (dockerComposeRunner) Validate(cmd *Command) error {
+	if err := rejectShellOptions(cmd); err != nil {
+		return err
+	}
+	return validateCommandLines(cmd)
+}
+
+func (dockerComposeRunner) Schema() map[string]interface{} {
+	return commandLineSchema(map[string]interface{}{
+		"command":    map[string]interface{}{"type": "string"},
+		"dcoptions":  stringListSchema(),
+		"cmdoptions": stringListSchema(),
+		"service":    map[string]interface{}{"type": "string"},
+	})
+}
+
+func (dockerComposeRunner) Explain() string {
+	return "Execute Docker Compose operations"
+}
+
+func (dockerComposeRunner) Execute(ctx context.Context, run *BlockRun) error {
+	var o composeOptions
+	if err := run.Command.DecodeOptions(&o); err != nil {
+		return err
+	}
+	return run.executor.executeDockerComposeCommand(ctx, run.Command, o, run.out)
+}
+
+// sshRunner runs its values on a remote host
+type sshRunner struct{}
+
+// sshOptions are the keys of ssh blocks
+type sshOptions struct {
+	User string `yaml:"user"`
+	Host string `yaml:"host"`
+	// Port is the ssh port, 22 when 0
+	Port    int        `yaml:"port"`
+	Options StringList `yaml:"options"`
+}
+
+func (sshRunner) Validate(cmd *Command) error {
+	if err := rejectShellOptions(cmd); err != nil {
+		return err
+	}
+	if err := validateCommandLines(cmd); err != nil {
+		return err
+	}
+	var o sshOptions
+	if err := cmd.DecodeOptions(&o); err != nil {
+		return err
+	}
+	// Only validate required fields if values or argv are provided
+	if len(cmd.Values)+len(cmd.Argv) > 0 {
+		if o.User == "" {
+			return fmt.Errorf("ssh command with values or argv requires 'user' field")
+		}
+		if o.Host == "" {
+			return fmt.Errorf("ssh command with values or argv requires 'host' field")
+		}
+	}
+	return nil
+}
+
+func (sshRunner) Schema() map[string]interface{} {
+	return commandLineSchema(map[string]interface{}{
+		"user":    map[string]interface{}{"type": "string"},
+		"host":    map[string]interface{}{"type": "string"},
+		"port":    map[string]interface{}{"type": "integer"},
+		"options": stringListSchema(),
+	})
+}
+
+func (sshRunner) Explain() string {
+	return "Execute commands on remote server via SSH"
+}
+
+func (sshRunner) Execute(ctx context.Context, run *BlockRun) error {
+	var o sshOptions
+	if err := run.Command.DecodeOptions(&o); err != nil {
+		return err
+	}
+	return run.executor.executeSSHCommand(ctx, run.Command, o, run.out)
+}
+
+// configRunner writes a file from its confdata
+type configRunner struct{}
+
+// confOptions are the keys of conf blocks
+type confOptions struct {
+	ConfDest string `yaml:"confdest"`
+	ConfPerm int    `yaml:"confperm"`
+	ConfData string `yaml:"confdata"`
+}
+
+func (configRunner) Validate(cmd *Command) error {
+	if err := rejectShellOptions(cmd); err != nil {
+		return err
+	}
+	if err := rejectArgv(cmd); err != nil {
+		return err
+	}
+	var o confOptions
+	if err := cmd.DecodeOptions(&o); err != nil {
+		return err
+	}
+	// Config commands still require destination and data if they exist
+	if o.ConfDest != "" && o.ConfData == "" {
+		return fmt.Errorf("config command with 'confdest' requires 'confdata' field")
+	}
+	if o.ConfData != "" && o.ConfDest == "" {
+		return fmt.Errorf("config command with 'confdata' requires 'confdest' field")
+	}
+	return nil
+}
+
+func (configRunner) Schema() map[string]interface{} {
+	return map[string]interface{}{
+		"confdest": map[string]interface{}{"type": "string"},
+		"confperm": map[string]interface{}{"type": "integer"},
+		"confdata": map[string]interface{}{"type": "string"},
+	}
+}
+
+func (configRunner) Explain() string {
+	return "Create configuration file"
+}
+
+func (configRunner) Execute(_ context.Context, run *BlockRun) error {
+	var o confOptions
+	if err := run.Command.DecodeOptions(&o); err != nil {
+		return err
+	}
+	return run.executor.handleConfigCommand(run.Command, o)
+}
+
+// httpRunner sends a request and checks the response
+type httpRunner struct{}
+
+func (httpRunner) Validate(cmd *Command) error {
+	if err := rejectShellOptions(cmd); err != nil {
+		return err
+	}
+	if err := rejectArgv(cmd); err != nil {
+		return err
+	}
+	if len(cmd.Values) > 0 {
+		return fmt.Errorf("values are not supported by http blocks, use body")
+	}
+	var o httpOptions
+	if err := cmd.DecodeOptions(&o); err != nil {
+		return err
+	}
+	return validateHTTPOptions(o)
+}
+
+func (httpRunner) Schema() map[string]interface{} {
+	status := map[string]interface{}{"type": []interface{}{"integer", "string"}}
+	return map[string]interface{}{
+		"method":    map[string]interface{}{"type": "string"},
+		"url":       map[string]interface{}{"type": "string"},
+		"headers":   stringMapSchema(),
+		"body":      map[string]interface{}{"type": "string"},
+		"body_file": map[string]interface{}{"type": "string"},
+		"expect_status": map[string]interface{}{
+			"oneOf": []interface{}{status, map[string]interface{}{"type": "array", "items": status}},
+		},
+		"extract": stringMapSchema(),
+	}
+}
+
+func (httpRunner) Explain() string {
+	return "Send an HTTP request"
+}
+
+func (httpRunner) Execute(ctx context.Context, run *BlockRun) error {
+	var o httpOptions
+	if err := run.Command.DecodeOptions(&o); err != nil {
+		return err
+	}
+	return run.executor.executeHTTPRequest(ctx, run.Command, o, run.out)
+}
+
+func (httpRunner) variables(cmd *Command) []string {
+	var o httpOptions
+	if err := cmd.DecodeOptions(&o); err != nil {
+		return nil
+	}
+	return mapKeys(o.Extract)
+}
+
+// templateRunner renders a template file
+type templateRunner struct{}
+
+func (templateRunner) Validate(cmd *Command) error {
+	if err := rejectShellOptions(cmd); err != nil {
+		return err
+	}
+	if err := rejectArgv(cmd); err != nil {
+		return err
+	}
+	if len(cmd.Values) > 0 {
+		return fmt.Errorf("values are not supported by template blocks, use vars")
+	}
+	var o templateOptions
+	if err := cmd.DecodeOptions(&o); err != nil {
+		return err
+	}
+	if o.Src == "" {
+		return fmt.Errorf("template command requires 'src' field")
+	}
+	if o.Dest == "" {
+		return fmt.Errorf("template command requires 'dest' field")
+	}
+	return validateMode(o.Mode)
+}
+
+func (templateRunner) Schema() map[string]interface{} {
+	return map[string]interface{}{
+		"src":  map[string]interface{}{"type": "string"},
+		"dest": map[string]interface{}{"type": "string"},
+		"mode": map[string]interface{}{"type": "integer"},
+		"vars": map[string]interface{}{"type": "object"},
+	}
+}
+
+func (templateRunner) Explain() string {
+	return "Render a file from a template"
+}
+
+func (templateRunner) Execute(_ context.Context, run *BlockRun) error {
+	var o templateOptions
+	if err := run.Command.DecodeOptions(&o); err != nil {
+		return err
+	}
+	return run.executor.executeTemplate(run.Command, o, run.out)
+}
+
+// fileRunner brings a path into a state, like an existing directory or a
+// copy of another file
+type fileRunner struct{}
+
+func (fileRunner) Validate(cmd *Command) error {
+	if err := rejectShellOptions(cmd); err != nil {
+		return err
+	}
+	if err := rejectArgv(cmd); err != nil {
+		return err
+	}
+	if len(cmd.Values) > 0 {
+		return fmt.Errorf("values are not supported by file blocks")
+	}
+	var o fileOptions
+	if err := cmd.DecodeOptions(&o); err != nil {
+		return err
+	}
+	return validateFileOptions(o)
+}
+
+func (fileRunner) Schema() map[string]interface{} {
+	states := make([]interface{}, len(fileStates))
+	for i, state := range fileStates {
+		states[i] = state
+	}
+	return map[string]interface{}{
+		"state":     map[string]interface{}{"type": "string", "enum": states},
+		"src":       map[string]interface{}{"type": "string"},
+		"dest":      map[string]interface{}{"type": "string"},
+		"mode":      map[string]interface{}{"type": "integer"},
+		"owner":     map[string]interface{}{"type": "string"},
+		"group":     map[string]interface{}{"type": "string"},
+		"recursive": map[string]interface{}{"type": "boolean"},
+	}
+}
+
+func (fileRunner) Explain() string {
+	return "Manage files, directories and links"
+}
+
+func (fileRunner) Execute(_ context.Context, run *BlockRun) error {
+	var o fileOptions
+	if err := run.Command.DecodeOptions(&o); err != nil {
+		return err
+	}
+	return run.executor.executeFile(run.Command, o, run.out)
+}
+
+// rejectShellOptions reports the options only shell blocks support
+func rejectShellOptions(cmd *Command) error {
+	if cmd.Script != "" || cmd.PreserveNewlines || cmd.Strict != nil {
+		return fmt.Errorf("script, preserve_newlines and strict are only supported by shell blocks")
+	}
+	return nil
+}
+
+// rejectArgv reports argv for types that don't split their values into
+// commands
+func rejectArgv(cmd *Command) error {
+	if len(cmd.Argv) > 0 {
+		return fmt.Errorf("argv is not supported by %s blocks", cmd.Type)
+	}
+	return nil
+}
+
+// validateMode checks the permissions of a file written by a block
+func validateMode(mode int) error {
+	if mode < 0 || mode > 0777 {
+		return fmt.Errorf("invalid mode %o: must be between 0000 and 0777", mode)
+	}
+	return nil
+}
+
+// validateCommandLines checks the values or argv of types that split them
+// into commands
+func validateCommandLines(cmd *Command) error {
+	if len(cmd.Argv) > 0 && len(cmd.Values) > 0 {
+		return fmt.Errorf("argv and values can't be used together")
+	}
+	if cmd.Split != splitFields {
+		if _, err := splitCommandLine(strings.Join(cmd.Values, " "), nil); err != nil {
+			return fmt.Errorf("invalid values: %w", err)
+		}
+	}
+	return nil
+}
+
+// commandLineSchema adds the keys of types that split their values into
+// commands to properties
+func commandLineSchema(properties map[string]interface{}) map[string]interface{} {
+	if properties == nil {
+		properties = make(map[string]interface{})
+	}
+	command := map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}
+	properties["argv"] = map[string]interface{}{
+		"oneOf": []interface{}{command, map[string]interface{}{"type": "array", "items": command}},
+	}
+	return properties
+}
+
+// stringMapSchema describes a mapping of strings
+func stringMapSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": map[string]interface{}{"type": "string"},
+	}
+}
+
+// stringListSchema describes a StringList
+func stringListSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+	}
+}
@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	rfyerrors "github.com/lanixx/runfromyaml/pkg/errors"
+)
+
+// Selection picks the cmd blocks of a run. Blocks are referred to by name,
+// by tag or by their position in the file starting at 1. An empty selection
+// runs every block.
+type Selection struct {
+	// Only runs just the blocks matching one of these references
+	Only []string
+	// Skip leaves out the blocks matching one of these references
+	Skip []string
+	// From starts the run at this block, earlier blocks are left out
+	From string
+	// Until ends the run with this block, later blocks are left out
+	Until string
+}
+
+// IsEmpty reports whether the selection runs every block
+func (s Selection) IsEmpty() bool {
+	return len(s.Only) == 0 && len(s.Skip) == 0 && s.From == "" && s.Until == ""
+}
+
+// SplitSelection splits a comma separated list of block references
+func SplitSelection(value string) []string {
+	var refs []string
+	for _, ref := range strings.Split(value, ",") {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// selectBlocks returns which of the commands are selected. References that
+// don't match any block are reported as validation errors with suggestions.
+func (s Selection) selectBlocks(commands []*Command) ([]bool, error) {
+	selected := make([]bool, len(commands))
+	for i := range selected {
+		selected[i] = len(s.Only) == 0
+	}
+
+	for _, ref := range s.Only {
+		matches, err := matchBlocks(commands, ref, "only", true)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range matches {
+			selected[i] = true
+		}
+	}
+
+	for _, ref := range s.Skip {
+		matches, err := matchBlocks(commands, ref, "skip", true)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range matches {
+			selected[i] = false
+		}
+	}
+
+	from, until := 0, len(commands)-1
+	if s.From != "" {
+		matches, err := matchBlocks(commands, s.From, "from", false)
+		if err != nil {
+			return nil, err
+		}
+		from = matches[0]
+	}
+	if s.Until != "" {
+		matches, err := matchBlocks(commands, s.Until, "until", false)
+		if err != nil {
+			return nil, err
+		}
+		until = matches[0]
+	}
+	if from > until {
+		return nil, rfyerrors.NewValidationError(
+			fmt.Sprintf("Block %q selected with from comes after block %q selected with until", s.From, s.Until),
+			"from",
+			s.From,
+		)
+	}
+	for i := range selected {
+		if i < from || i > until {
+			selected[i] = false
+		}
+	}
+
+	return selected, nil
+}
+
+// matchBlocks returns the indexes of the blocks a reference points to. Tags
+// are only accepted when withTags is set, since from and until need a
+// single block.
+func matchBlocks(commands []*Command, ref, field string, withTags bool) ([]int, error) {
+	if index, err := strconv.Atoi(ref); err == nil {
+		if index < 1 || index > len(commands) {
+			return nil, rfyerrors.NewValidationError(
+				fmt.Sprintf("Block index %d selected with %s is out of range", index, field),
+				field,
+				ref,
+			).WithSuggestion(fmt.Sprintf("Blocks are numbered from 1 to %d", len(commands)))
+		}
+		return []int{index - 1}, nil
+	}
+
+	var matches []int
+	for i, cmd := range commands {
+		if cmd.Name == ref || (withTags && containsString(cmd.Tags, ref)) {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) > 0 {
+		return matches, nil
+	}
+
+	var candidates []string
+	for _, cmd := range commands {
+		if cmd.Name != "" {
+			candidates = append(candidates, cmd.Name)
+		}
+		if withTags {
+			candidates = append(candidates, cmd.Tags...)
+		}
+	}
+
+	err := rfyerrors.NewValidationError(fmt.Sprintf("Unknown block %q selected with %s", ref, field), field, ref)
+	if similar := similarNames(ref, candidates); len(similar) > 0 {
+		return nil, err.WithSuggestion(fmt.Sprintf("Did you mean %s?", strings.Join(similar, ", ")))
+	}
+	if withTags {
+		return nil, err.WithSuggestion("Select blocks by name, tag or index")
+	}
+	return nil, err.WithSuggestion("Select the block by name or index")
+}
+
+// similarNames returns the candidates that are close to name, best first
+func similarNames(name string, candidates []string) []string {
+	type match struct {
+		name     string
+		distance int
+	}
+
+	limit := max(2, len(name)/3)
+	seen := make(map[string]bool)
+	var matches []match
+	for _, candidate := range candidates {
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+
+		distance := levenshtein(strings.ToLower(name), strings.ToLower(candidate))
+		if distance <= limit || strings.Contains(strings.ToLower(candidate), strings.ToLower(name)) {
+			matches = append(matches, match{candidate, distance})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].distance < matches[j].distance })
+
+	var names []string
+	for i := 0; i < len(matches) && i < 3; i++ {
+		names = append(names, strconv.Quote(matches[i].name))
+	}
+	return names
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr := make([]int, len(rb)+1)
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev = curr
+	}
+	return prev[len(rb)]
+}
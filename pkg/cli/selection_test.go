@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	rfyerrors "github.com/lanixx/runfromyaml/pkg/errors"
+)
+
+func TestSelectionSelectBlocks(t *testing.T) {
+	commands := []*Command{
+		{Name: "build", Tags: []string{"ci"}},
+		{Name: "test", Tags: []string{"ci", "slow"}},
+		{Type: "shell"},
+		{Name: "deploy"},
+	}
+
+	tests := []struct {
+		name      string
+		selection Selection
+		want      []bool
+	}{
+		{"only by name", Selection{Only: []string{"test"}}, []bool{false, true, false, false}},
+		{"only by tag", Selection{Only: []string{"ci"}}, []bool{true, true, false, false}},
+		{"only by index", Selection{Only: []string{"3"}}, []bool{false, false, true, false}},
+		{"skip by tag", Selection{Skip: []string{"slow"}}, []bool{true, false, true, true}},
+		{"only and skip", Selection{Only: []string{"ci"}, Skip: []string{"build"}}, []bool{false, true, false, false}},
+		{"from", Selection{From: "test"}, []bool{false, true, true, true}},
+		{"until", Selection{Until: "3"}, []bool{true, true, true, false}},
+		{"from until", Selection{From: "test", Until: "test"}, []bool{false, true, false, false}},
+		{"range and skip", Selection{From: "2", Skip: []string{"deploy"}}, []bool{false, true, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.selection.selectBlocks(commands)
+			if err != nil {
+				t.Fatalf("selectBlocks() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectBlocks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectionSelectBlocksInvalid(t *testing.T) {
+	commands := []*Command{
+		{Name: "build", Tags: []string{"ci"}},
+		{Name: "deploy"},
+	}
+
+	tests := []struct {
+		name       string
+		selection  Selection
+		field      string
+		suggestion string
+	}{
+		{"misspelled name", Selection{Only: []string{"biuld"}}, "only", `Did you mean "build"?`},
+		{"unknown name", Selection{Skip: []string{"release-notes"}}, "skip", "Select blocks by name, tag or index"},
+		{"index out of range", Selection{Only: []string{"3"}}, "only", "Blocks are numbered from 1 to 2"},
+		{"tag in range", Selection{From: "ci"}, "from", "Select the block by name or index"},
+		{"misspelled until", Selection{Until: "deplyo"}, "until", `Did you mean "deploy"?`},
+		{"reversed range", Selection{From: "deploy", Until: "build"}, "from", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.selection.selectBlocks(commands)
+			rfyErr, ok := err.(*rfyerrors.RunFromYAMLError)
+			if !ok || rfyErr.Type != rfyerrors.ErrorTypeValidation {
+				t.Fatalf("selectBlocks() error = %v, want validation error", err)
+			}
+			if rfyErr.Context["field"] != tt.field {
+				t.Errorf("error field = %v, want %q", rfyErr.Context["field"], tt.field)
+			}
+			if tt.suggestion != "" && !containsString(rfyErr.Suggestions, tt.suggestion) {
+				t.Errorf("suggestions = %q, want %q", rfyErr.Suggestions, tt.suggestion)
+			}
+		})
+	}
+}
+
+func TestSplitSelection(t *testing.T) {
+	got := SplitSelection(" build, ,test ,")
+	if want := []string{"build", "test"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SplitSelection() = %q, want %q", got, want)
+	}
+	if got := SplitSelection(""); got != nil {
+		t.Errorf("SplitSelection(\"\") = %q, want nil", got)
+	}
+}
+
+func TestRunfromyamlWithOptionsSelection(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	workflow := []byte(fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: shell
+    name: build
+    values:
+      - echo build >> %[1]s/runs
+  - type: shell
+    name: test
+    tags: [slow]
+    needs: [build]
+    values:
+      - echo test >> %[1]s/runs
+  - type: shell
+    name: deploy
+    needs: [test]
+    values:
+      - echo deploy >> %[1]s/runs
+finally:
+  - type: shell
+    values:
+      - echo finally >> %[1]s/runs
+`, dir))
+
+	err := RunfromyamlWithOptions(context.Background(), workflow, RunOptions{
+		Parallel:  1,
+		Selection: Selection{Skip: []string{"slow"}},
+	})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+
+	runs, _ := os.ReadFile(filepath.Join(dir, "runs"))
+	if want := "build\ndeploy\nfinally\n"; string(runs) != want {
+		t.Errorf("blocks wrote %q, want %q", runs, want)
+	}
+
+	// An invalid selection fails before anything runs
+	_ = os.Remove(filepath.Join(dir, "runs"))
+	err = RunfromyamlWithOptions(context.Background(), workflow, RunOptions{
+		Parallel:  1,
+		Selection: Selection{Only: []string{"tset"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), `Unknown block "tset"`) {
+		t.Errorf("RunfromyamlWithOptions() error = %v, want unknown block error", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "runs")); err == nil {
+		t.Error("blocks ran despite an invalid selection")
+	}
+}
@@ -13,21 +13,19 @@ func TestSSHExpandenvOptions(t *testing.T) {
 	executor := &CommandExecutor{}
 
 	// Test with expandenv enabled
-	cmdWithExpandenv := &Command{
-		Options: map[string]interface{}{
-			"user":      "testuser",
-			"host":      "testhost",
-			"port":      22,
-			"expandenv": true,
-			"options": []interface{}{
-				"-i $TEST_SSH_KEY",
-				"-o ConnectTimeout=5",
-				"-o StrictHostKeyChecking=no",
-			},
+	cmdWithExpandenv := &Command{ExpandEnv: true}
+	options := sshOptions{
+		User: "testuser",
+		Host: "testhost",
+		Port: 22,
+		Options: []string{
+			"-i $TEST_SSH_KEY",
+			"-o ConnectTimeout=5",
+			"-o StrictHostKeyChecking=no",
 		},
 	}
 
-	args := executor.buildSSHArgs(cmdWithExpandenv)
+	args := executor.buildSSHArgs(cmdWithExpandenv, options)
 
 	// Check if the environment variable was expanded in options
 	found := false
@@ -43,20 +41,18 @@ func TestSSHExpandenvOptions(t *testing.T) {
 	}
 
 	// Test with expandenv disabled
-	cmdWithoutExpandenv := &Command{
-		Options: map[string]interface{}{
-			"user":      "testuser",
-			"host":      "testhost",
-			"port":      22,
-			"expandenv": false,
-			"options": []interface{}{
-				"-i $TEST_SSH_KEY",
-				"-o ConnectTimeout=5",
-			},
+	cmdWithoutExpandenv := &Command{}
+	optionsNoExpand := sshOptions{
+		User: "testuser",
+		Host: "testhost",
+		Port: 22,
+		Options: []string{
+			"-i $TEST_SSH_KEY",
+			"-o ConnectTimeout=5",
 		},
 	}
 
-	argsNoExpand := executor.buildSSHArgs(cmdWithoutExpandenv)
+	argsNoExpand := executor.buildSSHArgs(cmdWithoutExpandenv, optionsNoExpand)
 
 	// Check if the environment variable was NOT expanded
 	foundLiteral := false
@@ -84,16 +80,14 @@ func TestSSHExpandenvUserHost(t *testing.T) {
 	executor := &CommandExecutor{}
 
 	// Test with expandenv enabled
-	cmd := &Command{
-		Options: map[string]interface{}{
-			"user":      "$TEST_USER",
-			"host":      "$TEST_HOST",
-			"port":      22,
-			"expandenv": true,
-		},
+	cmd := &Command{ExpandEnv: true}
+	options := sshOptions{
+		User: "$TEST_USER",
+		Host: "$TEST_HOST",
+		Port: 22,
 	}
 
-	args := executor.buildSSHArgs(cmd)
+	args := executor.buildSSHArgs(cmd, options)
 
 	// Check if user and host were expanded
 	expectedArgs := []string{"ssh", "-p", "22", "-l", "expandeduser", "expandedhost"}
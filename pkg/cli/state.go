@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// StateFileSuffix is appended to the workflow file name to get the default
+// location of its run-state file
+const StateFileSuffix = ".state.json"
+
+// runState records the outcome of the command blocks of a workflow, so that
+// a failed run can be resumed without repeating the blocks that succeeded
+type runState struct {
+	mu   sync.Mutex
+	path string
+	// keys holds the key of every cmd block, see stateKeys
+	keys   []string
+	Blocks map[string]blockState `json:"blocks"`
+}
+
+// blockState is the recorded outcome of a single command block
+type blockState struct {
+	Hash     string      `json:"hash"`
+	Status   BlockStatus `json:"status"`
+	Finished time.Time   `json:"finished"`
+	// Registered holds the variables set by the register option of the
+	// block or by its runner, like the extract option of http blocks. They
+	// are restored when the block is skipped on resume.
+	Registered map[string]string `json:"registered,omitempty"`
+}
+
+// loadRunState opens the run-state file at path. The recorded outcomes are
+// only read when resuming, otherwise the run starts with an empty state.
+func loadRunState(path string, resume bool) (*runState, error) {
+	state := &runState{path: path, Blocks: make(map[string]blockState)}
+	if !resume {
+		return state, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse run state %s: %w", path, err)
+	}
+	if state.Blocks == nil {
+		state.Blocks = make(map[string]blockState)
+	}
+	return state, nil
+}
+
+// succeeded returns the recorded outcome of a block if it succeeded with the
+// same content
+func (s *runState) succeeded(key, hash string) (blockState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	block, ok := s.Blocks[key]
+	return block, ok && block.Status == BlockStatusSuccess && block.Hash == hash
+}
+
+// record stores the outcome of a block and writes the state file, so that
+// it survives the process being killed
+func (s *runState) record(key string, block blockState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Blocks[key] = block
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// remove deletes the state file once it is no longer needed
+func (s *runState) remove() error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// stateKeys identifies the blocks in the run state. Named blocks get their
+// name followed by the number of its occurrence, like build#1 and build#2,
+// so blocks sharing a name can't be mixed up on resume. Unnamed blocks get
+// their position, like #3. The number after the last # always belongs to the
+// key, so names containing # can't collide with other keys.
+func stateKeys(commands []*Command) []string {
+	keys := make([]string, len(commands))
+	seen := make(map[string]int)
+	for i, cmd := range commands {
+		if cmd.Name == "" {
+			keys[i] = "#" + strconv.Itoa(i+1)
+			continue
+		}
+		seen[cmd.Name]++
+		keys[i] = cmd.Name + "#" + strconv.Itoa(seen[cmd.Name])
+	}
+	return keys
+}
+
+// blockHash returns a hash of the definition of a command block
+func blockHash(block interface{}) string {
+	data, err := yaml.Marshal(block)
+	if err != nil {
+		data = []byte(fmt.Sprint(block))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRunfromyamlWithOptionsResume(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "workflow.yaml"+StateFileSuffix)
+	workflow := func(second string) []byte {
+		return []byte(fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: shell
+    name: first
+    register: FIRST
+    values:
+      - echo first >> %[1]s/runs; echo from-first
+  - type: shell
+    values:
+      - echo "$FIRST" >> %[1]s/runs; %[2]s
+`, dir, second))
+	}
+
+	// The second block fails, the first one is recorded as done
+	err := RunfromyamlWithOptions(context.Background(), workflow("exit 1"), RunOptions{Parallel: 1, StateFile: stateFile})
+	if err == nil {
+		t.Fatal("RunfromyamlWithOptions() expected error")
+	}
+	state, err := loadRunState(stateFile, true)
+	if err != nil {
+		t.Fatalf("loadRunState() unexpected error: %v", err)
+	}
+	if state.Blocks["first#1"].Status != BlockStatusSuccess || state.Blocks["#2"].Status != BlockStatusFailed {
+		t.Fatalf("run state = %+v, want first succeeded and #2 failed", state.Blocks)
+	}
+
+	// Resuming skips the first block but restores its registered output
+	_ = os.Unsetenv("FIRST")
+	err = RunfromyamlWithOptions(context.Background(), workflow("true"), RunOptions{Parallel: 1, StateFile: stateFile, Resume: true})
+	if err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error on resume: %v", err)
+	}
+
+	runs, _ := os.ReadFile(filepath.Join(dir, "runs"))
+	if want := "first\nfrom-first\nfrom-first\n"; string(runs) != want {
+		t.Errorf("blocks wrote %q, want %q", runs, want)
+	}
+	if _, err := os.Stat(stateFile); err == nil {
+		t.Error("run state was kept after a successful run")
+	}
+}
+
+func TestStateKeys(t *testing.T) {
+	commands := []*Command{{Name: "build"}, {}, {Name: "build"}, {Name: "build#2"}, {Name: "#2"}, {Name: "build"}}
+	want := []string{"build#1", "#2", "build#2", "build#2#1", "#2#1", "build#3"}
+	if got := stateKeys(commands); !reflect.DeepEqual(got, want) {
+		t.Errorf("stateKeys() = %v, want %v", got, want)
+	}
+}
+
+func TestRunStateSucceeded(t *testing.T) {
+	state, err := loadRunState(filepath.Join(t.TempDir(), "state.json"), true)
+	if err != nil {
+		t.Fatalf("loadRunState() unexpected error: %v", err)
+	}
+
+	if err := state.record("build", blockState{Hash: "abc", Status: BlockStatusSuccess}); err != nil {
+		t.Fatalf("record() unexpected error: %v", err)
+	}
+	if err := state.record("#2", blockState{Hash: "def", Status: BlockStatusFailed}); err != nil {
+		t.Fatalf("record() unexpected error: %v", err)
+	}
+
+	reloaded, err := loadRunState(state.path, true)
+	if err != nil {
+		t.Fatalf("loadRunState() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		key, hash string
+		want      bool
+	}{
+		{"build", "abc", true},
+		{"build", "changed", false},
+		{"#2", "def", false},
+		{"unknown", "abc", false},
+	}
+	for _, tt := range tests {
+		if _, got := reloaded.succeeded(tt.key, tt.hash); got != tt.want {
+			t.Errorf("succeeded(%q, %q) = %v, want %v", tt.key, tt.hash, got, tt.want)
+		}
+	}
+
+	fresh, err := loadRunState(state.path, false)
+	if err != nil {
+		t.Fatalf("loadRunState() unexpected error: %v", err)
+	}
+	if len(fresh.Blocks) != 0 {
+		t.Errorf("loadRunState() without resume = %v, want empty state", fresh.Blocks)
+	}
+}
+
+func TestLoadRunStateInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	if _, err := loadRunState(path, true); err == nil || !strings.Contains(err.Error(), "failed to parse run state") {
+		t.Errorf("loadRunState() error = %v, want parse error", err)
+	}
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"sync"
+	"time"
+)
+
+// BlockStatus represents the outcome of a command block
+type BlockStatus string
+
+const (
+	BlockStatusSuccess BlockStatus = "success"
+	BlockStatusFailed  BlockStatus = "failed"
+	BlockStatusSkipped BlockStatus = "skipped"
+)
+
+// BlockResult describes the outcome of a single command block. Reason
+// explains why a block failed or was skipped. Argv lists the commands started
+// by the block, ExitCode is the exit code of the last one. Stdout and Stderr
+// hold the beginning of the output, Truncated is set when some was dropped.
+// Changed is set by block types that know whether they changed something.
+type BlockResult struct {
+	Section   string        `json:"section"`
+	Index     int           `json:"index"`
+	Name      string        `json:"name"`
+	Type      CommandType   `json:"type"`
+	Source    string        `json:"source,omitempty"`
+	Status    BlockStatus   `json:"status"`
+	Reason    string        `json:"reason,omitempty"`
+	Argv      [][]string    `json:"argv,omitempty"`
+	Started   time.Time     `json:"started"`
+	Finished  time.Time     `json:"finished"`
+	Duration  time.Duration `json:"duration"`
+	ExitCode  *int          `json:"exit_code,omitempty"`
+	Stdout    string        `json:"stdout,omitempty"`
+	Stderr    string        `json:"stderr,omitempty"`
+	Truncated bool          `json:"truncated,omitempty"`
+	Changed   *bool         `json:"changed,omitempty"`
+}
+
+// blockResults records the outcome of named command blocks during a run
+type blockResults struct {
+	mu     sync.RWMutex
+	byName map[string]BlockStatus
+}
+
+// newBlockResults creates an empty result store
+func newBlockResults() *blockResults {
+	return &blockResults{byName: make(map[string]BlockStatus)}
+}
+
+// set records the status of a block, unnamed blocks are ignored
+func (r *blockResults) set(name string, status BlockStatus) {
+	if name == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byName[name] = status
+}
+
+// get returns the status of a block, or an empty status if it has not run
+func (r *blockResults) get(name string) BlockStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byName[name]
+}
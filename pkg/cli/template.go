@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultFileMode is the mode of new files written without one
+const defaultFileMode os.FileMode = 0644
+
+// templateOptions are the keys of template blocks
+type templateOptions struct {
+	Src  string `yaml:"src"`
+	Dest string `yaml:"dest"`
+	Mode int    `yaml:"mode"`
+	// Vars holds the values for the template
+	Vars map[string]interface{} `yaml:"vars"`
+}
+
+// executeTemplate renders the src of a template block into its dest. The
+// file is only written when its content or mode changes. A relative src
+// belongs to the workflow, so it is resolved from the directory of the
+// workflow file.
+func (e *CommandExecutor) executeTemplate(cmd *Command, o templateOptions, out *blockOutput) error {
+	src := e.expandEnv(cmd, o.Src)
+	if cmd.Dir != "" && !filepath.IsAbs(src) {
+		src = filepath.Join(cmd.Dir, src)
+	}
+	dest := e.resolvePath(cmd, e.expandEnv(cmd, o.Dest))
+
+	content, err := e.renderTemplate(cmd, src, o.Vars)
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(o.Mode)
+	if e.config.DryRun {
+		if mode == 0 {
+			mode = defaultFileMode
+		}
+		e.planConfig(string(content), dest, mode)
+		return nil
+	}
+
+	changed, err := writeFileIfChanged(dest, content, mode)
+	if err != nil {
+		return err
+	}
+	out.setChanged(changed)
+	if changed {
+		e.print(color.FgGreen, fmt.Sprintf("# render %s to %s", src, dest))
+	} else {
+		e.print(color.FgGreen, fmt.Sprintf("# %s is unchanged", dest))
+	}
+	return nil
+}
+
+// renderTemplate executes the template file src with the variables of the
+// block, overridden by its vars. Referring to a missing variable is an error.
+func (e *CommandExecutor) renderTemplate(cmd *Command, src string, vars map[string]interface{}) ([]byte, error) {
+	text, err := os.ReadFile(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+
+	tmpl, err := template.New(filepath.Base(src)).Option("missingkey=error").Funcs(e.templateFuncs(cmd)).Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	data := make(map[string]interface{})
+	for key, value := range e.templateVariables(cmd) {
+		data[key] = value
+	}
+	expanded := expandStrings(vars, func(s string) string { return e.expandOption(cmd, s) })
+	for key, value := range jsonObject(expanded) {
+		data[key] = value
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return rendered.Bytes(), nil
+}
+
+// templateFuncs returns the functions available to the templates of a block
+func (e *CommandExecutor) templateFuncs(cmd *Command) template.FuncMap {
+	return template.FuncMap{
+		"default": func(fallback, value interface{}) interface{} {
+			if isEmptyValue(value) {
+				return fallback
+			}
+			return value
+		},
+		"required": func(message string, value interface{}) (interface{}, error) {
+			if isEmptyValue(value) {
+				return nil, errors.New(message)
+			}
+			return value, nil
+		},
+		"env": func(key string) string {
+			value, _ := e.lookupEnv(cmd, key)
+			return value
+		},
+		"toYaml": func(value interface{}) (string, error) {
+			data, err := yaml.Marshal(value)
+			return strings.TrimSuffix(string(data), "\n"), err
+		},
+		"toJson": func(value interface{}) (string, error) {
+			data, err := json.Marshal(jsonValue(value))
+			return string(data), err
+		},
+		"b64enc": func(value interface{}) string {
+			return base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(value)))
+		},
+		"indent": func(spaces int, text string) string {
+			pad := strings.Repeat(" ", spaces)
+			return pad + strings.ReplaceAll(text, "\n", "\n"+pad)
+		},
+		"join": func(sep string, value interface{}) string {
+			items := reflect.ValueOf(value)
+			if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+				return fmt.Sprint(value)
+			}
+			parts := make([]string, items.Len())
+			for i := range parts {
+				parts[i] = fmt.Sprint(items.Index(i).Interface())
+			}
+			return strings.Join(parts, sep)
+		},
+	}
+}
+
+// isEmptyValue reports whether a template value is missing or the zero value
+// of its type, like an empty string or list
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+// writeFileIfChanged writes data to path unless the file already has this
+// content and mode and reports whether it changed. A mode of 0 keeps the
+// mode of an existing file and creates new files with defaultFileMode.
+func writeFileIfChanged(path string, data []byte, mode os.FileMode) (bool, error) {
+	changed, err := fileChanged(path, data, mode)
+	if err != nil || !changed {
+		return false, err
+	}
+	return true, writeFile(path, data, mode)
+}
+
+// fileChanged reports whether writing data with mode to path would change
+// the file
+func fileChanged(path string, data []byte, mode os.FileMode) (bool, error) {
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		return false, fmt.Errorf("%s is a directory", path)
+	case os.IsNotExist(err):
+		return true, nil
+	case err != nil:
+		return false, fmt.Errorf("failed to check %s: %w", path, err)
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	// Windows only knows read-only files, so their mode isn't compared
+	sameMode := mode == 0 || runtime.GOOS == "windows" || info.Mode().Perm() == mode
+	return !bytes.Equal(current, data) || !sameMode, nil
+}
+
+// writeFile writes data to path with mode, or with the mode the file already
+// has or defaultFileMode for new files when it is 0
+func writeFile(path string, data []byte, mode os.FileMode) error {
+	perm := mode
+	if perm == 0 {
+		perm = defaultFileMode
+	}
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	// WriteFile only applies the mode to new files
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return fmt.Errorf("failed to change the mode of %s: %w", path, err)
+		}
+	}
+	return nil
+}
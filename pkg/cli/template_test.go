@@ -0,0 +1,223 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTemplateBlock(t *testing.T) {
+	dir := t.TempDir()
+	template := `listen {{ .port }};
+server_name {{ index . "server_name" | default "localhost" }};
+root {{ env "WEB_ROOT" }};
+app {{ .APP }}
+{{- range .upstreams }}
+upstream {{ . }};
+{{- end }}
+hosts: {{ join "," .upstreams }}
+json: {{ toJson .labels }}
+yaml:
+{{ toYaml .labels | indent 2 }}
+auth: {{ b64enc "user:pass" }}
+name: {{ required "name is required" .name }}
+`
+	// The template belongs to the workflow, the destination to the workdir
+	if err := os.MkdirAll(filepath.Join(dir, "templates"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "templates", "nginx.conf.tmpl"), []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	workdir := t.TempDir()
+
+	yamlData := `
+env:
+  - key: WEB_ROOT
+    value: /srv/www
+cmd:
+  - type: template
+    workdir: ` + workdir + `
+    expandenv: true
+    src: templates/nginx.conf.tmpl
+    dest: nginx.conf
+    mode: 0600
+    env:
+      APP: shop
+    vars:
+      port: 8080
+      name: $APP-web
+      upstreams: [app1, app2]
+      labels:
+        tier: web
+        zone: a
+`
+	var log strings.Builder
+	options := RunOptions{Parallel: 1, File: filepath.Join(dir, "workflow.yaml"), Log: &log}
+	if err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), options); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+
+	want := `listen 8080;
+server_name localhost;
+root /srv/www;
+app shop
+upstream app1;
+upstream app2;
+hosts: app1,app2
+json: {"tier":"web","zone":"a"}
+yaml:
+  tier: web
+  zone: a
+auth: dXNlcjpwYXNz
+name: shop-web
+`
+	dest := filepath.Join(workdir, "nginx.conf")
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("rendered:\n%s\nwant:\n%s", got, want)
+	}
+	if info, err := os.Stat(dest); err == nil && runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %o, want 0600", info.Mode().Perm())
+	}
+	if !strings.Contains(log.String(), "# render ") {
+		t.Errorf("log = %q, want the rendered file", log.String())
+	}
+
+	log.Reset()
+	if err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), options); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	if !strings.Contains(log.String(), dest+" is unchanged") {
+		t.Errorf("log = %q, want the file to be unchanged", log.String())
+	}
+}
+
+func TestTemplateBlockErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{name: "required", template: `{{ required "name is required" (index . "name") }}`, want: "name is required"},
+		{name: "missing variable", template: `{{ .name }}`, want: `map has no entry for key "name"`},
+		{name: "parse error", template: `{{ .name `, want: "failed to parse template"},
+		{name: "unknown function", template: `{{ sprig .name }}`, want: "failed to parse template"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "src.tmpl"), []byte(tt.template), 0644); err != nil {
+				t.Fatal(err)
+			}
+			yamlData := "cmd:\n  - type: template\n    workdir: " + dir + "\n    src: src.tmpl\n    dest: out\n"
+			report, err := RunfromyamlWithReport(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, File: filepath.Join(dir, "workflow.yaml"), Log: &strings.Builder{}})
+			if err == nil {
+				t.Fatal("RunfromyamlWithReport() expected error")
+			}
+			if !strings.Contains(report.Blocks[0].Reason, tt.want) {
+				t.Errorf("reason = %q, want %q", report.Blocks[0].Reason, tt.want)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "out")); err == nil {
+				t.Error("failed template wrote its destination")
+			}
+		})
+	}
+}
+
+func TestTemplateBlockIncluded(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"common/app.conf.tmpl": "port={{ .port }}\n",
+		"common/render.yaml":   "cmd:\n  - type: template\n    src: app.conf.tmpl\n    dest: " + filepath.Join(dir, "app.conf") + "\n    vars: {port: 80}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The src of an included block is relative to the included file
+	options := RunOptions{Parallel: 1, File: filepath.Join(dir, "workflow.yaml"), Log: &strings.Builder{}}
+	if err := RunfromyamlWithOptions(context.Background(), []byte("include: common/render.yaml\n"), options); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "app.conf")); string(got) != "port=80\n" {
+		t.Errorf("rendered %q, want %q", got, "port=80\n")
+	}
+}
+
+func TestTemplateBlockDryRun(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "src.tmpl"), []byte("port={{ .port }}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var log strings.Builder
+	yamlData := "cmd:\n  - type: template\n    workdir: " + dir + "\n    src: src.tmpl\n    dest: app.conf\n    vars: {port: 80}\n"
+	if err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), RunOptions{Parallel: 1, File: filepath.Join(dir, "workflow.yaml"), DryRun: true, Log: &log}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	if !strings.Contains(log.String(), "would write "+filepath.Join(dir, "app.conf")+" (mode 0644)") || !strings.Contains(log.String(), "+port=80") {
+		t.Errorf("log = %q, want the planned file and its content", log.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app.conf")); err == nil {
+		t.Error("dry run wrote the file")
+	}
+}
+
+func TestTemplateBlockValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  string
+	}{
+		{name: "missing src", block: "dest: out", want: "requires 'src' field"},
+		{name: "missing dest", block: "src: in", want: "requires 'dest' field"},
+		{name: "invalid mode", block: "src: in\n    dest: out\n    mode: 01777", want: "invalid mode"},
+		{name: "values", block: "src: in\n    dest: out\n    values: [a]", want: "values are not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateWorkflow([]byte("cmd:\n  - type: template\n    "+tt.block+"\n"), RunOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ValidateWorkflow() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsEmptyValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  bool
+	}{
+		{nil, true},
+		{"", true},
+		{[]interface{}{}, true},
+		{map[string]interface{}{}, true},
+		{0, true},
+		{false, true},
+		{"a", false},
+		{[]interface{}{1}, false},
+		{1, false},
+		{true, false},
+	}
+	for _, tt := range tests {
+		if got := isEmptyValue(tt.value); got != tt.want {
+			t.Errorf("isEmptyValue(%#v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
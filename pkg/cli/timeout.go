@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// processWaitDelay bounds how long a cancelled command may keep its output
+// pipes open before it is given up on
+const processWaitDelay = 5 * time.Second
+
+// TimeoutError is returned when a command block exceeds its timeout
+type TimeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+// Error implements the error interface
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s: %v", e.Timeout, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// parseDuration converts a duration setting. Strings use the Go duration
+// format (e.g. "90s", "5m"), plain numbers are taken as seconds.
+func parseDuration(value Duration) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	var d time.Duration
+	if seconds, err := strconv.Atoi(string(value)); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if seconds, err := strconv.ParseFloat(string(value), 64); err == nil {
+		nanoseconds := seconds * float64(time.Second)
+		if math.IsNaN(nanoseconds) || math.Abs(nanoseconds) >= math.MaxInt64 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		d = time.Duration(nanoseconds)
+	} else {
+		parsed, err := time.ParseDuration(string(value))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		d = parsed
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("duration must not be negative: %s", d)
+	}
+	return d, nil
+}
+
+// isTerminal reports whether f is connected to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
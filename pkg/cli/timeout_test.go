@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   Duration
+		want    time.Duration
+		wantErr bool
+	}{
+		{"unset", "", 0, false},
+		{"seconds", "30", 30 * time.Second, false},
+		{"go duration", "1m30s", 90 * time.Second, false},
+		{"fractional", "0.5", 500 * time.Millisecond, false},
+		{"invalid string", "soon", 0, true},
+		{"negative", "-5s", 0, true},
+		{"not a number", "NaN", 0, true},
+		{"too long", "1e300", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDuration(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDuration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteContextTimeoutKillsProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping process group test on Windows")
+	}
+
+	executor := NewCommandExecutor(CommandConfig{
+		Env:    NewEnvironment(),
+		Level:  LogLevelInfo,
+		Output: OutputTypeFile,
+	})
+
+	cmd := &Command{
+		Type:    CommandTypeShell,
+		Values:  []string{"sleep 30 & sleep 30"},
+		Timeout: 200 * time.Millisecond,
+	}
+
+	start := time.Now()
+	err := executor.ExecuteContext(context.Background(), cmd)
+	elapsed := time.Since(start)
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("ExecuteContext() error = %v, want TimeoutError", err)
+	}
+	// The background sleep keeps the output pipe open unless the whole
+	// process group is killed, which would delay the return by processWaitDelay
+	if elapsed >= processWaitDelay {
+		t.Errorf("ExecuteContext() returned after %s, process group was not killed", elapsed)
+	}
+}
+
+func TestRunfromyamlBlockTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	yamlData := `
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: shell
+    name: hanging-step
+    timeout: 200ms
+    values:
+      - sleep 30
+`
+
+	err := Runfromyaml([]byte(yamlData), false)
+	if err == nil || !strings.Contains(err.Error(), "command block 1 (hanging-step) timed out after 200ms") {
+		t.Errorf("Runfromyaml() error = %v, want timeout error naming the block", err)
+	}
+}
+
+func TestRunfromyamlWorkflowTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	yamlData := `
+timeout: 30s
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: shell
+    name: slow
+    values:
+      - sleep 30
+  - type: shell
+    name: never
+    values:
+      - echo never
+`
+
+	opts := RunOptions{Parallel: 1, Timeout: 200 * time.Millisecond}
+	err := RunfromyamlWithOptions(context.Background(), []byte(yamlData), opts)
+	if err == nil || !strings.Contains(err.Error(), "command block 1 (slow) aborted: workflow timed out after 200ms") {
+		t.Errorf("RunfromyamlWithOptions() error = %v, want workflow timeout error", err)
+	}
+}
+
+func TestRunfromyamlInvalidTimeout(t *testing.T) {
+	yamlData := `
+cmd:
+  - type: shell
+    timeout: later
+    values:
+      - echo never
+`
+
+	err := Runfromyaml([]byte(yamlData), false)
+	if want := `line 4, column 14: timeout of command block 1: invalid duration "later"`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Runfromyaml() error = %v, want %q", err, want)
+	}
+}
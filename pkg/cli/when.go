@@ -0,0 +1,510 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+// whenContext holds everything a when expression can refer to
+type whenContext struct {
+	env      *Environment
+	results  *blockResults
+	os       string
+	arch     string
+	hostname string
+}
+
+// newWhenContext collects the host facts used by when expressions
+func newWhenContext(env *Environment, results *blockResults) *whenContext {
+	hostname, _ := os.Hostname()
+	return &whenContext{
+		env:      env,
+		results:  results,
+		os:       runtime.GOOS,
+		arch:     runtime.GOARCH,
+		hostname: hostname,
+	}
+}
+
+// whenExpr is a parsed node of a when expression
+type whenExpr interface {
+	eval(ctx *whenContext) (string, error)
+}
+
+type whenLiteral struct{ value string }
+
+type whenIdent struct{ name string }
+
+type whenNot struct{ expr whenExpr }
+
+type whenBinary struct {
+	op          string
+	left, right whenExpr
+}
+
+type whenCall struct {
+	name string
+	args []whenExpr
+}
+
+// whenFunctions lists the functions available in when expressions and the
+// number of arguments they take
+var whenFunctions = map[string]int{
+	"succeeded": 1,
+	"failed":    1,
+	"skipped":   1,
+	"defined":   1,
+}
+
+func (l *whenLiteral) eval(*whenContext) (string, error) {
+	return l.value, nil
+}
+
+func (i *whenIdent) eval(ctx *whenContext) (string, error) {
+	switch {
+	case i.name == "os":
+		return ctx.os, nil
+	case i.name == "arch":
+		return ctx.arch, nil
+	case i.name == "hostname":
+		return ctx.hostname, nil
+	case strings.HasPrefix(i.name, "env."):
+		return ctx.env.Get(strings.TrimPrefix(i.name, "env.")), nil
+	case strings.HasPrefix(i.name, "result."):
+		return string(ctx.results.get(strings.TrimPrefix(i.name, "result."))), nil
+	}
+	return "", fmt.Errorf("unknown identifier %q", i.name)
+}
+
+func (n *whenNot) eval(ctx *whenContext) (string, error) {
+	value, err := n.expr.eval(ctx)
+	if err != nil {
+		return "", err
+	}
+	return boolString(!truthy(value)), nil
+}
+
+func (b *whenBinary) eval(ctx *whenContext) (string, error) {
+	left, err := b.left.eval(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Short-circuit logical operators
+	switch b.op {
+	case "&&":
+		if !truthy(left) {
+			return boolString(false), nil
+		}
+	case "||":
+		if truthy(left) {
+			return boolString(true), nil
+		}
+	}
+
+	right, err := b.right.eval(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	switch b.op {
+	case "&&", "||":
+		return boolString(truthy(right)), nil
+	case "==":
+		return boolString(left == right), nil
+	case "!=":
+		return boolString(left != right), nil
+	case "=~", "!~":
+		re, err := regexp.Compile(right)
+		if err != nil {
+			return "", fmt.Errorf("invalid regular expression %q: %w", right, err)
+		}
+		return boolString(re.MatchString(left) == (b.op == "=~")), nil
+	}
+	return "", fmt.Errorf("unknown operator %q", b.op)
+}
+
+func (c *whenCall) eval(ctx *whenContext) (string, error) {
+	// defined() takes the variable itself rather than its value
+	if ident, ok := c.args[0].(*whenIdent); ok && c.name == "defined" && strings.HasPrefix(ident.name, "env.") {
+		_, ok := ctx.env.Lookup(strings.TrimPrefix(ident.name, "env."))
+		return boolString(ok), nil
+	}
+
+	arg, err := c.args[0].eval(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	switch c.name {
+	case "succeeded":
+		return boolString(ctx.results.get(arg) == BlockStatusSuccess), nil
+	case "failed":
+		return boolString(ctx.results.get(arg) == BlockStatusFailed), nil
+	case "skipped":
+		return boolString(ctx.results.get(arg) == BlockStatusSkipped), nil
+	case "defined":
+		_, ok := ctx.env.Lookup(arg)
+		return boolString(ok), nil
+	}
+	return "", fmt.Errorf("unknown function %q", c.name)
+}
+
+// truthy reports whether a value counts as true
+func truthy(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "0", "false", "no", "off":
+		return false
+	}
+	return true
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+// evaluateWhen parses and evaluates a when expression
+func evaluateWhen(expression string, ctx *whenContext) (bool, error) {
+	expr, err := parseWhen(expression)
+	if err != nil {
+		return false, err
+	}
+	value, err := expr.eval(ctx)
+	if err != nil {
+		return false, err
+	}
+	return truthy(value), nil
+}
+
+// whenBlockReferences returns the names of all blocks whose results are used
+// by the expression
+func whenBlockReferences(expr whenExpr) []string {
+	var names []string
+	var walk func(e whenExpr)
+	walk = func(e whenExpr) {
+		switch n := e.(type) {
+		case *whenIdent:
+			if strings.HasPrefix(n.name, "result.") {
+				names = append(names, strings.TrimPrefix(n.name, "result."))
+			}
+		case *whenNot:
+			walk(n.expr)
+		case *whenBinary:
+			walk(n.left)
+			walk(n.right)
+		case *whenCall:
+			if n.name != "defined" {
+				if literal, ok := n.args[0].(*whenLiteral); ok {
+					names = append(names, literal.value)
+				}
+			}
+			for _, arg := range n.args {
+				walk(arg)
+			}
+		}
+	}
+	walk(expr)
+	return names
+}
+
+// whenReferences returns the names of the blocks used by the when expression
+// of the command
+func (c *Command) whenReferences() []string {
+	if c.When == "" {
+		return nil
+	}
+	expr, err := parseWhen(c.When)
+	if err != nil {
+		return nil
+	}
+	return whenBlockReferences(expr)
+}
+
+// whenToken is a lexical token of a when expression
+type whenToken struct {
+	kind  string // "string", "word", "op" or "eof"
+	value string
+	pos   int
+}
+
+// tokenizeWhen splits a when expression into tokens
+func tokenizeWhen(expression string) ([]whenToken, error) {
+	var tokens []whenToken
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", start+1)
+			}
+			i++
+			tokens = append(tokens, whenToken{kind: "string", value: sb.String(), pos: start})
+		case r == '$':
+			start := i
+			i++
+			braced := i < len(runes) && runes[i] == '{'
+			if braced {
+				i++
+			}
+			nameStart := i
+			for i < len(runes) && isWhenNameRune(runes[i]) && runes[i] != '.' && runes[i] != '-' {
+				i++
+			}
+			name := string(runes[nameStart:i])
+			if braced {
+				if i >= len(runes) || runes[i] != '}' {
+					return nil, fmt.Errorf("unterminated variable at position %d", start+1)
+				}
+				i++
+			}
+			if name == "" {
+				return nil, fmt.Errorf("missing variable name at position %d", start+1)
+			}
+			tokens = append(tokens, whenToken{kind: "word", value: "env." + name, pos: start})
+		case isWhenNameRune(r):
+			start := i
+			for i < len(runes) && isWhenNameRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, whenToken{kind: "word", value: string(runes[start:i]), pos: start})
+		default:
+			start := i
+			op := string(r)
+			if i+1 < len(runes) {
+				two := string(runes[i : i+2])
+				switch two {
+				case "==", "!=", "&&", "||", "=~", "!~":
+					op = two
+				}
+			}
+			switch op {
+			case "==", "!=", "&&", "||", "=~", "!~", "!", "(", ")", ",":
+			default:
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, start+1)
+			}
+			i += len([]rune(op))
+			tokens = append(tokens, whenToken{kind: "op", value: op, pos: start})
+		}
+	}
+
+	return append(tokens, whenToken{kind: "eof", pos: len(runes)}), nil
+}
+
+func isWhenNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-'
+}
+
+// whenParser is a recursive descent parser for when expressions:
+//
+//	expr    = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | compare
+//	compare = operand [ ( "==" | "!=" | "=~" | "!~" ) operand ]
+//	operand = string | number | identifier | function "(" expr ")" | "(" expr ")"
+type whenParser struct {
+	tokens []whenToken
+	pos    int
+}
+
+// parseWhen parses a when expression
+func parseWhen(expression string) (whenExpr, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, fmt.Errorf("empty when expression")
+	}
+
+	tokens, err := tokenizeWhen(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid when expression %q: %w", expression, err)
+	}
+
+	p := &whenParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err == nil && p.peek().kind != "eof" {
+		err = fmt.Errorf("unexpected %q at position %d", p.peek().value, p.peek().pos+1)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid when expression %q: %w", expression, err)
+	}
+	return expr, nil
+}
+
+func (p *whenParser) peek() whenToken {
+	return p.tokens[p.pos]
+}
+
+func (p *whenParser) next() whenToken {
+	token := p.tokens[p.pos]
+	if token.kind != "eof" {
+		p.pos++
+	}
+	return token
+}
+
+func (p *whenParser) accept(op string) bool {
+	if token := p.peek(); token.kind == "op" && token.value == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *whenParser) parseOr() (whenExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &whenBinary{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *whenParser) parseAnd() (whenExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &whenBinary{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *whenParser) parseUnary() (whenExpr, error) {
+	if p.accept("!") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &whenNot{expr: expr}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *whenParser) parseCompare() (whenExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "=~", "!~"} {
+		if p.accept(op) {
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return &whenBinary{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *whenParser) parseOperand() (whenExpr, error) {
+	token := p.next()
+	switch token.kind {
+	case "string":
+		return &whenLiteral{value: token.value}, nil
+	case "word":
+		if isNumber(token.value) || token.value == "true" || token.value == "false" {
+			return &whenLiteral{value: token.value}, nil
+		}
+		if arity, ok := whenFunctions[token.value]; ok {
+			return p.parseCall(token, arity)
+		}
+		if !isKnownIdentifier(token.value) {
+			return nil, fmt.Errorf("unknown identifier %q at position %d", token.value, token.pos+1)
+		}
+		return &whenIdent{name: token.value}, nil
+	case "op":
+		if token.value == "(" {
+			expr, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, fmt.Errorf("missing ')' at position %d", p.peek().pos+1)
+			}
+			return expr, nil
+		}
+		return nil, fmt.Errorf("unexpected %q at position %d", token.value, token.pos+1)
+	}
+	return nil, fmt.Errorf("unexpected end of expression")
+}
+
+func (p *whenParser) parseCall(name whenToken, arity int) (whenExpr, error) {
+	if !p.accept("(") {
+		return nil, fmt.Errorf("function %s at position %d requires arguments", name.value, name.pos+1)
+	}
+	var args []whenExpr
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if !p.accept(",") {
+				return nil, fmt.Errorf("missing ')' at position %d", p.peek().pos+1)
+			}
+		}
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("function %s expects %d argument(s), got %d", name.value, arity, len(args))
+	}
+	return &whenCall{name: name.value, args: args}, nil
+}
+
+func isKnownIdentifier(name string) bool {
+	switch name {
+	case "os", "arch", "hostname":
+		return true
+	}
+	for _, prefix := range []string{"env.", "result."} {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNumber(value string) bool {
+	if value == "" || !unicode.IsDigit([]rune(value)[0]) {
+		return false
+	}
+	for _, r := range value {
+		if !unicode.IsDigit(r) && r != '.' {
+			return false
+		}
+	}
+	return true
+}
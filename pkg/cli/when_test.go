@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func newTestWhenContext() *whenContext {
+	env := NewEnvironment()
+	env.variables["STAGE"] = "prod"
+	env.variables["EMPTY"] = ""
+
+	results := newBlockResults()
+	results.set("build", BlockStatusSuccess)
+	results.set("lint", BlockStatusFailed)
+	results.set("docs", BlockStatusSkipped)
+
+	ctx := newWhenContext(env, results)
+	ctx.hostname = "web-01"
+	return ctx
+}
+
+func TestEvaluateWhen(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		{`os == "` + runtime.GOOS + `"`, true},
+		{`os != "` + runtime.GOOS + `"`, false},
+		{`arch == "` + runtime.GOARCH + `"`, true},
+		{`hostname =~ "^web-[0-9]+$"`, true},
+		{`hostname !~ "^db-"`, true},
+		{`env.STAGE == "prod"`, true},
+		{`$STAGE == 'prod' && ${STAGE} != "dev"`, true},
+		{`env.MISSING == ""`, true},
+		{`env.STAGE`, true},
+		{`env.EMPTY`, false},
+		{`defined(env.EMPTY)`, true},
+		{`defined("MISSING")`, false},
+		{`!defined(env.MISSING)`, true},
+		{`succeeded("build")`, true},
+		{`failed("lint") && skipped("docs")`, true},
+		{`result.build == "success"`, true},
+		{`result.unknown == ""`, true},
+		{`env.STAGE == "dev" || (os == "` + runtime.GOOS + `" && !failed("build"))`, true},
+		{`true`, true},
+		{`false || 0`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			got, err := evaluateWhen(tt.expression, newTestWhenContext())
+			if err != nil {
+				t.Fatalf("evaluateWhen() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("evaluateWhen() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWhenErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		wantErr    string
+	}{
+		{``, "empty when expression"},
+		{`os ==`, "unexpected end of expression"},
+		{`os == "linux`, "unterminated string"},
+		{`(os == "linux"`, "missing ')'"},
+		{`platform == "linux"`, `unknown identifier "platform"`},
+		{`os = "linux"`, `unexpected character '='`},
+		{`succeeded()`, "expects 1 argument(s), got 0"},
+		{`os == "linux" "extra"`, `unexpected "extra"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			_, err := parseWhen(tt.expression)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseWhen() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWhenBlockReferences(t *testing.T) {
+	expr, err := parseWhen(`succeeded("build") && result.test == "success" || defined(env.FOO)`)
+	if err != nil {
+		t.Fatalf("parseWhen() unexpected error: %v", err)
+	}
+
+	got := strings.Join(whenBlockReferences(expr), ",")
+	if got != "build,test" {
+		t.Errorf("whenBlockReferences() = %s, want build,test", got)
+	}
+}
+
+func TestValidateCommandRejectsInvalidWhen(t *testing.T) {
+	cmd := &Command{
+		Type: CommandTypeShell,
+		When: `os == `,
+	}
+
+	if err := validateCommand(cmd); err == nil {
+		t.Error("validateCommand() expected error for invalid when expression")
+	}
+}
+
+func TestRunfromyamlWhenSkipsBlock(t *testing.T) {
+	yamlData := `
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: exec
+    name: never
+    when: os == "plan9-does-not-exist"
+    values:
+      - command-that-does-not-exist-12345
+  - type: exec
+    name: after
+    when: skipped("never")
+    values:
+      - go version
+`
+
+	if err := Runfromyaml([]byte(yamlData), false); err != nil {
+		t.Errorf("Runfromyaml() unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package cli
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+// Ways to split the values of exec, docker, docker-compose and ssh blocks
+// into commands and arguments
+const (
+	// splitShell honours quotes and backslashes like a POSIX shell and only
+	// separates commands at unquoted semicolons
+	splitShell = "shell"
+	// splitFields is the behaviour of earlier versions: commands are
+	// separated at every semicolon and arguments at every whitespace
+	splitFields = "fields"
+)
+
+// validateSplit checks the split option of a block
+func validateSplit(split string) error {
+	switch split {
+	case "", splitShell, splitFields:
+		return nil
+	}
+	return fmt.Errorf("invalid split %q: must be %q or %q", split, splitShell, splitFields)
+}
+
+// splitCommandLine splits a command line into commands and their words like a
+// POSIX shell. Single quotes keep everything literally, within double quotes
+// a backslash only escapes $, `, ", \ and newlines, and outside of quotes it
+// escapes any character. Unquoted semicolons separate commands.
+//
+// Variables outside of single quotes are replaced by expand while splitting,
+// unless it is nil. Their values are never parsed again, so quotes and
+// semicolons in them are kept literally. Values outside of double quotes are
+// split at whitespace into several words.
+func splitCommandLine(line string, expand func(string) string) ([][]string, error) {
+	var (
+		commands [][]string
+		words    []string
+		word     strings.Builder
+		inWord   bool
+		escaped  bool
+		quote    rune
+	)
+
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if len(words) > 0 {
+			commands = append(commands, words)
+			words = nil
+		}
+	}
+
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		if r == '$' && !escaped && quote != '\'' && expand != nil {
+			if ref := variableRef(line[i:]); ref != "" {
+				value := expand(ref)
+				if quote == '"' {
+					word.WriteString(value)
+				} else {
+					for _, v := range value {
+						if unicode.IsSpace(v) {
+							endWord()
+						} else {
+							word.WriteRune(v)
+							inWord = true
+						}
+					}
+				}
+				i += len(ref)
+				continue
+			}
+		}
+		i += size
+
+		switch {
+		case escaped:
+			escaped = false
+			if quote == '"' && !strings.ContainsRune("$`\"\\\n", r) {
+				word.WriteRune('\\')
+			}
+			// An escaped newline continues the line
+			if r != '\n' {
+				word.WriteRune(r)
+				inWord = true
+			}
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ';':
+			endCommand()
+		case unicode.IsSpace(r):
+			endWord()
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, line)
+	}
+	if escaped {
+		word.WriteRune('\\')
+		inWord = true
+	}
+	endCommand()
+
+	return commands, nil
+}
+
+// variableRef returns the variable reference s starts with, like $NAME,
+// ${NAME} or $1, in the forms os.Expand replaces. It is empty if the $ doesn't
+// start a reference.
+func variableRef(s string) string {
+	if len(s) < 2 {
+		return ""
+	}
+	switch c := s[1]; {
+	case c == '{':
+		if end := strings.IndexByte(s, '}'); end > 2 {
+			return s[:end+1]
+		}
+		return ""
+	case strings.IndexByte("*#$@!?-", c) >= 0 || ('0' <= c && c <= '9'):
+		return s[:2]
+	}
+	end := 1
+	for end < len(s) && (s[end] == '_' || 'a' <= s[end] && s[end] <= 'z' || 'A' <= s[end] && s[end] <= 'Z' || '0' <= s[end] && s[end] <= '9') {
+		end++
+	}
+	if end == 1 {
+		return ""
+	}
+	return s[:end]
+}
+
+// splitFieldsCommandLine splits the values like earlier versions did
+func splitFieldsCommandLine(values []string, expand func(string) string) [][]string {
+	var commands [][]string
+	for _, cmdStr := range splitCommands(values) {
+		if cmdArgs := strings.Fields(expand(strings.TrimSpace(cmdStr))); len(cmdArgs) > 0 {
+			commands = append(commands, cmdArgs)
+		}
+	}
+	return commands
+}
+
+// commandLines returns the commands of a block as lists of arguments, taken
+// from its argv or split from its values. Environment variables are expanded
+// within every argument of argv and while values are split, so their content
+// can't add quotes or commands. With split: fields they are expanded before
+// values are split, like earlier versions did.
+func (e *CommandExecutor) commandLines(cmd *Command) ([][]string, error) {
+	expand := func(s string) string { return e.expandEnv(cmd, s) }
+
+	if len(cmd.Argv) > 0 {
+		commands := make([][]string, len(cmd.Argv))
+		for i, argv := range cmd.Argv {
+			commands[i] = make([]string, len(argv))
+			for j, arg := range argv {
+				commands[i][j] = expand(arg)
+			}
+		}
+		return commands, nil
+	}
+
+	if cmd.Split == splitFields {
+		return splitFieldsCommandLine(cmd.Values, expand), nil
+	}
+
+	commands, err := splitCommandLine(strings.Join(cmd.Values, " "), expand)
+	if err != nil {
+		return nil, err
+	}
+
+	// Blocks written for the earlier splitting are pointed to the opt-in
+	if cmd.Split == "" {
+		if legacy := splitFieldsCommandLine(cmd.Values, expand); !reflect.DeepEqual(commands, legacy) {
+			e.print(color.FgYellow, fmt.Sprintf("# warning: values of %s block are split like a POSIX shell now: %s instead of %s - set split: fields to keep the previous behaviour",
+				cmd.Type, formatCommandLines(commands), formatCommandLines(legacy)))
+		}
+	}
+
+	return commands, nil
+}
+
+// formatCommandLines returns commands in shell syntax for messages
+func formatCommandLines(commands [][]string) string {
+	formatted := make([]string, len(commands))
+	for i, argv := range commands {
+		formatted[i] = formatArgv(argv)
+	}
+	return strings.Join(formatted, "; ")
+}
+
+// parseArgv converts the argv option of a block. A list of strings is a
+// single command, a list of lists holds one command per entry.
+func parseArgv(value interface{}) ([][]string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("argv must be a list of arguments or a list of commands")
+	}
+
+	var commands [][]string
+	var argv []string
+	for _, item := range items {
+		switch v := item.(type) {
+		case []interface{}:
+			if len(argv) > 0 {
+				return nil, fmt.Errorf("argv mixes arguments and commands")
+			}
+			if len(v) == 0 {
+				return nil, fmt.Errorf("argv contains an empty command")
+			}
+			commands = append(commands, stringList(v))
+		case map[interface{}]interface{}:
+			return nil, fmt.Errorf("argv entries must be strings or lists of strings")
+		case nil:
+			if len(commands) > 0 {
+				return nil, fmt.Errorf("argv mixes arguments and commands")
+			}
+			argv = append(argv, "")
+		default:
+			if len(commands) > 0 {
+				return nil, fmt.Errorf("argv mixes arguments and commands")
+			}
+			argv = append(argv, fmt.Sprint(v))
+		}
+	}
+	if len(argv) > 0 {
+		commands = append(commands, argv)
+	}
+	return commands, nil
+}
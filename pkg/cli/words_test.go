@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    [][]string
+		wantErr bool
+	}{
+		{"plain words", "ls  -la\t/tmp", [][]string{{"ls", "-la", "/tmp"}}, false},
+		{"commands", "echo a; echo b;", [][]string{{"echo", "a"}, {"echo", "b"}}, false},
+		{"double quotes", `echo "a; b"`, [][]string{{"echo", "a; b"}}, false},
+		{"single quotes", `echo 'a "b" \c'`, [][]string{{"echo", `a "b" \c`}}, false},
+		{"escapes", `echo a\ b \"c\" d\;e`, [][]string{{"echo", "a b", `"c"`, "d;e"}}, false},
+		{"escapes in double quotes", `echo "\$HOME \a \\"`, [][]string{{"echo", `$HOME \a \`}}, false},
+		{"adjacent quotes", `echo pre"mid"'end'`, [][]string{{"echo", "premidend"}}, false},
+		{"empty argument", `printf '' x`, [][]string{{"printf", "", "x"}}, false},
+		{"line continuation", "echo a \\\n b", [][]string{{"echo", "a", "b"}}, false},
+		{"empty", " ; ", nil, false},
+		{"unterminated double quote", `echo "a`, nil, true},
+		{"unterminated single quote", `echo 'a`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitCommandLine(tt.line, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitCommandLine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitCommandLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitCommandLineExpand(t *testing.T) {
+	vars := map[string]string{
+		"MSG":   `say "hi"; rm -rf x`,
+		"QUOTE": "it's",
+		"FLAGS": " -a  -b ",
+		"EMPTY": "",
+	}
+	expand := func(s string) string { return os.Expand(s, func(key string) string { return vars[key] }) }
+
+	tests := []struct {
+		name string
+		line string
+		want [][]string
+	}{
+		{"quotes and semicolon in value", `echo "$MSG"`, [][]string{{"echo", `say "hi"; rm -rf x`}}},
+		{"unquoted value", `echo $MSG`, [][]string{{"echo", "say", `"hi";`, "rm", "-rf", "x"}}},
+		{"apostrophe in value", `echo $QUOTE ${QUOTE}s`, [][]string{{"echo", "it's", "it'ss"}}},
+		{"split at whitespace", `ls $FLAGS /tmp`, [][]string{{"ls", "-a", "-b", "/tmp"}}},
+		{"empty values", `echo $EMPTY "$EMPTY" a`, [][]string{{"echo", "", "a"}}},
+		{"single quotes", `echo '$MSG'`, [][]string{{"echo", "$MSG"}}},
+		{"escaped", `echo \$MSG "\${MSG}"`, [][]string{{"echo", "$MSG", "${MSG}"}}},
+		{"no variable", `echo $ a$ ${}`, [][]string{{"echo", "$", "a$", "${}"}}},
+		{"commands", `echo $QUOTE; echo b`, [][]string{{"echo", "it's"}, {"echo", "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitCommandLine(tt.line, expand)
+			if err != nil {
+				t.Fatalf("splitCommandLine() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitCommandLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseArgv(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    [][]string
+		wantErr bool
+	}{
+		{"single command", `argv: [echo, "a; b", 1]`, [][]string{{"echo", "a; b", "1"}}, false},
+		{"several commands", "argv:\n  - [echo, a]\n  - [echo, b c]", [][]string{{"echo", "a"}, {"echo", "b c"}}, false},
+		{"missing", `other: 1`, nil, false},
+		{"scalar", `argv: echo`, nil, true},
+		{"mixed", "argv:\n  - echo\n  - [a]", nil, true},
+		{"empty command", "argv:\n  - []", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var block map[interface{}]interface{}
+			if err := yaml.Unmarshal([]byte(tt.yaml), &block); err != nil {
+				t.Fatalf("Failed to parse test YAML: %v", err)
+			}
+			got, err := parseArgv(block["argv"])
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseArgv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseArgv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateArgvRequiredFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  string
+	}{
+		{"docker without container", "type: docker\n    command: exec\n    argv: [ls]", "requires 'container' field"},
+		{"docker without command", "type: docker\n    container: app\n    argv: [ls]", "requires 'command' field"},
+		{"ssh without user", "type: ssh\n    host: example.com\n    argv: [uptime]", "requires 'user' field"},
+		{"ssh without host", "type: ssh\n    user: admin\n    argv: [uptime]", "requires 'host' field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateWorkflow([]byte("cmd:\n  - "+tt.block+"\n"), RunOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ValidateWorkflow() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandLinesSplit(t *testing.T) {
+	executor := NewCommandExecutor(CommandConfig{Env: NewEnvironment(), Level: "info", Output: "file"})
+
+	tests := []struct {
+		name string
+		cmd  *Command
+		want [][]string
+	}{
+		{"shell", &Command{Type: CommandTypeExec, Values: []string{`echo "a; b"`}}, [][]string{{"echo", "a; b"}}},
+		{"fields", &Command{Type: CommandTypeExec, Split: splitFields, Values: []string{`echo "a; b"`}}, [][]string{{"echo", `"a`}, {"b\""}}},
+		{"argv", &Command{Type: CommandTypeExec, Argv: [][]string{{"echo", "$RFY_WORDS_TEST x"}}}, [][]string{{"echo", "words x"}}},
+	}
+
+	t.Setenv("RFY_WORDS_TEST", "words")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := executor.commandLines(tt.cmd)
+			if err != nil {
+				t.Fatalf("commandLines() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("commandLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunfromyamlWithOptionsExecQuoting(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	dir := t.TempDir()
+	workflow := func(extra string) []byte {
+		return []byte(fmt.Sprintf(`
+logging:
+  - level: info
+  - output: file
+cmd:
+  - type: exec
+    name: quoted
+    register: QUOTED
+    values:
+      - printf '%%s|' "a; b" 'c  d'
+  - type: exec
+    name: argv
+    argv: [touch, "%[1]s/argv file"]
+  - type: exec
+    name: data
+    env:
+      MSG: it's "quoted"; touch %[1]s/injected
+    register: DATA
+    values:
+      - printf '%%s|' "$MSG" $MSG
+  - type: shell
+    values:
+      - echo "$QUOTED" > %[1]s/out; echo "$DATA" > %[1]s/data
+%[2]s`, dir, extra))
+	}
+
+	err := RunfromyamlWithOptions(context.Background(), workflow(`
+  - type: exec
+    values:
+      - echo "unterminated
+`), RunOptions{Parallel: 1})
+	if err == nil {
+		t.Fatal("RunfromyamlWithOptions() expected validation error for unterminated quote")
+	}
+
+	if err := RunfromyamlWithOptions(context.Background(), workflow(""), RunOptions{Parallel: 1}); err != nil {
+		t.Fatalf("RunfromyamlWithOptions() unexpected error: %v", err)
+	}
+	out, _ := os.ReadFile(filepath.Join(dir, "out"))
+	if want := "a; b|c  d|\n"; string(out) != want {
+		t.Errorf("registered output = %q, want %q", out, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "argv file")); err != nil {
+		t.Errorf("argv block didn't create the file: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "data"))
+	if want := `it's "quoted"; touch ` + dir + `/injected|it's|"quoted";|touch|` + dir + "/injected|\n"; string(data) != want {
+		t.Errorf("registered output = %q, want %q", data, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "injected")); err == nil {
+		t.Error("a semicolon in a variable started a command")
+	}
+}
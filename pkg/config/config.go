@@ -2,6 +2,7 @@ package config
 
 import (
 	"flag"
+	"time"
 )
 
 // Config holds all configuration for the application
@@ -14,6 +15,8 @@ type Config struct {
 	AI         bool
 	Shell      bool
 	MCP        bool
+	DryRun     bool
+	Resume     bool
 	File       string
 	Host       string
 	User       string
@@ -24,7 +27,15 @@ type Config struct {
 	ShellType  string
 	MCPName    string
 	MCPVersion string
+	Only       string
+	Skip       string
+	From       string
+	Until      string
+	PluginDir  string
+	StateFile  string
 	Port       int
+	Parallel   int
+	Timeout    time.Duration
 }
 
 // New creates a new Config instance with default values
@@ -38,6 +49,8 @@ func New() *Config {
 		AI:         false,
 		Shell:      false,
 		MCP:        false,
+		DryRun:     false,
+		Resume:     false,
 		File:       "commands.yaml",
 		Host:       "localhost",
 		User:       "rest",
@@ -49,6 +62,7 @@ func New() *Config {
 		MCPName:    "runfromyaml-workflow-server",
 		MCPVersion: "1.0.0",
 		Port:       8080,
+		Parallel:   1,
 	}
 }
 
@@ -62,6 +76,8 @@ func (c *Config) ParseFlags() error {
 	flag.BoolVar(&c.AI, "ai", c.AI, "ai - interact with OpenAI")
 	flag.BoolVar(&c.Shell, "shell", c.Shell, "shell - interactive shell")
 	flag.BoolVar(&c.MCP, "mcp", c.MCP, "mcp - start MCP (Model Context Protocol) server mode")
+	flag.BoolVar(&c.DryRun, "dry-run", c.DryRun, "dry-run - print the commands and file changes of the workflow without executing them")
+	flag.BoolVar(&c.Resume, "resume", c.Resume, "resume - record the outcome of the command blocks and skip those that already succeeded in the last failed run of this file")
 
 	flag.StringVar(&c.File, "file", c.File, "file - file with all defined commands, descriptions and configuration blocks in yaml fromat")
 	flag.StringVar(&c.Host, "host", c.Host, "host - set host for rest api mode (default host is localhost)")
@@ -73,8 +89,17 @@ func (c *Config) ParseFlags() error {
 	flag.StringVar(&c.ShellType, "shell-type", c.ShellType, "shell-type - which shell type should be used for recording all the commands to generate yaml structure")
 	flag.StringVar(&c.MCPName, "mcp-name", c.MCPName, "mcp-name - set MCP server name")
 	flag.StringVar(&c.MCPVersion, "mcp-version", c.MCPVersion, "mcp-version - set MCP server version")
+	flag.StringVar(&c.Only, "only", c.Only, "only - comma separated names, tags or indexes of the only command blocks to run")
+	flag.StringVar(&c.Skip, "skip", c.Skip, "skip - comma separated names, tags or indexes of command blocks to leave out")
+	flag.StringVar(&c.From, "from", c.From, "from - name or index of the command block to start the run with")
+	flag.StringVar(&c.Until, "until", c.Until, "until - name or index of the last command block to run")
+	flag.StringVar(&c.PluginDir, "plugin-dir", c.PluginDir, "plugin-dir - directory searched for runfromyaml-<type> plugins before PATH")
+	flag.StringVar(&c.StateFile, "state-file", c.StateFile, "state-file - record the outcome of the command blocks in this file (default with -resume is the workflow file with .state.json appended)")
 
 	flag.IntVar(&c.Port, "port", c.Port, "port - set http port for rest api mode (default http port is 8080)")
+	flag.IntVar(&c.Parallel, "parallel", c.Parallel, "parallel - maximum number of command blocks executed concurrently (default is 1)")
+
+	flag.DurationVar(&c.Timeout, "timeout", c.Timeout, "timeout - abort the whole workflow after this duration, e.g. 30m (overrides the timeout defined in the yaml file)")
 
 	flag.Parse()
 
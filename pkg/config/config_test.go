@@ -45,6 +45,10 @@ func TestNew(t *testing.T) {
 	if cfg.ShellType != "bash" {
 		t.Errorf("Expected ShellType to be 'bash', got %s", cfg.ShellType)
 	}
+
+	if cfg.Parallel != 1 {
+		t.Errorf("Expected Parallel to be 1, got %d", cfg.Parallel)
+	}
 }
 
 func TestParseFlags(t *testing.T) {
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -23,7 +24,7 @@ func (c *Config) LoadFromYAML(data []byte) error {
 
 	for _, opt := range yamlOpts.Options {
 		switch opt.Key {
-		case "debug", "rest", "no-auth", "restout", "no-file", "ai":
+		case "debug", "rest", "no-auth", "restout", "no-file", "ai", "dry-run", "resume":
 			if val, ok := opt.Value.(bool); ok {
 				switch opt.Key {
 				case "debug":
@@ -38,9 +39,13 @@ func (c *Config) LoadFromYAML(data []byte) error {
 					c.NoFile = val
 				case "ai":
 					c.AI = val
+				case "dry-run":
+					c.DryRun = val
+				case "resume":
+					c.Resume = val
 				}
 			}
-		case "file", "host", "user", "ai-key", "ai-model", "ai-cmdtype", "shell-type":
+		case "file", "host", "user", "ai-key", "ai-model", "ai-cmdtype", "shell-type", "only", "skip", "from", "until", "plugin-dir", "state-file":
 			if val, ok := opt.Value.(string); ok {
 				switch opt.Key {
 				case "file":
@@ -57,12 +62,34 @@ func (c *Config) LoadFromYAML(data []byte) error {
 					c.AICmdType = val
 				case "shell-type":
 					c.ShellType = val
+				case "only":
+					c.Only = val
+				case "skip":
+					c.Skip = val
+				case "from":
+					c.From = val
+				case "until":
+					c.Until = val
+				case "plugin-dir":
+					c.PluginDir = val
+				case "state-file":
+					c.StateFile = val
 				}
 			}
 		case "port":
 			if val, ok := opt.Value.(int); ok {
 				c.Port = val
 			}
+		case "parallel":
+			if val, ok := opt.Value.(int); ok {
+				c.Parallel = val
+			}
+		case "timeout":
+			if val, ok := opt.Value.(string); ok {
+				if d, err := time.ParseDuration(val); err == nil {
+					c.Timeout = d
+				}
+			}
 		}
 	}
 
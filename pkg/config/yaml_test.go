@@ -67,6 +67,87 @@ options:
 			},
 			wantErr: false,
 		},
+		{
+			name: "parallel option",
+			yamlData: `
+options:
+  - key: "parallel"
+    value: 4
+`,
+			expected: Config{
+				Parallel: 4,
+			},
+			wantErr: false,
+		},
+		{
+			name: "dry-run option",
+			yamlData: `
+options:
+  - key: "dry-run"
+    value: true
+`,
+			expected: Config{
+				DryRun: true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "resume option",
+			yamlData: `
+options:
+  - key: "resume"
+    value: true
+`,
+			expected: Config{
+				Resume: true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "selection options",
+			yamlData: `
+options:
+  - key: "only"
+    value: "build,test"
+  - key: "skip"
+    value: "slow"
+  - key: "from"
+    value: "build"
+  - key: "until"
+    value: "3"
+`,
+			expected: Config{
+				Only:  "build,test",
+				Skip:  "slow",
+				From:  "build",
+				Until: "3",
+			},
+			wantErr: false,
+		},
+		{
+			name: "plugin-dir option",
+			yamlData: `
+options:
+  - key: "plugin-dir"
+    value: "./plugins"
+`,
+			expected: Config{
+				PluginDir: "./plugins",
+			},
+			wantErr: false,
+		},
+		{
+			name: "state-file option",
+			yamlData: `
+options:
+  - key: "state-file"
+    value: "/tmp/run.state.json"
+`,
+			expected: Config{
+				StateFile: "/tmp/run.state.json",
+			},
+			wantErr: false,
+		},
 		{
 			name: "mixed options",
 			yamlData: `
@@ -216,6 +297,25 @@ options:
 				if cfg.Port != tt.expected.Port {
 					t.Errorf("Port = %v, want %v", cfg.Port, tt.expected.Port)
 				}
+				if cfg.Parallel != tt.expected.Parallel {
+					t.Errorf("Parallel = %v, want %v", cfg.Parallel, tt.expected.Parallel)
+				}
+				if cfg.DryRun != tt.expected.DryRun {
+					t.Errorf("DryRun = %v, want %v", cfg.DryRun, tt.expected.DryRun)
+				}
+				if cfg.Resume != tt.expected.Resume {
+					t.Errorf("Resume = %v, want %v", cfg.Resume, tt.expected.Resume)
+				}
+				if cfg.Only != tt.expected.Only || cfg.Skip != tt.expected.Skip || cfg.From != tt.expected.From || cfg.Until != tt.expected.Until {
+					t.Errorf("selection = %q %q %q %q, want %q %q %q %q", cfg.Only, cfg.Skip, cfg.From, cfg.Until,
+						tt.expected.Only, tt.expected.Skip, tt.expected.From, tt.expected.Until)
+				}
+				if cfg.PluginDir != tt.expected.PluginDir {
+					t.Errorf("PluginDir = %v, want %v", cfg.PluginDir, tt.expected.PluginDir)
+				}
+				if cfg.StateFile != tt.expected.StateFile {
+					t.Errorf("StateFile = %v, want %v", cfg.StateFile, tt.expected.StateFile)
+				}
 			}
 		})
 	}
@@ -81,6 +81,13 @@ func (e *RunFromYAMLError) WithSuggestion(suggestion string) *RunFromYAMLError {
 	return e
 }
 
+// MessageWithSuggestions returns the message followed by the suggestions,
+// one per line
+func (e *RunFromYAMLError) MessageWithSuggestions() string {
+	lines := append([]string{e.Message}, e.Suggestions...)
+	return strings.Join(lines, "\n")
+}
+
 // New creates a new RunFromYAMLError
 func New(errorType ErrorType, message string) *RunFromYAMLError {
 	return &RunFromYAMLError{
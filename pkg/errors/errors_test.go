@@ -92,6 +92,16 @@ func TestRunFromYAMLError_WithSuggestion(t *testing.T) {
 	}
 }
 
+func TestRunFromYAMLError_MessageWithSuggestions(t *testing.T) {
+	err := New(ErrorTypeValidation, "unknown block").
+		WithSuggestion("Did you mean \"build\"?")
+
+	want := "unknown block\nDid you mean \"build\"?"
+	if got := err.MessageWithSuggestions(); got != want {
+		t.Errorf("MessageWithSuggestions() = %q, want %q", got, want)
+	}
+}
+
 func TestRunFromYAMLError_Is(t *testing.T) {
 	err1 := New(ErrorTypeConfig, "config error")
 	err2 := New(ErrorTypeConfig, "another config error")
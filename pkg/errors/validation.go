@@ -91,14 +91,24 @@ func (v *Validator) ValidateFilePermissions(fieldName string, perm os.FileMode)
 	}
 }
 
-// ValidateCommandType checks if command type is valid
+// CommandTypes hooks the validator into the runner registry of the cli
+// package, which imports this one. It reports whether blocks of cmdType can
+// be run and lists the registered types.
+var CommandTypes func(cmdType string) (bool, []string)
+
+// ValidateCommandType checks if command type is valid. Every type is
+// accepted when the cli package isn't part of the program.
+//
+// Deprecated: use cli.LookupRunner, which knows about plugins and the
+// runners added with cli.RegisterRunner.
 func (v *Validator) ValidateCommandType(cmdType string) {
-	validTypes := []string{"exec", "shell", "conf", "docker", "docker-compose", "ssh"}
+	if CommandTypes == nil {
+		return
+	}
 
-	for _, validType := range validTypes {
-		if cmdType == validType {
-			return
-		}
+	valid, validTypes := CommandTypes(cmdType)
+	if valid {
+		return
 	}
 
 	v.AddError(NewValidationError(
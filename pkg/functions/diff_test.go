@@ -0,0 +1,76 @@
+package functions
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		want    string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{
+			"new file",
+			"",
+			"a\nb\n",
+			"--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			"changed line",
+			"a\nb\nc\n",
+			"a\nB\nc\n",
+			"--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			"separate hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			"removed lines",
+			"a\nb\nc\n",
+			"a\n",
+			"--- old\n+++ new\n@@ -1,3 +1,1 @@\n a\n-b\n-c\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnifiedDiff("old", "new", tt.oldText, tt.newText); got != tt.want {
+				t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffLargeFiles(t *testing.T) {
+	lines := func(n int, prefix string) []string {
+		result := make([]string, n)
+		for i := range result {
+			result[i] = prefix + strconv.Itoa(i)
+		}
+		return result
+	}
+
+	// A single change in a large file only compares the changed line
+	oldLines := lines(100000, "line ")
+	newLines := append([]string{}, oldLines...)
+	newLines[50000] = "changed"
+	got := UnifiedDiff("old", "new", strings.Join(oldLines, "\n")+"\n", strings.Join(newLines, "\n")+"\n")
+	if !strings.Contains(got, "@@ -49998,7 +49998,7 @@\n") || !strings.Contains(got, "-line 50000\n+changed\n") {
+		t.Errorf("UnifiedDiff() = %q, want the changed line", got)
+	}
+
+	// Rewritten files too large to compare are replaced as a whole
+	oldLines, newLines = lines(3000, "old "), lines(3000, "new ")
+	got = UnifiedDiff("old", "new", strings.Join(oldLines, "\n")+"\n", strings.Join(newLines, "\n")+"\n")
+	if !strings.HasPrefix(got, "--- old\n+++ new\n@@ -1,3000 +1,3000 @@\n-old 0\n") || strings.Count(got, "\n+new ") != 3000 {
+		t.Errorf("UnifiedDiff() = %.200q..., want all lines replaced", got)
+	}
+}
@@ -3,10 +3,10 @@ package mcp
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 
-	"gopkg.in/yaml.v2"
-
+	"github.com/lanixx/runfromyaml/pkg/cli"
 	"github.com/lanixx/runfromyaml/pkg/openai"
 )
 
@@ -36,7 +36,7 @@ func NewAIWorkflowGenerator(apiKey, model string) *AIWorkflowGenerator {
 }
 
 // GenerateWorkflowFromDescription generates a complete workflow using AI
-func (g *AIWorkflowGenerator) GenerateWorkflowFromDescription(description string) (map[string]interface{}, error) {
+func (g *AIWorkflowGenerator) GenerateWorkflowFromDescription(description string) (*cli.Workflow, error) {
 	if !g.enabled {
 		// Fallback to pattern-matching if AI is not available
 		return g.generateFallbackWorkflow(description), nil
@@ -97,12 +97,7 @@ WORKFLOW STRUCTURE REQUIREMENTS:
 - Add verification steps to confirm successful execution
 
 AVAILABLE BLOCK TYPES:
-- shell: Execute shell commands (use for most operations)
-- exec: Execute system commands directly
-- docker: Run Docker containers (command: run/exec, container: image_name)
-- docker-compose: Docker Compose operations (dcoptions, command, cmdoptions, service)
-- ssh: Remote SSH commands (user, host, port, options)
-- conf: Create configuration files (confdest, confperm, confdata)
+%s
 
 YAML STRUCTURE TEMPLATE:
 ` + "```yaml" + `
@@ -120,7 +115,7 @@ env:
 
 cmd:
   # PRESERVED FROM DESCRIPTION: [exact command mentioned]
-  - type: shell|exec|docker|docker-compose|ssh|conf
+  - type: %s
     name: descriptive-name
     desc: "PRESERVED: exact description or GENERATED: inferred purpose"
     expandenv: true
@@ -215,11 +210,40 @@ IMPORTANT:
 
 Generate the workflow:`
 
-	return fmt.Sprintf(prompt, description)
+	return fmt.Sprintf(prompt, description, availableBlockTypes(), strings.Join(blockTypeNames(), "|"))
+}
+
+// blockTypeNames returns the names of the registered block types
+func blockTypeNames() []string {
+	var names []string
+	for _, t := range cli.RunnerTypes() {
+		names = append(names, string(t))
+	}
+	return names
+}
+
+// availableBlockTypes describes every registered block type with the keys
+// of its schema, one per line
+func availableBlockTypes() string {
+	var lines []string
+	for _, t := range cli.RunnerTypes() {
+		runner, _ := cli.LookupRunner(t)
+		line := fmt.Sprintf("- %s: %s", t, runner.Explain())
+		var keys []string
+		for key := range runner.Schema() {
+			keys = append(keys, key)
+		}
+		if len(keys) > 0 {
+			sort.Strings(keys)
+			line += " (" + strings.Join(keys, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
 }
 
 // ImproveWorkflow takes an existing workflow and additional requirements to enhance it
-func (g *AIWorkflowGenerator) ImproveWorkflow(existingYAML string, additionalRequirements string) (map[string]interface{}, error) {
+func (g *AIWorkflowGenerator) ImproveWorkflow(existingYAML string, additionalRequirements string) (*cli.Workflow, error) {
 	if !g.enabled {
 		return nil, fmt.Errorf("AI workflow improvement is not available - OpenAI API key not configured")
 	}
@@ -304,14 +328,13 @@ Generate the improved workflow:`
 	return fmt.Sprintf(prompt, additionalRequirements, existingYAML)
 }
 
-// parseAIResponse parses the AI response and extracts YAML
-func (g *AIWorkflowGenerator) parseAIResponse(response string) (map[string]interface{}, error) {
+// parseAIResponse parses the AI response and extracts YAML. The workflow is
+// decoded strictly, so responses with unknown keys are rejected.
+func (g *AIWorkflowGenerator) parseAIResponse(response string) (*cli.Workflow, error) {
 	// Clean the response - remove markdown code blocks if present
 	yamlContent := g.extractYAMLFromResponse(response)
 
-	// Parse YAML
-	var workflow map[string]interface{}
-	err := yaml.Unmarshal([]byte(yamlContent), &workflow)
+	workflow, err := cli.ParseWorkflow([]byte(yamlContent), "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse AI-generated YAML: %w", err)
 	}
@@ -339,97 +362,52 @@ func (g *AIWorkflowGenerator) extractYAMLFromResponse(response string) string {
 }
 
 // validateAndEnhanceWorkflow validates and enhances the AI-generated workflow
-func (g *AIWorkflowGenerator) validateAndEnhanceWorkflow(workflow map[string]interface{}, originalDescription string) (map[string]interface{}, error) {
+func (g *AIWorkflowGenerator) validateAndEnhanceWorkflow(workflow *cli.Workflow, originalDescription string) (*cli.Workflow, error) {
 	// Ensure logging section exists with enhanced configuration
-	if _, exists := workflow["logging"]; !exists {
-		workflow["logging"] = []map[string]interface{}{
-			{"level": "info"},
-			{"output": "stdout"},
-		}
+	if len(workflow.Logging) == 0 {
+		workflow.Logging = defaultLogging()
 	}
 
-	// Add metadata section for better tracking
-	if _, exists := workflow["metadata"]; !exists {
-		workflow["metadata"] = map[string]interface{}{
-			"generated_by":       "runfromyaml-ai-generator",
-			"source_description": originalDescription,
-			"generation_notes":   "Review sections marked with TODO for customization",
-		}
+	// If no cmd section, create a basic one with clear guidance
+	if len(workflow.Cmd) == 0 {
+		workflow.Cmd = []cli.Block{{
+			Type:      "shell",
+			Name:      "generated-workflow",
+			Desc:      "GENERATED: AI-generated workflow based on: " + originalDescription,
+			ExpandEnv: true,
+			Values: cli.StringList{
+				"echo 'AI-generated workflow executed'",
+				"echo 'TODO: Add your specific commands here'",
+			},
+		}}
+		return workflow, nil
 	}
 
-	// Ensure cmd section exists and is valid
-	if cmdSection, exists := workflow["cmd"]; exists {
-		if cmdList, ok := cmdSection.([]interface{}); ok {
-			// Validate and enhance each command block
-			for i, cmd := range cmdList {
-				if cmdMap, ok := cmd.(map[interface{}]interface{}); ok {
-					// Convert interface{} keys to string keys for consistency
-					stringMap := make(map[string]interface{})
-					for k, v := range cmdMap {
-						if keyStr, ok := k.(string); ok {
-							stringMap[keyStr] = v
-						}
-					}
-
-					// Ensure required fields exist with enhanced descriptions
-					if _, exists := stringMap["type"]; !exists {
-						stringMap["type"] = "shell"
-					}
-					if _, exists := stringMap["name"]; !exists {
-						stringMap["name"] = fmt.Sprintf("step-%d", i+1)
-					}
-					if _, exists := stringMap["desc"]; !exists {
-						stringMap["desc"] = fmt.Sprintf("GENERATED: Step %d based on: %s", i+1, originalDescription)
-					}
-
-					// Add expandenv if not present (default to true for better variable support)
-					if _, exists := stringMap["expandenv"]; !exists {
-						stringMap["expandenv"] = true
-					}
-
-					// Enhance descriptions to indicate source
-					if desc, exists := stringMap["desc"]; exists {
-						if descStr, ok := desc.(string); ok {
-							if !strings.Contains(descStr, "PRESERVED") && !strings.Contains(descStr, "GENERATED") {
-								stringMap["desc"] = "GENERATED: " + descStr
-							}
-						}
-					}
-
-					// Validate values section
-					if values, exists := stringMap["values"]; exists {
-						if valuesList, ok := values.([]interface{}); ok {
-							// Ensure all values are strings and add comments where helpful
-							for j, value := range valuesList {
-								if valueStr, ok := value.(string); ok {
-									// Add inline comments for commands that might need customization
-									if g.needsCustomization(valueStr) {
-										valuesList[j] = valueStr + "  # TODO: Customize for your environment"
-									}
-								}
-							}
-							stringMap["values"] = valuesList
-						}
-					}
-
-					cmdList[i] = stringMap
-				}
-			}
-			workflow["cmd"] = cmdList
+	// Validate and enhance each command block
+	for i := range workflow.Cmd {
+		block := &workflow.Cmd[i]
+
+		// Ensure required fields exist with enhanced descriptions
+		if block.Type == "" {
+			block.Type = "shell"
 		}
-	} else {
-		// If no cmd section, create a basic one with clear guidance
-		workflow["cmd"] = []map[string]interface{}{
-			{
-				"type":      "shell",
-				"name":      "generated-workflow",
-				"desc":      "GENERATED: AI-generated workflow based on: " + originalDescription,
-				"expandenv": true,
-				"values": []string{
-					"echo 'AI-generated workflow executed'",
-					"echo 'TODO: Add your specific commands here'",
-				},
-			},
+		if block.Name == "" {
+			block.Name = fmt.Sprintf("step-%d", i+1)
+		}
+		if block.Desc == "" {
+			block.Desc = fmt.Sprintf("GENERATED: Step %d based on: %s", i+1, originalDescription)
+		}
+
+		// Enhance descriptions to indicate source
+		if !strings.Contains(block.Desc, "PRESERVED") && !strings.Contains(block.Desc, "GENERATED") {
+			block.Desc = "GENERATED: " + block.Desc
+		}
+
+		// Add inline comments for commands that might need customization
+		for j, value := range block.Values {
+			if g.needsCustomization(value) {
+				block.Values[j] = value + "  # TODO: Customize for your environment"
+			}
 		}
 	}
 
@@ -454,31 +432,19 @@ func (g *AIWorkflowGenerator) needsCustomization(command string) bool {
 }
 
 // generateFallbackWorkflow generates a workflow using pattern matching (fallback)
-func (g *AIWorkflowGenerator) generateFallbackWorkflow(description string) map[string]interface{} {
-	workflow := map[string]interface{}{
-		"logging": []map[string]interface{}{
-			{"level": "info"},
-			{"output": "stdout"},
-		},
-		"cmd": []map[string]interface{}{},
-	}
-
-	// Use the existing pattern-matching logic as fallback
-	blocks := g.analyzeAndGenerateBlocks(description)
-	workflow["cmd"] = blocks
-
-	// Add environment variables if needed
-	envVars := g.extractEnvironmentVariables(description)
-	if len(envVars) > 0 {
-		workflow["env"] = envVars
+func (g *AIWorkflowGenerator) generateFallbackWorkflow(description string) *cli.Workflow {
+	return &cli.Workflow{
+		Logging: defaultLogging(),
+		// Use the existing pattern-matching logic as fallback
+		Cmd: g.analyzeAndGenerateBlocks(description),
+		// Add environment variables if needed
+		Env: g.extractEnvironmentVariables(description),
 	}
-
-	return workflow
 }
 
 // analyzeAndGenerateBlocks - fallback pattern matching (copied from original)
-func (g *AIWorkflowGenerator) analyzeAndGenerateBlocks(description string) []map[string]interface{} {
-	var blocks []map[string]interface{}
+func (g *AIWorkflowGenerator) analyzeAndGenerateBlocks(description string) []cli.Block {
+	var blocks []cli.Block
 	desc := strings.ToLower(description)
 
 	// Docker-related workflows
@@ -509,81 +475,59 @@ func (g *AIWorkflowGenerator) analyzeAndGenerateBlocks(description string) []map
 }
 
 // Helper methods for fallback generation (simplified versions)
-func (g *AIWorkflowGenerator) generateDockerComposeBlock(description string) map[string]interface{} {
-	return map[string]interface{}{
-		"type":       "docker-compose",
-		"name":       "docker-compose-setup",
-		"desc":       "Docker Compose setup based on: " + description,
-		"expandenv":  true,
-		"dcoptions":  []string{"-f", "docker-compose.yml"},
-		"command":    "up",
-		"cmdoptions": []string{"-d"},
-		"service":    "",
-		"values":     []string{},
+func (g *AIWorkflowGenerator) generateDockerComposeBlock(description string) cli.Block {
+	return cli.Block{
+		Type:      "docker-compose",
+		Name:      "docker-compose-setup",
+		Desc:      "Docker Compose setup based on: " + description,
+		ExpandEnv: true,
+		Options: map[string]interface{}{
+			"dcoptions":  []string{"-f", "docker-compose.yml"},
+			"command":    "up",
+			"cmdoptions": []string{"-d"},
+		},
 	}
 }
 
-func (g *AIWorkflowGenerator) generateDockerBlock(description string) map[string]interface{} {
-	return map[string]interface{}{
-		"type":      "docker",
-		"name":      "docker-setup",
-		"desc":      "Docker setup based on: " + description,
-		"expandenv": true,
-		"command":   "run",
-		"container": "alpine:latest",
-		"values":    []string{"echo 'Docker container started'", "uname -a"},
+func (g *AIWorkflowGenerator) generateDockerBlock(description string) cli.Block {
+	return cli.Block{
+		Type:      "docker",
+		Name:      "docker-setup",
+		Desc:      "Docker setup based on: " + description,
+		ExpandEnv: true,
+		Values:    cli.StringList{"echo 'Docker container started'", "uname -a"},
+		Options: map[string]interface{}{
+			"command":   "run",
+			"container": "alpine:latest",
+		},
 	}
 }
 
-func (g *AIWorkflowGenerator) generateDatabaseSetupBlocks(description string) []map[string]interface{} {
-	return []map[string]interface{}{
-		{
-			"type":      "shell",
-			"name":      "database-setup",
-			"desc":      "Database setup commands",
-			"expandenv": true,
-			"values":    []string{"echo 'Setting up database'", "# Add your database setup commands here"},
-		},
+func (g *AIWorkflowGenerator) generateDatabaseSetupBlocks(description string) []cli.Block {
+	return []cli.Block{
+		shellBlock("database-setup", "Database setup commands", "echo 'Setting up database'", "# Add your database setup commands here"),
 	}
 }
 
-func (g *AIWorkflowGenerator) generateWebAppBlocks(description string) []map[string]interface{} {
-	return []map[string]interface{}{
-		{
-			"type":      "shell",
-			"name":      "web-app-setup",
-			"desc":      "Web application setup",
-			"expandenv": true,
-			"values":    []string{"echo 'Setting up web application'", "# Add your web app setup commands here"},
-		},
+func (g *AIWorkflowGenerator) generateWebAppBlocks(description string) []cli.Block {
+	return []cli.Block{
+		shellBlock("web-app-setup", "Web application setup", "echo 'Setting up web application'", "# Add your web app setup commands here"),
 	}
 }
 
-func (g *AIWorkflowGenerator) generateGenericShellBlock(description string) map[string]interface{} {
-	return map[string]interface{}{
-		"type":      "shell",
-		"name":      "generated-commands",
-		"desc":      "Generated commands based on: " + description,
-		"expandenv": true,
-		"values":    []string{"echo 'Executing generated workflow'", "# Add specific commands based on your requirements"},
-	}
+func (g *AIWorkflowGenerator) generateGenericShellBlock(description string) cli.Block {
+	return shellBlock("generated-commands", "Generated commands based on: "+description, "echo 'Executing generated workflow'", "# Add specific commands based on your requirements")
 }
 
-func (g *AIWorkflowGenerator) extractEnvironmentVariables(description string) []map[string]interface{} {
-	var envVars []map[string]interface{}
+func (g *AIWorkflowGenerator) extractEnvironmentVariables(description string) []cli.EnvEntry {
+	var envVars []cli.EnvEntry
 
 	if strings.Contains(strings.ToLower(description), "database") {
-		envVars = append(envVars, map[string]interface{}{
-			"key":   "DB_HOST",
-			"value": "localhost",
-		})
+		envVars = append(envVars, cli.EnvEntry{Key: "DB_HOST", Value: "localhost"})
 	}
 
 	if strings.Contains(strings.ToLower(description), "web") || strings.Contains(strings.ToLower(description), "app") {
-		envVars = append(envVars, map[string]interface{}{
-			"key":   "APP_PORT",
-			"value": "8080",
-		})
+		envVars = append(envVars, cli.EnvEntry{Key: "APP_PORT", Value: "8080"})
 	}
 
 	return envVars
@@ -2,6 +2,8 @@ package mcp
 
 import (
 	"encoding/json"
+
+	"github.com/lanixx/runfromyaml/pkg/cli"
 )
 
 // registerResources registers all available MCP resources
@@ -278,78 +280,9 @@ func (s *MCPServer) getWorkflowSchema() string {
 			"cmd": map[string]interface{}{
 				"type": "array",
 				"items": map[string]interface{}{
-					"type": "object",
-					"properties": map[string]interface{}{
-						"type": map[string]interface{}{
-							"type": "string",
-							"enum": []string{"exec", "shell", "docker", "docker-compose", "ssh", "conf"},
-						},
-						"name": map[string]interface{}{
-							"type": "string",
-						},
-						"desc": map[string]interface{}{
-							"type": "string",
-						},
-						"expandenv": map[string]interface{}{
-							"type": "boolean",
-						},
-						"values": map[string]interface{}{
-							"type": "array",
-							"items": map[string]interface{}{
-								"type": "string",
-							},
-						},
-						// Docker-specific properties
-						"command": map[string]interface{}{
-							"type": "string",
-						},
-						"container": map[string]interface{}{
-							"type": "string",
-						},
-						// Docker Compose-specific properties
-						"dcoptions": map[string]interface{}{
-							"type": "array",
-							"items": map[string]interface{}{
-								"type": "string",
-							},
-						},
-						"cmdoptions": map[string]interface{}{
-							"type": "array",
-							"items": map[string]interface{}{
-								"type": "string",
-							},
-						},
-						"service": map[string]interface{}{
-							"type": "string",
-						},
-						// SSH-specific properties
-						"user": map[string]interface{}{
-							"type": "string",
-						},
-						"host": map[string]interface{}{
-							"type": "string",
-						},
-						"port": map[string]interface{}{
-							"type": "integer",
-						},
-						"options": map[string]interface{}{
-							"type": "array",
-							"items": map[string]interface{}{
-								"type": "string",
-							},
-						},
-						// Config-specific properties
-						"confdest": map[string]interface{}{
-							"type": "string",
-						},
-						"confperm": map[string]interface{}{
-							"type": "integer",
-						},
-						"confdata": map[string]interface{}{
-							"type": "string",
-						},
-					},
-					"required": []string{"type"},
+					"type":       "object",
+					"properties": blockSchemaProperties(),
+					"required":   []string{"type"},
 				},
 			},
 		},
@@ -359,3 +292,41 @@ func (s *MCPServer) getWorkflowSchema() string {
 	jsonBytes, _ := json.MarshalIndent(schema, "", "  ")
 	return string(jsonBytes)
 }
+
+// blockSchemaProperties returns the schema of the keys of command blocks,
+// those specific to a type are contributed by its runner
+func blockSchemaProperties() map[string]interface{} {
+	var types []string
+	for _, t := range cli.RunnerTypes() {
+		types = append(types, string(t))
+	}
+
+	properties := map[string]interface{}{
+		"type": map[string]interface{}{
+			"type": "string",
+			"enum": types,
+		},
+		"name": map[string]interface{}{
+			"type": "string",
+		},
+		"desc": map[string]interface{}{
+			"type": "string",
+		},
+		"expandenv": map[string]interface{}{
+			"type": "boolean",
+		},
+		"values": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type": "string",
+			},
+		},
+	}
+	for _, t := range cli.RunnerTypes() {
+		runner, _ := cli.LookupRunner(t)
+		for key, schema := range runner.Schema() {
+			properties[key] = schema
+		}
+	}
+	return properties
+}
@@ -1,8 +1,15 @@
 package mcp
 
 import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
 	"testing"
 
+	"gopkg.in/yaml.v2"
+
+	"github.com/lanixx/runfromyaml/pkg/cli"
 	"github.com/lanixx/runfromyaml/pkg/config"
 )
 
@@ -199,14 +206,195 @@ func TestGenerateWorkflowFromDescription(t *testing.T) {
 
 			if !tc.expectError {
 				// Check basic workflow structure
-				if _, exists := workflow["cmd"]; !exists {
+				if len(workflow.Cmd) == 0 {
 					t.Error("Expected workflow to have cmd section")
 				}
 
-				if _, exists := workflow["logging"]; !exists {
+				if len(workflow.Logging) == 0 {
 					t.Error("Expected workflow to have logging section")
 				}
 			}
 		})
 	}
 }
+
+func TestReportContent(t *testing.T) {
+	if content := reportContent(nil); content != nil {
+		t.Errorf("reportContent(nil) = %v, want nil", content)
+	}
+
+	exitCode := 2
+	report := &cli.RunReport{
+		Status: cli.BlockStatusFailed,
+		Blocks: []cli.BlockResult{
+			{Section: "command block", Index: 0, Name: "build", Status: cli.BlockStatusFailed, ExitCode: &exitCode, Reason: "exit status 2", Stdout: "compiling"},
+			{Section: "command block", Index: 1, Name: "deploy", Status: cli.BlockStatusSkipped, Reason: "not run because of an earlier failure"},
+		},
+	}
+
+	content := reportContent(report)
+	if len(content) != 2 {
+		t.Fatalf("reportContent() returned %d items, want 2", len(content))
+	}
+	for _, want := range []string{"0 passed, 1 failed, 1 skipped", "command block 1 (build): failed, exit code 2 - exit status 2", "command block 2 (deploy): skipped"} {
+		if !strings.Contains(content[0].Text, want) {
+			t.Errorf("summary = %q, want it to contain %q", content[0].Text, want)
+		}
+	}
+	if !strings.Contains(content[1].Text, `"stdout": "compiling"`) {
+		t.Errorf("report = %q, want the captured output", content[1].Text)
+	}
+}
+
+func TestGeneratedWorkflowsDecode(t *testing.T) {
+	server := NewServer(&config.Config{MCPName: "test-server", MCPVersion: "1.0.0"})
+
+	workflows := make(map[string]*cli.Workflow)
+	for _, name := range []string{"web-app", "database-setup", "ci-cd", "docker-setup"} {
+		workflow, err := server.generateWorkflowFromTemplate(name, map[string]interface{}{})
+		if err != nil {
+			t.Fatalf("generateWorkflowFromTemplate(%q) unexpected error: %v", name, err)
+		}
+		workflows[name] = workflow
+	}
+	description := "configure a postgres database for a web app deployed with docker compose over ssh"
+	workflows["description"], _ = server.generateWorkflowFromDescription(description)
+	workflows["fallback"] = server.aiWorkflowGen.generateFallbackWorkflow(description)
+
+	for name, workflow := range workflows {
+		data, err := yaml.Marshal(workflow)
+		if err != nil {
+			t.Fatalf("%s: yaml.Marshal() unexpected error: %v", name, err)
+		}
+		if _, err := cli.ParseWorkflow(data, name); err != nil {
+			t.Errorf("%s: generated workflow doesn't decode: %v\n%s", name, err, data)
+		}
+	}
+}
+
+func TestHandleValidateWorkflow(t *testing.T) {
+	server := NewServer(&config.Config{MCPName: "test-server", MCPVersion: "1.0.0"})
+
+	tests := []struct {
+		name    string
+		yaml    string
+		want    string
+		isError bool
+	}{
+		{"valid", "cmd:\n  - type: shell\n    values: [ls]\n", "Workflow is valid", false},
+		{"typo", "cmd:\n  - type: shell\n    vaules: [ls]\n", `line 3, column 5: unknown key "vaules" in command block 1, did you mean "values"?`, true},
+		{"missing cmd", "logging:\n  - level: info\n", "Missing 'cmd' section", true},
+		{"runner", "cmd:\n  - type: docker\n    values: [ls]\n", "docker command with values or argv requires 'container' field", true},
+		{"dependency", "cmd:\n  - type: shell\n    name: build\n    needs: [setup]\n    values: [ls]\n", `needs unknown block "setup"`, true},
+		{"syntax", "cmd: [", "Invalid YAML syntax", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, _ := server.handleValidateWorkflow(map[string]interface{}{"yaml_content": tt.yaml})
+			if result.IsError != tt.isError || !strings.Contains(result.Content[0].Text, tt.want) {
+				t.Errorf("handleValidateWorkflow() = %+v, want %q", result, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkflowSchemaFromRunners(t *testing.T) {
+	server := NewServer(&config.Config{MCPName: "test-server", MCPVersion: "1.0.0"})
+
+	var schema struct {
+		Properties struct {
+			Cmd struct {
+				Items struct {
+					Properties map[string]map[string]interface{} `json:"properties"`
+				} `json:"items"`
+			} `json:"cmd"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal([]byte(server.getWorkflowSchema()), &schema); err != nil {
+		t.Fatalf("getWorkflowSchema() returned invalid JSON: %v", err)
+	}
+	properties := schema.Properties.Cmd.Items.Properties
+
+	enum, _ := properties["type"]["enum"].([]interface{})
+	for _, blockType := range cli.RunnerTypes() {
+		if !containsValue(enum, string(blockType)) {
+			t.Errorf("type enum %v is missing %s", enum, blockType)
+		}
+		runner, _ := cli.LookupRunner(blockType)
+		for key := range runner.Schema() {
+			if _, ok := properties[key]; !ok {
+				t.Errorf("schema is missing the %q key of %s blocks", key, blockType)
+			}
+		}
+	}
+
+	explanation := server.explainWorkflow(&cli.Workflow{Cmd: []cli.Block{{Type: "ssh", Name: "deploy"}}})
+	if !strings.Contains(explanation, "1. deploy (ssh)\n   - Execute commands on remote server via SSH\n") {
+		t.Errorf("explainWorkflow() = %q, want the explanation of the ssh runner", explanation)
+	}
+}
+
+func TestCreateWorkflowPromptBlockTypes(t *testing.T) {
+	prompt := (&AIWorkflowGenerator{}).createWorkflowPrompt("deploy the app")
+	if strings.Contains(prompt, "%!") {
+		t.Errorf("createWorkflowPrompt() has formatting errors:\n%s", prompt)
+	}
+	for _, blockType := range cli.RunnerTypes() {
+		runner, _ := cli.LookupRunner(blockType)
+		if want := "- " + string(blockType) + ": " + runner.Explain(); !strings.Contains(prompt, want) {
+			t.Errorf("createWorkflowPrompt() is missing %q", want)
+		}
+	}
+	if want := "type: " + strings.Join(blockTypeNames(), "|"); !strings.Contains(prompt, want) {
+		t.Errorf("createWorkflowPrompt() is missing %q", want)
+	}
+}
+
+func TestHandleExecuteExistingWorkflowStdout(t *testing.T) {
+	server := NewServer(&config.Config{MCPName: "test-server", MCPVersion: "1.0.0"})
+	workflow := `logging:
+  - level: info
+  - output: stdout
+cmd:
+  - type: exec
+    name: greet
+    values: [echo, hello]
+`
+
+	// Stdout carries the MCP messages, anything else written to it breaks
+	// the protocol
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	result, runErr := server.handleExecuteExistingWorkflow(map[string]interface{}{"yaml_content": workflow})
+	planResult, planErr := server.handleExecuteExistingWorkflow(map[string]interface{}{"yaml_content": workflow, "dry_run": true})
+	os.Stdout = stdout
+	writer.Close()
+	written, _ := io.ReadAll(reader)
+
+	if runErr != nil || planErr != nil {
+		t.Fatalf("handleExecuteExistingWorkflow() unexpected errors: %v, %v", runErr, planErr)
+	}
+	if len(written) > 0 {
+		t.Errorf("handleExecuteExistingWorkflow() wrote %q to stdout", written)
+	}
+	if report := result.Content[2].Text; !strings.Contains(report, `"stdout": "hello\n"`) {
+		t.Errorf("report = %q, want the output of the block", report)
+	}
+	if plan := planResult.Content[1].Text; !strings.Contains(plan, "echo hello") {
+		t.Errorf("plan = %q, want the planned command", plan)
+	}
+}
+
+// containsValue reports whether values contains value
+func containsValue(values []interface{}, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,18 @@
 package mcp
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
 	"github.com/lanixx/runfromyaml/pkg/cli"
+	rfyerrors "github.com/lanixx/runfromyaml/pkg/errors"
 )
 
 // registerTools registers all available MCP tools
@@ -23,7 +29,7 @@ func (s *MCPServer) registerTools() {
 				},
 				"dry_run": map[string]interface{}{
 					"type":        "boolean",
-					"description": "Only generate the workflow without executing it",
+					"description": "Generate the workflow and show the exact commands and file changes it would make without executing it",
 					"default":     false,
 				},
 			},
@@ -58,6 +64,29 @@ func (s *MCPServer) registerTools() {
 					"type":        "string",
 					"description": "YAML workflow content to execute",
 				},
+				"dry_run": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Show the exact commands and file changes of the workflow without executing it",
+					"default":     false,
+				},
+				"only": map[string]interface{}{
+					"type":        "array",
+					"items":       map[string]interface{}{"type": "string"},
+					"description": "Names, tags or 1-based indexes of the only command blocks to run",
+				},
+				"skip": map[string]interface{}{
+					"type":        "array",
+					"items":       map[string]interface{}{"type": "string"},
+					"description": "Names, tags or 1-based indexes of command blocks to leave out",
+				},
+				"from": map[string]interface{}{
+					"type":        "string",
+					"description": "Name or 1-based index of the command block to start the run with",
+				},
+				"until": map[string]interface{}{
+					"type":        "string",
+					"description": "Name or 1-based index of the last command block to run",
+				},
 			},
 			"required": []string{"yaml_content"},
 		},
@@ -176,34 +205,133 @@ func (s *MCPServer) handleGenerateAndExecuteWorkflow(args map[string]interface{}
 	yamlContent := string(yamlBytes)
 
 	if dryRun {
+		plan, err := planWorkflow(yamlBytes, s.config.Debug, cli.Selection{})
+		if err != nil {
+			return &ToolResult{
+				Content: []Content{
+					{Type: "text", Text: "🤖 AI-Generated workflow (dry run - not executed):"},
+					{Type: "text", Text: "```yaml\n" + yamlContent + "\n```"},
+					{Type: "text", Text: fmt.Sprintf("Dry run failed: %v", err)},
+				},
+				IsError: true,
+			}, err
+		}
 		return &ToolResult{
 			Content: []Content{
 				{Type: "text", Text: "🤖 AI-Generated workflow (dry run - not executed):"},
 				{Type: "text", Text: "```yaml\n" + yamlContent + "\n```"},
+				{Type: "text", Text: "Planned commands and file changes:"},
+				{Type: "text", Text: "```\n" + plan + "```"},
 			},
 		}, nil
 	}
 
 	// Execute workflow
-	err = cli.Runfromyaml(yamlBytes, s.config.Debug)
+	report, err := cli.RunfromyamlWithReport(context.Background(), yamlBytes, discardOutput(cli.RunOptions{Debug: s.config.Debug, Parallel: 1}))
 	if err != nil {
-		return &ToolResult{
-			Content: []Content{
-				{Type: "text", Text: "🤖 AI-Generated workflow:"},
-				{Type: "text", Text: "```yaml\n" + yamlContent + "\n```"},
-				{Type: "text", Text: fmt.Sprintf("Execution failed: %v", err)},
-			},
-			IsError: true,
-		}, err
+		content := []Content{
+			{Type: "text", Text: "🤖 AI-Generated workflow:"},
+			{Type: "text", Text: "```yaml\n" + yamlContent + "\n```"},
+			{Type: "text", Text: fmt.Sprintf("Execution failed: %s", describeError(err))},
+		}
+		return &ToolResult{Content: append(content, reportContent(report)...), IsError: true}, err
 	}
 
-	return &ToolResult{
-		Content: []Content{
-			{Type: "text", Text: "✅ AI-Generated workflow executed successfully!"},
-			{Type: "text", Text: "Generated workflow:"},
-			{Type: "text", Text: "```yaml\n" + yamlContent + "\n```"},
-		},
-	}, nil
+	content := []Content{
+		{Type: "text", Text: "✅ AI-Generated workflow executed successfully!"},
+		{Type: "text", Text: "Generated workflow:"},
+		{Type: "text", Text: "```yaml\n" + yamlContent + "\n```"},
+	}
+	return &ToolResult{Content: append(content, reportContent(report)...)}, nil
+}
+
+// planWorkflow runs a workflow in dry-run mode and returns the commands and
+// file changes it would make
+func planWorkflow(yamlBytes []byte, debug bool, selection cli.Selection) (string, error) {
+	var plan strings.Builder
+	err := cli.RunfromyamlWithOptions(context.Background(), yamlBytes, discardOutput(cli.RunOptions{
+		Debug:     debug,
+		Parallel:  1,
+		DryRun:    true,
+		Plan:      &plan,
+		Selection: selection,
+	}))
+	return plan.String(), err
+}
+
+// discardOutput keeps the output of a run off the stdio of the server, which
+// carries the MCP messages, whatever the logging section of the workflow
+// says. The output of the blocks is part of the run report.
+func discardOutput(opts cli.RunOptions) cli.RunOptions {
+	opts.Stdout = io.Discard
+	opts.Stderr = io.Discard
+	opts.Log = io.Discard
+	return opts
+}
+
+// selectionFromArgs reads the only, skip, from and until tool arguments
+func selectionFromArgs(args map[string]interface{}) cli.Selection {
+	selection := cli.Selection{
+		Only: stringArgs(args["only"]),
+		Skip: stringArgs(args["skip"]),
+	}
+	selection.From, _ = args["from"].(string)
+	selection.Until, _ = args["until"].(string)
+	return selection
+}
+
+// stringArgs converts a list argument, or a comma separated string, into a
+// list of strings
+func stringArgs(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return cli.SplitSelection(v)
+	case []interface{}:
+		var result []string
+		for _, item := range v {
+			result = append(result, fmt.Sprint(item))
+		}
+		return result
+	}
+	return nil
+}
+
+// describeError returns the message of err including the suggestions of
+// structured errors
+func describeError(err error) string {
+	rfyErr, ok := err.(*rfyerrors.RunFromYAMLError)
+	if !ok {
+		return err.Error()
+	}
+	return rfyErr.MessageWithSuggestions()
+}
+
+// reportContent describes the outcome of every block of a run, with the full
+// report as JSON. It is empty without a report.
+func reportContent(report *cli.RunReport) []Content {
+	if report == nil {
+		return nil
+	}
+
+	passed, failed, skipped := report.Counts()
+	lines := []string{fmt.Sprintf("Run report: %d passed, %d failed, %d skipped in %s",
+		passed, failed, skipped, report.Duration.Round(time.Millisecond))}
+	for _, block := range report.Blocks {
+		line := fmt.Sprintf("- %s %d (%s): %s", block.Section, block.Index+1, block.Name, block.Status)
+		if block.ExitCode != nil {
+			line += fmt.Sprintf(", exit code %d", *block.ExitCode)
+		}
+		if block.Reason != "" {
+			line += " - " + block.Reason
+		}
+		lines = append(lines, line)
+	}
+
+	content := []Content{{Type: "text", Text: strings.Join(lines, "\n")}}
+	if data, err := json.MarshalIndent(report, "", "  "); err == nil {
+		content = append(content, Content{Type: "text", Text: "```json\n" + string(data) + "\n```"})
+	}
+	return content
 }
 
 // handleGenerateWorkflow generates a workflow without executing
@@ -252,26 +380,47 @@ func (s *MCPServer) handleExecuteExistingWorkflow(args map[string]interface{}) (
 		}, fmt.Errorf("missing or invalid yaml_content")
 	}
 
-	// Execute workflow
-	err := cli.Runfromyaml([]byte(yamlContent), s.config.Debug)
-	if err != nil {
+	selection := selectionFromArgs(args)
+
+	if dryRun, _ := args["dry_run"].(bool); dryRun {
+		plan, err := planWorkflow([]byte(yamlContent), s.config.Debug, selection)
+		if err != nil {
+			return &ToolResult{
+				Content: []Content{{Type: "text", Text: fmt.Sprintf("Dry run failed: %s", describeError(err))}},
+				IsError: true,
+			}, err
+		}
 		return &ToolResult{
 			Content: []Content{
-				{Type: "text", Text: fmt.Sprintf("Workflow execution failed: %v", err)},
-				{Type: "text", Text: "Workflow content:"},
-				{Type: "text", Text: "```yaml\n" + yamlContent + "\n```"},
+				{Type: "text", Text: "Workflow dry run - nothing was executed. Planned commands and file changes:"},
+				{Type: "text", Text: "```\n" + plan + "```"},
 			},
-			IsError: true,
-		}, err
+		}, nil
 	}
 
-	return &ToolResult{
-		Content: []Content{
-			{Type: "text", Text: "✅ Workflow executed successfully!"},
-			{Type: "text", Text: "Executed workflow:"},
-			{Type: "text", Text: "```yaml\n" + yamlContent + "\n```"},
-		},
-	}, nil
+	// Execute workflow
+	report, err := cli.RunfromyamlWithReport(context.Background(), []byte(yamlContent), discardOutput(cli.RunOptions{
+		Debug:     s.config.Debug,
+		Parallel:  1,
+		Selection: selection,
+	}))
+	if err != nil {
+		content := []Content{{Type: "text", Text: fmt.Sprintf("Workflow execution failed: %s", describeError(err))}}
+		content = append(content, reportContent(report)...)
+		content = append(content,
+			Content{Type: "text", Text: "Workflow content:"},
+			Content{Type: "text", Text: "```yaml\n" + yamlContent + "\n```"},
+		)
+		return &ToolResult{Content: content, IsError: true}, err
+	}
+
+	content := []Content{{Type: "text", Text: "✅ Workflow executed successfully!"}}
+	content = append(content, reportContent(report)...)
+	content = append(content,
+		Content{Type: "text", Text: "Executed workflow:"},
+		Content{Type: "text", Text: "```yaml\n" + yamlContent + "\n```"},
+	)
+	return &ToolResult{Content: content}, nil
 }
 
 // handleValidateWorkflow validates a workflow
@@ -284,18 +433,22 @@ func (s *MCPServer) handleValidateWorkflow(args map[string]interface{}) (*ToolRe
 		}, fmt.Errorf("missing or invalid yaml_content")
 	}
 
-	// Validate YAML structure
-	var workflow map[interface{}]interface{}
-	err := yaml.Unmarshal([]byte(yamlContent), &workflow)
-	if err != nil {
+	// Decode the workflow, unknown keys and wrong types are listed with
+	// their position
+	var validationErrors []string
+	workflow, err := cli.ParseWorkflow([]byte(yamlContent), "")
+	var decodeErr *cli.DecodeError
+	switch {
+	case errors.As(err, &decodeErr):
+		validationErrors = validationProblems(err)
+	case err != nil:
 		return &ToolResult{
 			Content: []Content{{Type: "text", Text: fmt.Sprintf("❌ Invalid YAML syntax: %v", err)}},
 			IsError: true,
 		}, err
+	default:
+		validationErrors = s.validateWorkflowStructure(workflow, []byte(yamlContent))
 	}
-
-	// Basic validation
-	validationErrors := s.validateWorkflowStructure(workflow)
 	if len(validationErrors) > 0 {
 		errorText := "❌ Workflow validation failed:\n"
 		for _, err := range validationErrors {
@@ -323,8 +476,7 @@ func (s *MCPServer) handleExplainWorkflow(args map[string]interface{}) (*ToolRes
 	}
 
 	// Parse YAML
-	var workflow map[interface{}]interface{}
-	err := yaml.Unmarshal([]byte(yamlContent), &workflow)
+	workflow, err := cli.ParseWorkflow([]byte(yamlContent), "")
 	if err != nil {
 		return &ToolResult{
 			Content: []Content{{Type: "text", Text: fmt.Sprintf("Error parsing YAML: %v", err)}},
@@ -431,32 +583,27 @@ func (s *MCPServer) handleImproveWorkflow(args map[string]interface{}) (*ToolRes
 	}, nil
 }
 
-// generateWorkflowFromDescription generates a workflow from natural language description
-func (s *MCPServer) generateWorkflowFromDescription(description string) (map[string]interface{}, error) {
-	workflow := map[string]interface{}{
-		"logging": []map[string]interface{}{
-			{"level": "info"},
-			{"output": "stdout"},
-		},
-		"cmd": []map[string]interface{}{},
-	}
-
-	// Analyze description and generate blocks
-	blocks := s.analyzeAndGenerateBlocks(description)
-	workflow["cmd"] = blocks
+// defaultLogging is the logging section of generated workflows
+func defaultLogging() []cli.LoggingEntry {
+	return []cli.LoggingEntry{{Level: "info"}, {Output: "stdout"}}
+}
 
-	// Add environment variables if needed
-	envVars := s.extractEnvironmentVariables(description)
-	if len(envVars) > 0 {
-		workflow["env"] = envVars
+// generateWorkflowFromDescription generates a workflow from natural language description
+func (s *MCPServer) generateWorkflowFromDescription(description string) (*cli.Workflow, error) {
+	workflow := &cli.Workflow{
+		Logging: defaultLogging(),
+		// Analyze description and generate blocks
+		Cmd: s.analyzeAndGenerateBlocks(description),
+		// Add environment variables if needed
+		Env: s.extractEnvironmentVariables(description),
 	}
 
 	return workflow, nil
 }
 
 // analyzeAndGenerateBlocks analyzes description and generates appropriate command blocks
-func (s *MCPServer) analyzeAndGenerateBlocks(description string) []map[string]interface{} {
-	var blocks []map[string]interface{}
+func (s *MCPServer) analyzeAndGenerateBlocks(description string) []cli.Block {
+	var blocks []cli.Block
 	desc := strings.ToLower(description)
 
 	// Docker-related workflows
@@ -497,229 +644,205 @@ func (s *MCPServer) analyzeAndGenerateBlocks(description string) []map[string]in
 }
 
 // Block generation methods
-func (s *MCPServer) generateDockerComposeBlock(description string) map[string]interface{} {
-	return map[string]interface{}{
-		"type":       "docker-compose",
-		"name":       "docker-compose-setup",
-		"desc":       "Docker Compose setup based on: " + description,
-		"expandenv":  true,
-		"dcoptions":  []string{"-f", "docker-compose.yml"},
-		"command":    "up",
-		"cmdoptions": []string{"-d"},
-		"service":    "",
-		"values":     []string{},
+func (s *MCPServer) generateDockerComposeBlock(description string) cli.Block {
+	return cli.Block{
+		Type:      "docker-compose",
+		Name:      "docker-compose-setup",
+		Desc:      "Docker Compose setup based on: " + description,
+		ExpandEnv: true,
+		Options: map[string]interface{}{
+			"dcoptions":  []string{"-f", "docker-compose.yml"},
+			"command":    "up",
+			"cmdoptions": []string{"-d"},
+		},
 	}
 }
 
-func (s *MCPServer) generateDockerBlock(description string) map[string]interface{} {
-	return map[string]interface{}{
-		"type":      "docker",
-		"name":      "docker-setup",
-		"desc":      "Docker setup based on: " + description,
-		"expandenv": true,
-		"command":   "run",
-		"container": "alpine:latest",
-		"values":    []string{"echo 'Docker container started'", "uname -a"},
+func (s *MCPServer) generateDockerBlock(description string) cli.Block {
+	return cli.Block{
+		Type:      "docker",
+		Name:      "docker-setup",
+		Desc:      "Docker setup based on: " + description,
+		ExpandEnv: true,
+		Values:    cli.StringList{"echo 'Docker container started'", "uname -a"},
+		Options: map[string]interface{}{
+			"command":   "run",
+			"container": "alpine:latest",
+		},
 	}
 }
 
-func (s *MCPServer) generateDatabaseSetupBlocks(description string) []map[string]interface{} {
-	var blocks []map[string]interface{}
+func (s *MCPServer) generateDatabaseSetupBlocks(description string) []cli.Block {
+	var blocks []cli.Block
 
 	// Database configuration
 	if strings.Contains(strings.ToLower(description), "postgres") {
-		blocks = append(blocks, map[string]interface{}{
-			"type":     "conf",
-			"name":     "postgres-config",
-			"desc":     "PostgreSQL configuration",
-			"confdest": "./postgres.conf",
-			"confperm": 0644,
-			"confdata": "# PostgreSQL Configuration\nport = 5432\nmax_connections = 100\n",
+		blocks = append(blocks, cli.Block{
+			Type: "conf",
+			Name: "postgres-config",
+			Desc: "PostgreSQL configuration",
+			Options: map[string]interface{}{
+				"confdest": "./postgres.conf",
+				"confperm": 0644,
+				"confdata": "# PostgreSQL Configuration\nport = 5432\nmax_connections = 100\n",
+			},
 		})
 	}
 
 	// Database setup commands
-	blocks = append(blocks, map[string]interface{}{
-		"type":      "shell",
-		"name":      "database-setup",
-		"desc":      "Database setup commands",
-		"expandenv": true,
-		"values":    []string{"echo 'Setting up database'", "# Add your database setup commands here"},
+	blocks = append(blocks, cli.Block{
+		Type:      "shell",
+		Name:      "database-setup",
+		Desc:      "Database setup commands",
+		ExpandEnv: true,
+		Values:    cli.StringList{"echo 'Setting up database'", "# Add your database setup commands here"},
 	})
 
 	return blocks
 }
 
-func (s *MCPServer) generateWebAppBlocks(description string) []map[string]interface{} {
-	var blocks []map[string]interface{}
-
-	// Web server configuration
-	blocks = append(blocks, map[string]interface{}{
-		"type":     "conf",
-		"name":     "web-config",
-		"desc":     "Web application configuration",
-		"confdest": "./app.conf",
-		"confperm": 0644,
-		"confdata": "# Web Application Configuration\nport=8080\nhost=0.0.0.0\n",
-	})
-
-	// Web app setup
-	blocks = append(blocks, map[string]interface{}{
-		"type":      "shell",
-		"name":      "web-app-setup",
-		"desc":      "Web application setup",
-		"expandenv": true,
-		"values":    []string{"echo 'Setting up web application'", "# Add your web app setup commands here"},
-	})
-
-	return blocks
+func (s *MCPServer) generateWebAppBlocks(description string) []cli.Block {
+	return []cli.Block{
+		// Web server configuration
+		{
+			Type: "conf",
+			Name: "web-config",
+			Desc: "Web application configuration",
+			Options: map[string]interface{}{
+				"confdest": "./app.conf",
+				"confperm": 0644,
+				"confdata": "# Web Application Configuration\nport=8080\nhost=0.0.0.0\n",
+			},
+		},
+		// Web app setup
+		{
+			Type:      "shell",
+			Name:      "web-app-setup",
+			Desc:      "Web application setup",
+			ExpandEnv: true,
+			Values:    cli.StringList{"echo 'Setting up web application'", "# Add your web app setup commands here"},
+		},
+	}
 }
 
-func (s *MCPServer) generateConfigBlocks(description string) []map[string]interface{} {
-	return []map[string]interface{}{
+func (s *MCPServer) generateConfigBlocks(description string) []cli.Block {
+	return []cli.Block{
 		{
-			"type":     "conf",
-			"name":     "generated-config",
-			"desc":     "Generated configuration file",
-			"confdest": "./generated.conf",
-			"confperm": 0644,
-			"confdata": "# Generated Configuration\n# Based on: " + description + "\n",
+			Type: "conf",
+			Name: "generated-config",
+			Desc: "Generated configuration file",
+			Options: map[string]interface{}{
+				"confdest": "./generated.conf",
+				"confperm": 0644,
+				"confdata": "# Generated Configuration\n# Based on: " + description + "\n",
+			},
 		},
 	}
 }
 
-func (s *MCPServer) generateSSHBlocks(description string) []map[string]interface{} {
-	return []map[string]interface{}{
+func (s *MCPServer) generateSSHBlocks(description string) []cli.Block {
+	return []cli.Block{
 		{
-			"type":      "ssh",
-			"name":      "ssh-operation",
-			"desc":      "SSH remote operation",
-			"expandenv": true,
-			"user":      "$USER",
-			"host":      "localhost",
-			"port":      22,
-			"options":   []string{"-o", "ConnectTimeout=5"},
-			"values":    []string{"echo 'SSH connection established'", "uname -a"},
+			Type:      "ssh",
+			Name:      "ssh-operation",
+			Desc:      "SSH remote operation",
+			ExpandEnv: true,
+			Values:    cli.StringList{"echo 'SSH connection established'", "uname -a"},
+			Options: map[string]interface{}{
+				"user":    "$USER",
+				"host":    "localhost",
+				"port":    22,
+				"options": []string{"-o", "ConnectTimeout=5"},
+			},
 		},
 	}
 }
 
-func (s *MCPServer) generateGenericShellBlock(description string) map[string]interface{} {
-	return map[string]interface{}{
-		"type":      "shell",
-		"name":      "generated-commands",
-		"desc":      "Generated commands based on: " + description,
-		"expandenv": true,
-		"values":    []string{"echo 'Executing generated workflow'", "# Add specific commands based on your requirements"},
+func (s *MCPServer) generateGenericShellBlock(description string) cli.Block {
+	return cli.Block{
+		Type:      "shell",
+		Name:      "generated-commands",
+		Desc:      "Generated commands based on: " + description,
+		ExpandEnv: true,
+		Values:    cli.StringList{"echo 'Executing generated workflow'", "# Add specific commands based on your requirements"},
 	}
 }
 
 // extractEnvironmentVariables extracts environment variables from description
-func (s *MCPServer) extractEnvironmentVariables(description string) []map[string]interface{} {
-	var envVars []map[string]interface{}
+func (s *MCPServer) extractEnvironmentVariables(description string) []cli.EnvEntry {
+	var envVars []cli.EnvEntry
 
 	// Add common environment variables based on description
 	if strings.Contains(strings.ToLower(description), "database") {
-		envVars = append(envVars, map[string]interface{}{
-			"key":   "DB_HOST",
-			"value": "localhost",
-		})
-		envVars = append(envVars, map[string]interface{}{
-			"key":   "DB_PORT",
-			"value": "5432",
-		})
+		envVars = append(envVars, cli.EnvEntry{Key: "DB_HOST", Value: "localhost"})
+		envVars = append(envVars, cli.EnvEntry{Key: "DB_PORT", Value: "5432"})
 	}
 
 	if strings.Contains(strings.ToLower(description), "web") || strings.Contains(strings.ToLower(description), "app") {
-		envVars = append(envVars, map[string]interface{}{
-			"key":   "APP_PORT",
-			"value": "8080",
-		})
+		envVars = append(envVars, cli.EnvEntry{Key: "APP_PORT", Value: "8080"})
 	}
 
 	return envVars
 }
 
-// validateWorkflowStructure validates the structure of a workflow
-func (s *MCPServer) validateWorkflowStructure(workflow map[interface{}]interface{}) []string {
+// validateWorkflowStructure validates the structure of a decoded workflow
+func (s *MCPServer) validateWorkflowStructure(workflow *cli.Workflow, yamlContent []byte) []string {
 	var errors []string
 
 	// Check for cmd section
-	if _, exists := workflow["cmd"]; !exists {
+	if len(workflow.Cmd) == 0 {
 		errors = append(errors, "Missing 'cmd' section")
-		return errors
-	}
-
-	// Validate cmd blocks
-	if cmdBlocks, ok := workflow["cmd"].([]interface{}); ok {
-		for i, block := range cmdBlocks {
-			if blockMap, ok := block.(map[interface{}]interface{}); ok {
-				if _, exists := blockMap["type"]; !exists {
-					errors = append(errors, fmt.Sprintf("Command block %d: missing 'type' field", i+1))
-				}
-			} else {
-				errors = append(errors, fmt.Sprintf("Command block %d: invalid format", i+1))
-			}
-		}
-	} else {
-		errors = append(errors, "'cmd' section must be an array")
+	}
+
+	// The blocks, includes and settings are checked like before a run
+	if err := cli.ValidateWorkflow(yamlContent, discardOutput(cli.RunOptions{Debug: s.config.Debug})); err != nil {
+		errors = append(errors, validationProblems(err)...)
 	}
 
 	return errors
 }
 
+// validationProblems lists the problems of a failed validation, decode
+// problems one by one with their position
+func validationProblems(err error) []string {
+	var decodeErr *cli.DecodeError
+	if !errors.As(err, &decodeErr) {
+		return []string{describeError(err)}
+	}
+	problems := make([]string, len(decodeErr.Problems))
+	for i, problem := range decodeErr.Problems {
+		problems[i] = problem.String()
+	}
+	return problems
+}
+
 // explainWorkflow generates an explanation of what the workflow will do
-func (s *MCPServer) explainWorkflow(workflow map[interface{}]interface{}) string {
+func (s *MCPServer) explainWorkflow(workflow *cli.Workflow) string {
 	explanation := "This workflow will perform the following actions:\n\n"
 
 	// Explain environment variables
-	if envVars, exists := workflow["env"]; exists {
-		if envList, ok := envVars.([]interface{}); ok && len(envList) > 0 {
-			explanation += "🔧 Environment Setup:\n"
-			for _, env := range envList {
-				if envMap, ok := env.(map[interface{}]interface{}); ok {
-					key := envMap["key"]
-					value := envMap["value"]
-					explanation += fmt.Sprintf("   - Set %v = %v\n", key, value)
-				}
-			}
-			explanation += "\n"
+	if len(workflow.Env) > 0 {
+		explanation += "🔧 Environment Setup:\n"
+		for _, env := range workflow.Env {
+			explanation += fmt.Sprintf("   - Set %s = %s\n", env.Key, env.Value)
 		}
+		explanation += "\n"
 	}
 
 	// Explain command blocks
-	if cmdBlocks, exists := workflow["cmd"]; exists {
-		if cmdList, ok := cmdBlocks.([]interface{}); ok {
-			explanation += "📋 Command Execution:\n"
-			for i, block := range cmdList {
-				if blockMap, ok := block.(map[interface{}]interface{}); ok {
-					blockType := blockMap["type"]
-					name := blockMap["name"]
-					desc := blockMap["desc"]
-
-					explanation += fmt.Sprintf("%d. %s (%s)\n", i+1, name, blockType)
-					if desc != nil {
-						explanation += fmt.Sprintf("   Description: %v\n", desc)
-					}
-
-					// Add type-specific explanations
-					switch blockType {
-					case "shell":
-						explanation += "   - Execute shell commands\n"
-					case "docker":
-						explanation += "   - Run Docker container operations\n"
-					case "docker-compose":
-						explanation += "   - Execute Docker Compose operations\n"
-					case "ssh":
-						explanation += "   - Execute commands on remote server via SSH\n"
-					case "conf":
-						explanation += "   - Create configuration file\n"
-					case "exec":
-						explanation += "   - Execute system commands directly\n"
-					}
-					explanation += "\n"
-				}
+	if len(workflow.Cmd) > 0 {
+		explanation += "📋 Command Execution:\n"
+		for i, block := range workflow.Cmd {
+			explanation += fmt.Sprintf("%d. %s (%s)\n", i+1, block.Name, block.Type)
+			if block.Desc != "" {
+				explanation += fmt.Sprintf("   Description: %s\n", block.Desc)
+			}
+
+			// Add type-specific explanations
+			if runner, ok := cli.LookupRunner(cli.CommandType(block.Type)); ok {
+				explanation += fmt.Sprintf("   - %s\n", runner.Explain())
 			}
+			explanation += "\n"
 		}
 	}
 
@@ -727,7 +850,7 @@ func (s *MCPServer) explainWorkflow(workflow map[interface{}]interface{}) string
 }
 
 // generateWorkflowFromTemplate generates workflow from a predefined template
-func (s *MCPServer) generateWorkflowFromTemplate(templateName string, parameters map[string]interface{}) (map[string]interface{}, error) {
+func (s *MCPServer) generateWorkflowFromTemplate(templateName string, parameters map[string]interface{}) (*cli.Workflow, error) {
 	switch templateName {
 	case "web-app":
 		return s.generateWebAppTemplate(parameters), nil
@@ -742,146 +865,93 @@ func (s *MCPServer) generateWorkflowFromTemplate(templateName string, parameters
 	}
 }
 
+// shellBlock returns a shell block with variable expansion
+func shellBlock(name, desc string, values ...string) cli.Block {
+	return cli.Block{Type: "shell", Name: name, Desc: desc, ExpandEnv: true, Values: values}
+}
+
 // Template generators
-func (s *MCPServer) generateWebAppTemplate(params map[string]interface{}) map[string]interface{} {
+func (s *MCPServer) generateWebAppTemplate(params map[string]interface{}) *cli.Workflow {
 	port := "8080"
 	if p, ok := params["port"].(string); ok {
 		port = p
 	}
 
-	return map[string]interface{}{
-		"logging": []map[string]interface{}{
-			{"level": "info"},
-			{"output": "stdout"},
+	return &cli.Workflow{
+		Logging: defaultLogging(),
+		Env: []cli.EnvEntry{
+			{Key: "APP_PORT", Value: port},
+			{Key: "NODE_ENV", Value: "production"},
 		},
-		"env": []map[string]interface{}{
-			{"key": "APP_PORT", "value": port},
-			{"key": "NODE_ENV", "value": "production"},
-		},
-		"cmd": []map[string]interface{}{
-			{
-				"type":      "shell",
-				"name":      "install-dependencies",
-				"desc":      "Install application dependencies",
-				"expandenv": true,
-				"values":    []string{"npm install"},
-			},
-			{
-				"type":      "shell",
-				"name":      "build-app",
-				"desc":      "Build the application",
-				"expandenv": true,
-				"values":    []string{"npm run build"},
-			},
-			{
-				"type":      "shell",
-				"name":      "start-app",
-				"desc":      "Start the web application",
-				"expandenv": true,
-				"values":    []string{"npm start"},
-			},
+		Cmd: []cli.Block{
+			shellBlock("install-dependencies", "Install application dependencies", "npm install"),
+			shellBlock("build-app", "Build the application", "npm run build"),
+			shellBlock("start-app", "Start the web application", "npm start"),
 		},
 	}
 }
 
-func (s *MCPServer) generateDatabaseTemplate(params map[string]interface{}) map[string]interface{} {
+func (s *MCPServer) generateDatabaseTemplate(params map[string]interface{}) *cli.Workflow {
 	dbType := "postgresql"
 	if db, ok := params["database_type"].(string); ok {
 		dbType = db
 	}
 
-	return map[string]interface{}{
-		"logging": []map[string]interface{}{
-			{"level": "info"},
-			{"output": "stdout"},
-		},
-		"env": []map[string]interface{}{
-			{"key": "DB_TYPE", "value": dbType},
-			{"key": "DB_HOST", "value": "localhost"},
-			{"key": "DB_PORT", "value": "5432"},
+	return &cli.Workflow{
+		Logging: defaultLogging(),
+		Env: []cli.EnvEntry{
+			{Key: "DB_TYPE", Value: dbType},
+			{Key: "DB_HOST", Value: "localhost"},
+			{Key: "DB_PORT", Value: "5432"},
 		},
-		"cmd": []map[string]interface{}{
-			{
-				"type":       "docker-compose",
-				"name":       "start-database",
-				"desc":       "Start database with Docker Compose",
-				"expandenv":  true,
-				"dcoptions":  []string{"-f", "docker-compose.db.yml"},
-				"command":    "up",
-				"cmdoptions": []string{"-d"},
-				"service":    "",
-				"values":     []string{},
-			},
+		Cmd: []cli.Block{
 			{
-				"type":      "shell",
-				"name":      "wait-for-db",
-				"desc":      "Wait for database to be ready",
-				"expandenv": true,
-				"values":    []string{"sleep 10", "echo 'Database should be ready'"},
+				Type:      "docker-compose",
+				Name:      "start-database",
+				Desc:      "Start database with Docker Compose",
+				ExpandEnv: true,
+				Options: map[string]interface{}{
+					"dcoptions":  []string{"-f", "docker-compose.db.yml"},
+					"command":    "up",
+					"cmdoptions": []string{"-d"},
+				},
 			},
+			shellBlock("wait-for-db", "Wait for database to be ready", "sleep 10", "echo 'Database should be ready'"),
 		},
 	}
 }
 
-func (s *MCPServer) generateCICDTemplate(params map[string]interface{}) map[string]interface{} {
-	return map[string]interface{}{
-		"logging": []map[string]interface{}{
-			{"level": "info"},
-			{"output": "stdout"},
-		},
-		"cmd": []map[string]interface{}{
-			{
-				"type":      "shell",
-				"name":      "checkout-code",
-				"desc":      "Checkout source code",
-				"expandenv": true,
-				"values":    []string{"git pull origin main"},
-			},
-			{
-				"type":      "shell",
-				"name":      "run-tests",
-				"desc":      "Run test suite",
-				"expandenv": true,
-				"values":    []string{"npm test"},
-			},
-			{
-				"type":      "shell",
-				"name":      "build-application",
-				"desc":      "Build application",
-				"expandenv": true,
-				"values":    []string{"npm run build"},
-			},
-			{
-				"type":      "shell",
-				"name":      "deploy",
-				"desc":      "Deploy application",
-				"expandenv": true,
-				"values":    []string{"echo 'Deploying application'", "# Add deployment commands"},
-			},
+func (s *MCPServer) generateCICDTemplate(params map[string]interface{}) *cli.Workflow {
+	return &cli.Workflow{
+		Logging: defaultLogging(),
+		Cmd: []cli.Block{
+			shellBlock("checkout-code", "Checkout source code", "git pull origin main"),
+			shellBlock("run-tests", "Run test suite", "npm test"),
+			shellBlock("build-application", "Build application", "npm run build"),
+			shellBlock("deploy", "Deploy application", "echo 'Deploying application'", "# Add deployment commands"),
 		},
 	}
 }
 
-func (s *MCPServer) generateDockerTemplate(params map[string]interface{}) map[string]interface{} {
+func (s *MCPServer) generateDockerTemplate(params map[string]interface{}) *cli.Workflow {
 	image := "alpine:latest"
 	if img, ok := params["image"].(string); ok {
 		image = img
 	}
 
-	return map[string]interface{}{
-		"logging": []map[string]interface{}{
-			{"level": "info"},
-			{"output": "stdout"},
-		},
-		"cmd": []map[string]interface{}{
+	return &cli.Workflow{
+		Logging: defaultLogging(),
+		Cmd: []cli.Block{
 			{
-				"type":      "docker",
-				"name":      "run-container",
-				"desc":      "Run Docker container",
-				"expandenv": true,
-				"command":   "run",
-				"container": image,
-				"values":    []string{"echo 'Container started'", "uname -a", "ls -la"},
+				Type:      "docker",
+				Name:      "run-container",
+				Desc:      "Run Docker container",
+				ExpandEnv: true,
+				Values:    cli.StringList{"echo 'Container started'", "uname -a", "ls -la"},
+				Options: map[string]interface{}{
+					"command":   "run",
+					"container": image,
+				},
 			},
 		},
 	}
@@ -1,7 +1,7 @@
 package restapi
 
 import (
-	"fmt"
+	"encoding/json"
 	"io"
 	"net"
 	"net/http"
@@ -10,10 +10,9 @@ import (
 
 	auth "github.com/abbot/go-http-auth"
 	"golang.org/x/crypto/bcrypt"
-	"gopkg.in/yaml.v2"
 
 	"github.com/lanixx/runfromyaml/pkg/cli"
-	"github.com/lanixx/runfromyaml/pkg/functions"
+	rfyerrors "github.com/lanixx/runfromyaml/pkg/errors"
 )
 
 const (
@@ -115,41 +114,65 @@ func (s *Server) handleCommandAuth(w http.ResponseWriter, r *auth.AuthenticatedR
 
 // processRequest processes the request body and executes the YAML commands
 func (s *Server) processRequest(w http.ResponseWriter, r *http.Request, body []byte) error {
-	functions.RestOut = w
-	functions.ReqOut = r
-
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
 
-	if !s.config.Output {
-		_ = cli.Runfromyaml(body, false)
-		return nil
+	opts := cli.RunOptions{Parallel: 1}
+
+	// ?dry-run=true answers with the commands and file changes instead of
+	// running them
+	if dryRun, err := strconv.ParseBool(r.URL.Query().Get("dry-run")); err == nil && dryRun {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		opts.DryRun = true
+		opts.Plan = w
 	}
 
-	// Process YAML with output redirection
-	var ydoc map[interface{}]interface{}
-	if err := yaml.Unmarshal(body, &ydoc); err != nil {
-		return fmt.Errorf("failed to parse YAML: %w", err)
+	// ?only=a,b&skip=c&from=d&until=e select the command blocks to run
+	query := r.URL.Query()
+	opts.Selection = cli.Selection{
+		Only:  cli.SplitSelection(query.Get("only")),
+		Skip:  cli.SplitSelection(query.Get("skip")),
+		From:  query.Get("from"),
+		Until: query.Get("until"),
 	}
 
-	// Update logging output to REST
-	if logging, ok := ydoc["logging"].([]interface{}); ok {
-		for _, logEntry := range logging {
-			if entry, ok := logEntry.(map[interface{}]interface{}); ok {
-				if _, ok := entry["output"]; ok {
-					entry["output"] = "rest"
-				}
-			}
+	// The output of the blocks is part of the run report, whatever the
+	// logging section of the workflow says. Nothing is written to the stdio
+	// of the server or to its log file.
+	opts.Stdout = io.Discard
+	opts.Stderr = io.Discard
+	opts.Log = io.Discard
+
+	s.runWorkflow(w, r, body, opts)
+	return nil
+}
+
+// runWorkflow executes the workflow and answers with the run report. The
+// output of the blocks is only included with the Output option. Workflows
+// rejected before anything runs, like an invalid block selection, are
+// answered with a bad request.
+func (s *Server) runWorkflow(w http.ResponseWriter, r *http.Request, body []byte, opts cli.RunOptions) {
+	// Commands are killed when the client goes away
+	report, err := cli.RunfromyamlWithReport(r.Context(), body, opts)
+	if report == nil {
+		message := err.Error()
+		if rfyErr, ok := err.(*rfyerrors.RunFromYAMLError); ok {
+			message = rfyErr.MessageWithSuggestions()
 		}
+		http.Error(w, message, http.StatusBadRequest)
+		return
 	}
-
-	modifiedBody, err := yaml.Marshal(ydoc)
-	if err != nil {
-		return fmt.Errorf("failed to marshal modified YAML: %w", err)
+	if opts.DryRun {
+		return
 	}
 
-	_ = cli.Runfromyaml(modifiedBody, false)
-	return nil
+	if !s.config.Output {
+		for i := range report.Blocks {
+			report.Blocks[i].Stdout, report.Blocks[i].Stderr = "", ""
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(report)
 }
 
 // Legacy support for backward compatibility
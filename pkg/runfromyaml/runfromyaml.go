@@ -0,0 +1,197 @@
+// Package runfromyaml loads, validates and runs workflows from Go programs.
+//
+// Unlike the command line tool, a run writes the output of its commands and
+// its messages to the writers it is given, doesn't read the standard input
+// of the process and can be given its own environment:
+//
+//	workflow, err := runfromyaml.LoadFile("deploy.yaml")
+//	if err != nil {
+//		return err
+//	}
+//	report, err := workflow.Run(
+//		runfromyaml.WithContext(ctx),
+//		runfromyaml.WithStdout(&stdout),
+//		runfromyaml.WithEnv(map[string]string{"PATH": "/usr/bin:/bin"}),
+//	)
+package runfromyaml
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lanixx/runfromyaml/pkg/cli"
+)
+
+// Report describes the outcome of a run and of all its blocks
+type Report = cli.RunReport
+
+// BlockResult describes the outcome of a single block
+type BlockResult = cli.BlockResult
+
+// BlockStatus is the outcome of a block or of a whole run
+type BlockStatus = cli.BlockStatus
+
+const (
+	StatusSuccess = cli.BlockStatusSuccess
+	StatusFailed  = cli.BlockStatusFailed
+	StatusSkipped = cli.BlockStatusSkipped
+)
+
+// Event reports the progress of a run to the handler given by
+// WithEventHandler
+type Event = cli.Event
+
+// EventType identifies what an Event reports
+type EventType = cli.EventType
+
+const (
+	EventBlockStarted  = cli.EventBlockStarted
+	EventBlockFinished = cli.EventBlockFinished
+)
+
+// DecodeError lists the parts of a workflow that can't be decoded, like
+// unknown keys or values of the wrong type
+type DecodeError = cli.DecodeError
+
+// Problem is a single part of a workflow that can't be decoded, with its
+// position
+type Problem = cli.Problem
+
+// Workflow is a decoded workflow. It can be validated and run any number of
+// times, also concurrently.
+type Workflow struct {
+	data []byte
+	file string
+}
+
+// Load decodes a workflow. Problems with its content are reported as a
+// *DecodeError. Includes are resolved relative to the working directory.
+func Load(data []byte) (*Workflow, error) {
+	return load(data, "")
+}
+
+// LoadFile reads and decodes a workflow file like Load, its includes are
+// resolved relative to it
+func LoadFile(path string) (*Workflow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workflow: %w", err)
+	}
+	return load(data, path)
+}
+
+// load decodes a workflow, file names it in error messages
+func load(data []byte, file string) (*Workflow, error) {
+	if _, err := cli.ParseWorkflow(data, file); err != nil {
+		return nil, err
+	}
+	return &Workflow{data: data, file: file}, nil
+}
+
+// Validate checks the workflow and its includes like Run does before any
+// block runs. Nothing is executed.
+func (w *Workflow) Validate() error {
+	return cli.ValidateWorkflow(w.data, cli.RunOptions{File: w.file})
+}
+
+// Run executes the workflow and returns a report on every block. The report
+// is nil when the workflow is rejected before any block runs.
+func (w *Workflow) Run(opts ...Option) (*Report, error) {
+	s := settings{
+		ctx:      context.Background(),
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		parallel: 1,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	if s.log == nil {
+		s.log = s.stdout
+	}
+
+	return cli.RunfromyamlWithReport(s.ctx, w.data, cli.RunOptions{
+		File:     w.file,
+		Parallel: s.parallel,
+		DryRun:   s.dryRun,
+		Stdout:   s.stdout,
+		Stderr:   s.stderr,
+		Log:      s.log,
+		Env:      s.env,
+		OnEvent:  s.onEvent,
+	})
+}
+
+// settings holds the options of a run
+type settings struct {
+	ctx      context.Context
+	stdout   io.Writer
+	stderr   io.Writer
+	log      io.Writer
+	env      map[string]string
+	onEvent  func(Event)
+	parallel int
+	dryRun   bool
+}
+
+// Option configures a run
+type Option func(*settings)
+
+// WithContext runs the workflow with ctx. Cancelling it kills the running
+// commands and stops the cmd blocks, the on_failure and finally blocks still
+// run.
+func WithContext(ctx context.Context) Option {
+	return func(s *settings) { s.ctx = ctx }
+}
+
+// WithStdout sets the writer receiving the standard output of the started
+// commands, the standard output of the process by default
+func WithStdout(w io.Writer) Option {
+	return func(s *settings) { s.stdout = w }
+}
+
+// WithStderr sets the writer receiving the standard error of the started
+// commands, the standard error of the process by default
+func WithStderr(w io.Writer) Option {
+	return func(s *settings) { s.stderr = w }
+}
+
+// WithLog sets the writer receiving the messages of the run, like skipped
+// blocks and the summary. They go to the standard output writer by default.
+// The logging section of the workflow is ignored.
+func WithLog(w io.Writer) Option {
+	return func(s *settings) { s.log = w }
+}
+
+// WithEnv sets the variables the workflow starts with. The workflow and the
+// commands it starts don't see the environment of the process then, so vars
+// should usually include PATH.
+func WithEnv(vars map[string]string) Option {
+	return func(s *settings) {
+		s.env = make(map[string]string, len(vars))
+		for key, value := range vars {
+			s.env[key] = value
+		}
+	}
+}
+
+// WithEventHandler sets a function called for every event of the run. It is
+// called one event at a time, so it doesn't need to be safe for concurrent
+// use, but it should return quickly as blocks wait for it.
+func WithEventHandler(handler func(Event)) Option {
+	return func(s *settings) { s.onEvent = handler }
+}
+
+// WithParallel runs up to n independent cmd blocks concurrently, 1 by
+// default
+func WithParallel(n int) Option {
+	return func(s *settings) { s.parallel = n }
+}
+
+// WithDryRun reports the commands and file changes of every block to the
+// log writer instead of executing them
+func WithDryRun() Option {
+	return func(s *settings) { s.dryRun = true }
+}
@@ -0,0 +1,147 @@
+package runfromyaml
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	_, err := Load([]byte("cmd:\n  - type: shell\n    vaules: [echo hi]\n"))
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || len(decodeErr.Problems) != 1 || decodeErr.Problems[0].Line != 3 {
+		t.Errorf("Load() error = %v, want a *DecodeError for line 3", err)
+	}
+
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("LoadFile() expected error for a missing file")
+	}
+}
+
+func TestWorkflowValidate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "workflow.yaml")
+	data := "include: tasks.yaml\ncmd:\n  - type: shell\n    needs: build\n    values: \"true\"\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tasks.yaml"), []byte("cmd:\n  - type: shell\n    name: build\n    values: \"true\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	workflow, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() unexpected error: %v", err)
+	}
+	if err := workflow.Validate(); err != nil {
+		t.Errorf("Validate() unexpected error: %v", err)
+	}
+
+	workflow, err = Load([]byte(data))
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	if err := workflow.Validate(); err == nil {
+		t.Error("Validate() expected error for an include relative to the working directory")
+	}
+}
+
+func TestWorkflowRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+	t.Setenv("RFY_PROCESS", "leaked")
+
+	workflow, err := Load([]byte(`
+logging:
+  - output: rest
+env:
+  - key: GREETING
+    value: hello
+cmd:
+  - type: shell
+    name: greet
+    values:
+      - echo "$GREETING $NAME ${RFY_PROCESS:-unset}"; echo oops >&2
+`))
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+
+	var stdout, stderr, log strings.Builder
+	var events []Event
+	report, err := workflow.Run(
+		WithStdout(&stdout),
+		WithStderr(&stderr),
+		WithLog(&log),
+		WithEnv(map[string]string{"NAME": "world"}),
+		WithEventHandler(func(event Event) { events = append(events, event) }),
+	)
+	if err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+
+	if stdout.String() != "hello world unset\n" || stderr.String() != "oops\n" {
+		t.Errorf("stdout = %q, stderr = %q, want the output of the block", stdout.String(), stderr.String())
+	}
+	if !strings.Contains(log.String(), "# summary: 1 passed") {
+		t.Errorf("log = %q, want the summary", log.String())
+	}
+	if report.Status != StatusSuccess || len(report.Blocks) != 1 || report.Blocks[0].Stdout != "hello world unset\n" {
+		t.Errorf("report = %+v, want the successful block with its output", report)
+	}
+	if len(events) != 2 || events[0].Type != EventBlockStarted || events[1].Type != EventBlockFinished || events[1].Block.Status != StatusSuccess {
+		t.Errorf("events = %+v, want the block to start and succeed", events)
+	}
+}
+
+func TestWorkflowRunContext(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping bash based test on Windows")
+	}
+
+	workflow, err := Load([]byte("cmd:\n  - type: shell\n    values: sleep 10\nfinally:\n  - type: shell\n    values: echo cleanup\n"))
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var stdout strings.Builder
+	started := time.Now()
+	report, err := workflow.Run(WithContext(ctx), WithStdout(&stdout), WithLog(&strings.Builder{}))
+	if err == nil || report.Status != StatusFailed {
+		t.Errorf("Run() error = %v, want the cancelled block to fail the run", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Run() took %s, want the block to be killed", elapsed)
+	}
+	if stdout.String() != "cleanup\n" {
+		t.Errorf("stdout = %q, want the finally block to run", stdout.String())
+	}
+}
+
+func TestWorkflowRunDryRun(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "app.conf")
+	workflow, err := Load([]byte("cmd:\n  - type: conf\n    confdest: " + dest + "\n    confperm: 0644\n    confdata: port=80\n"))
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+
+	var log strings.Builder
+	if _, err := workflow.Run(WithDryRun(), WithLog(&log)); err != nil {
+		t.Fatalf("Run() unexpected error: %v", err)
+	}
+	if !strings.Contains(log.String(), "# [dry-run] would write "+dest) {
+		t.Errorf("log = %q, want the planned file", log.String())
+	}
+	if _, err := os.Stat(dest); err == nil {
+		t.Error("dry run wrote the file")
+	}
+}